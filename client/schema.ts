@@ -12,6 +12,7 @@ const post_schema = z.object({
     tags: z.array(z.string()),
     archived: z.boolean(),
     publish_at: z.string(),
+    version: z.number().optional(),
     created_at: z.string(),
     updated_at: z.string(),
     project_id: z.string().optional().nullable(),
@@ -34,13 +35,20 @@ const projects_response_schema = z.array(z.object({
 }));
 
 const category_schema = z.object({
+    id: z.number().optional(),
     name: z.string(),
-    parent: z.string()
+    parent: z.string(),
+    description: z.string().optional(),
+    display_order: z.number().optional(),
+    slug: z.string().optional(),
+    cover_image: z.string().optional(),
 });
 
 const category_node_schema = z.object({
     name: z.string(),
-    children: z.array(z.any())
+    children: z.array(z.any()),
+    post_count: z.number().optional(),
+    total_post_count: z.number().optional(),
 })
 
 const category_response = z.object({
@@ -50,7 +58,9 @@ const category_response = z.object({
 
 const access_key = z.object({
     id: z.number(),
-    value: z.string(),
+    prefix: z.string(),
+    // only sent when the token is created, after that only the prefix is known
+    value: z.string().optional(),
     user_id: z.number(),
     name: z.string(),
     note: z.string(),
@@ -59,6 +69,18 @@ const access_key = z.object({
     updated_at: z.string(),
 });
 
+const tag_schema = z.object({
+    id: z.number(),
+    name: z.string(),
+    description: z.string(),
+    colour: z.string(),
+    slug: z.string(),
+    count: z.number(),
+    first_used: z.string().nullable(),
+    last_used: z.string().nullable(),
+    weight: z.number().optional(),
+});
+
 const integration_link = z.object({
     id: z.number(),
     user_id: z.number(),
@@ -83,8 +105,10 @@ export type Category = z.infer<typeof category_schema>;
 
 export type IntegrationLink = z.infer<typeof integration_link>;
 
+export type Tag = z.infer<typeof tag_schema>;
+
 // i don't think there's a way for zod to gmo self-recursive types yet.
-export type CategoryNode = { name: string, children: CategoryNode[] };
+export type CategoryNode = { name: string, children: CategoryNode[], post_count?: number, total_post_count?: number };
 
 export type CategoryResponse = {
     categories: Category[]
@@ -99,6 +123,7 @@ export const SCHEMA = {
     CATEGORY_NODE: category_node_schema,
     CATEGORY_RESPONSE: category_response,
     ACCESS_KEY: access_key,
+    TAGS: z.array(tag_schema),
     PROJECTS_RESPONSE: projects_response_schema
 }
 
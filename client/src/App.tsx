@@ -87,7 +87,7 @@ function MainContent() {
 
   useEffect(() => {
     (async () => {
-      const response = await fetch(`${API_URL}/posts?limit=-1`, { credentials: "include" });
+      const response = await fetch(`${API_URL}/posts?limit=-1&fields=all`, { credentials: "include" });
       if (!response.ok) throw new Error("Couldn't fetch posts");
       const result = await response.json();
       setPosts(SCHEMA.POSTS_RESPONSE.parse(result));
@@ -97,8 +97,8 @@ function MainContent() {
       setCategories(SCHEMA.CATEGORY_RESPONSE.parse(await cat_res.json()));
 
       const tag_res = await fetch(`${API_URL}/tags`, { credentials: "include" });
-      const tag_result = await tag_res.json();
-      setTags(tag_result);
+      const tag_result = SCHEMA.TAGS.parse(await tag_res.json());
+      setTags(tag_result.map((tag) => tag.name));
     
       await refetchProjects();
 
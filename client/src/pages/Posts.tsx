@@ -58,13 +58,15 @@ export function PostsPage() {
                 const result = await response.json();
                 setPosts({ ...posts, posts: [...posts.posts, { ...result } as Post] });
             } else {
-                const update_post = post as (Omit<PostUpdate, "id"> & { id: number });
+                if (response && response.status == 409) return { error: "Post was edited elsewhere, refresh to get the latest version", success: false };
                 if (!response || !response.ok) return { error: "Invalid Response", success: false };
+                // the server responds with the updated post, which includes the new version
+                const updated_post = await response.json();
 
                 setPosts({
                     ...posts, posts: posts.posts.map((p) => {
                         if (p.id != post.id) return p;
-                        return { ...p, ...update_post };
+                        return { ...p, ...updated_post };
                     })
                 });
             }
@@ -143,16 +145,17 @@ function Tag({ tag, remove }: { tag: string, remove: (() => void) | null }) {
 function PostCard({ post: initial, edit, save }: { post: Post, edit: () => void, save: (post: PostUpdate) => Promise<FunctionResponse> }) {
     const [post, setPost] = useState<PostUpdate>({ ...initial });
 
+    // build from the latest copy of the post so we send the current version
     const trash = async (): Promise<FunctionResponse> => {
-        post.archived = true;
-        setPost({ ...post });
-        return await save(post);
+        const updated = { ...initial, archived: true };
+        setPost(updated);
+        return await save(updated);
     }
 
     const revive = async (): Promise<FunctionResponse> => {
-        post.archived = false;
-        setPost({ ...post });
-        return await save(post);
+        const updated = { ...initial, archived: false };
+        setPost(updated);
+        return await save(updated);
     }
 
     return <div className='post-card hidden-parent'>
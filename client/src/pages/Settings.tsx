@@ -283,6 +283,7 @@ type TokenCreation = Omit<AccessKey, "created_at" | "updated_at"> & { saving: bo
 
 const EMPTY_TOKEN: Omit<TokenCreation, "user_id"> = {
   id: -1,
+  prefix: "",
   value: "<generated>",
   name: "",
   note: "",
@@ -297,7 +298,7 @@ function TokenRow({ token, save, remove }: { token: TokenCreation, save: (token:
   const icon = token.saving ? <Oval width={18} height={18} strokeWidth={8} /> : (mode == "create" ? <Check /> : <Save />);
 
   return <>
-    <div className="api-token" style={{ fontFamily: "monospace" }}>{editing.value}</div>
+    <div className="api-token" style={{ fontFamily: "monospace" }}>{token.value ?? `${token.prefix}...`}</div>
     <input type="text" value={editing.name} onChange={(e) => setEditing({ ...editing, name: e.target.value })} disabled={!enabled} />
     <input type="text" value={editing.note} onChange={(e) => setEditing({ ...editing, note: e.target.value })} disabled={!enabled} />
     <input type="checkbox" checked={editing.enabled} onChange={(e) => setEditing({ ...editing, enabled: e.target.checked })} disabled={!enabled} />
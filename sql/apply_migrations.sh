@@ -15,19 +15,32 @@ if [ ! -d "$MIGRATIONS_FOLDER" ]; then
     exit 1
 fi
 
+# Keep track of applied migrations, as migrations that alter tables can't be applied twice
+sqlite3 "$DATABASE_FILE" "CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY, applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);"
+
 # Find all migration files in the migrations folder
 MIGRATION_FILES=$(find "$MIGRATIONS_FOLDER" -type f -name "*.sql" | sort -n)
 
 # Iterate over migration files and apply them in order
 for FILE in $MIGRATION_FILES; do
+    NAME=$(basename "$FILE")
+    APPLIED=$(sqlite3 "$DATABASE_FILE" "SELECT COUNT(*) FROM migrations WHERE name = '$NAME';")
+    if [ "$APPLIED" != "0" ]; then
+        echo "Skipping applied migration: $FILE"
+        continue
+    fi
+
     echo "Applying migration: $FILE"
-    sqlite3 "$DATABASE_FILE" < "$FILE"
+    # -bail stops at the first error, otherwise the rest of a failed migration would still run
+    sqlite3 -bail "$DATABASE_FILE" < "$FILE"
 
-    # Check the exit status of the previous command
+    # Check the exit status of the previous command, a failed migration isn't recorded so it's applied again
     if [ $? -ne 0 ]; then
         echo "Error applying migration: $FILE"
         exit 1
     fi
+
+    sqlite3 "$DATABASE_FILE" "INSERT INTO migrations (name) VALUES ('$NAME');"
 done
 
 echo "All migrations applied successfully."
@@ -1,5 +1,5 @@
-INSERT OR IGNORE INTO users (user_id, github_id, username, email, avatar_url) VALUES
-    (1, '81222943', 'f0rbit', 'dev@forbit.dev', 'https://avatars.githubusercontent.com/u/81222943?v=4');
+INSERT OR IGNORE INTO users (user_id, github_id, username, email, avatar_url, role) VALUES
+    (1, '81222943', 'f0rbit', 'dev@forbit.dev', 'https://avatars.githubusercontent.com/u/81222943?v=4', 'admin');
 
 INSERT OR IGNORE INTO categories (owner_id, name, parent) VALUES
     (1, 'coding', 'root'),
@@ -23,3 +23,6 @@ INSERT OR IGNORE INTO tags (post_id, tag) VALUES
 
 INSERT OR IGNORE INTO access_keys (key_id, key_value, user_id, name, note) VALUES
     (1, 'd6a6ef5bde684e49a29366e94cf8c772ff085d64f8d67012', 1, 'Test Key', 'This is generated by default');
+
+INSERT OR IGNORE INTO user_identities (user_id, provider, subject, username, email, avatar_url) VALUES
+    (1, 'github', '81222943', 'f0rbit', 'dev@forbit.dev', 'https://avatars.githubusercontent.com/u/81222943?v=4');
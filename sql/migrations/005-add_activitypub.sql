@@ -0,0 +1,31 @@
+-- keypairs used to sign outgoing activitypub requests, one per user
+CREATE TABLE IF NOT EXISTS actor_keys (
+    user_id INTEGER NOT NULL,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(user_id)
+);
+
+-- remote actors following a user, inbox is where we deliver activities to
+CREATE TABLE IF NOT EXISTS followers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    actor TEXT NOT NULL,
+    inbox TEXT NOT NULL,
+    shared_inbox TEXT NOT NULL DEFAULT "",
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(user_id, actor)
+);
+
+-- posts that have been sent out as a 'Create' activity, so we know whether to send 'Update' or 'Delete' later
+CREATE TABLE IF NOT EXISTS federated_posts (
+    post_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts(id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(post_id)
+);
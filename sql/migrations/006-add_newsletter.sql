@@ -0,0 +1,42 @@
+-- readers subscribed to an author's newsletter, status is one of pending, confirmed, unsubscribed
+CREATE TABLE IF NOT EXISTS subscribers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    email TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT "pending",
+    token TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    confirmed_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(user_id, email),
+    UNIQUE(token)
+);
+
+-- a newsletter sent for a post, each post is only ever sent once
+CREATE TABLE IF NOT EXISTS newsletter_sends (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts(id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(post_id)
+);
+
+-- delivery status of a send for each subscriber, status is one of pending, sent, failed
+CREATE TABLE IF NOT EXISTS newsletter_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    send_id INTEGER NOT NULL,
+    subscriber_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT "pending",
+    error TEXT NOT NULL DEFAULT "",
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (send_id) REFERENCES newsletter_sends(id),
+    FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
+    UNIQUE(send_id, subscriber_id)
+);
+
+-- posts that are already published shouldn't be emailed out when they are next edited
+INSERT OR IGNORE INTO newsletter_sends (post_id, user_id, subject)
+    SELECT id, author_id, title FROM posts WHERE archived = 0 AND datetime(publish_at) <= datetime('now');
@@ -0,0 +1,2 @@
+-- version is incremented on every edit, used to reject edits made against a stale copy of a post
+ALTER TABLE posts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
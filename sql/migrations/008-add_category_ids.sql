@@ -0,0 +1,24 @@
+-- categories were keyed on their name alone, so two users couldn't own a category with the same name.
+-- sqlite can't change a primary key in place, so we rebuild the table with a surrogate key & names unique per owner.
+-- the rebuild is one transaction, so a failure part way through leaves the old table as it was
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS categories_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    name VARCHAR(15) NOT NULL,
+    parent VARCHAR(15) NOT NULL DEFAULT 'root',
+
+    FOREIGN KEY (owner_id) REFERENCES users(user_id),
+    UNIQUE(owner_id, name)
+);
+
+INSERT INTO categories_new (owner_id, name, parent)
+    SELECT owner_id, name, IFNULL(parent, 'root') FROM categories;
+
+DROP TABLE categories;
+ALTER TABLE categories_new RENAME TO categories;
+
+CREATE INDEX IF NOT EXISTS idx_categories_user ON categories(owner_id);
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- optional metadata for displaying categories
+ALTER TABLE categories ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE categories ADD COLUMN display_order INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE categories ADD COLUMN slug TEXT NOT NULL DEFAULT '';
+ALTER TABLE categories ADD COLUMN cover_image TEXT NOT NULL DEFAULT '';
+
+-- existing categories get a slug derived from their name
+UPDATE categories SET slug = lower(replace(trim(name), ' ', '-')) WHERE slug = '';
@@ -0,0 +1,26 @@
+-- tags on posts are stored by name, this table holds the metadata of each tag a user has
+CREATE TABLE IF NOT EXISTS user_tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    colour TEXT NOT NULL DEFAULT '',
+    slug TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (owner_id) REFERENCES users(user_id),
+    UNIQUE(owner_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tags_owner ON user_tags(owner_id);
+
+-- existing tags become entities of the post author
+INSERT OR IGNORE INTO user_tags (owner_id, name)
+    SELECT DISTINCT posts.author_id, tags.tag FROM tags JOIN posts ON posts.id = tags.post_id;
+
+-- tags are added to posts from many places, so keep the entities in sync with a trigger
+CREATE TRIGGER IF NOT EXISTS user_tags_insert AFTER INSERT ON tags
+BEGIN
+    INSERT OR IGNORE INTO user_tags (owner_id, name)
+        SELECT posts.author_id, NEW.tag FROM posts WHERE posts.id = NEW.post_id;
+END;
@@ -0,0 +1,119 @@
+-- every change to a user's content bumps their version, used to build ETags & Last-Modified headers without rendering the response
+CREATE TABLE IF NOT EXISTS content_versions (
+    user_id INTEGER PRIMARY KEY,
+    version INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+INSERT OR IGNORE INTO content_versions (user_id) SELECT user_id FROM users;
+
+-- content is changed from many places (including other triggers), so the versions are kept up to date with triggers
+
+CREATE TRIGGER IF NOT EXISTS content_version_posts_insert AFTER INSERT ON posts
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.author_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.author_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_posts_update AFTER UPDATE ON posts
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.author_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.author_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_posts_delete AFTER DELETE ON posts
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (OLD.author_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = OLD.author_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_tags_insert AFTER INSERT ON tags
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) SELECT author_id FROM posts WHERE id = NEW.post_id;
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = (SELECT author_id FROM posts WHERE id = NEW.post_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_tags_update AFTER UPDATE ON tags
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) SELECT author_id FROM posts WHERE id = NEW.post_id;
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = (SELECT author_id FROM posts WHERE id = NEW.post_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_tags_delete AFTER DELETE ON tags
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) SELECT author_id FROM posts WHERE id = OLD.post_id;
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = (SELECT author_id FROM posts WHERE id = OLD.post_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_posts_projects_insert AFTER INSERT ON posts_projects
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) SELECT author_id FROM posts WHERE id = NEW.post_id;
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = (SELECT author_id FROM posts WHERE id = NEW.post_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_posts_projects_update AFTER UPDATE ON posts_projects
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) SELECT author_id FROM posts WHERE id = NEW.post_id;
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = (SELECT author_id FROM posts WHERE id = NEW.post_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_posts_projects_delete AFTER DELETE ON posts_projects
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) SELECT author_id FROM posts WHERE id = OLD.post_id;
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = (SELECT author_id FROM posts WHERE id = OLD.post_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_categories_insert AFTER INSERT ON categories
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.owner_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.owner_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_categories_update AFTER UPDATE ON categories
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.owner_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.owner_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_categories_delete AFTER DELETE ON categories
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (OLD.owner_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = OLD.owner_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_user_tags_insert AFTER INSERT ON user_tags
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.owner_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.owner_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_user_tags_update AFTER UPDATE ON user_tags
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.owner_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.owner_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_user_tags_delete AFTER DELETE ON user_tags
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (OLD.owner_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = OLD.owner_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_followers_insert AFTER INSERT ON followers
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.user_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.user_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_followers_delete AFTER DELETE ON followers
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (OLD.user_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = OLD.user_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS content_version_users_update AFTER UPDATE ON users
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.user_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.user_id;
+END;
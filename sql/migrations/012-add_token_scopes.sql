@@ -0,0 +1,2 @@
+-- space separated scopes a token is limited to, tokens created before scopes keep full access
+ALTER TABLE access_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT 'posts:read posts:write categories:write tokens:admin integrations:sync newsletter:read';
@@ -0,0 +1,10 @@
+-- tokens without an expiry never expire
+ALTER TABLE access_keys ADD COLUMN expires_at TIMESTAMP;
+ALTER TABLE access_keys ADD COLUMN last_used_at TIMESTAMP;
+ALTER TABLE access_keys ADD COLUMN last_used_ip TEXT NOT NULL DEFAULT '';
+
+-- a rotated token's old value keeps working until previous_expires_at
+ALTER TABLE access_keys ADD COLUMN previous_key_value TEXT;
+ALTER TABLE access_keys ADD COLUMN previous_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_previous_key_value ON access_keys(previous_key_value);
@@ -0,0 +1,5 @@
+-- key_value & previous_key_value hold SHA-256 hashes of the token values, key_prefix is the start of the value so
+-- tokens can be told apart. sqlite has no sha256 function, so tokens that aren't hashed yet are hashed in place by
+-- the server on startup
+ALTER TABLE access_keys ADD COLUMN key_prefix TEXT NOT NULL DEFAULT '';
+ALTER TABLE access_keys ADD COLUMN hashed BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,50 @@
+-- users can log in with several providers, each login is an identity. github_id is kept for users that log in with
+-- GitHub, sqlite can't drop the NOT NULL in place, so the table is rebuilt in one transaction
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS users_new (
+    user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    github_id INTEGER UNIQUE,
+    username VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    avatar_url VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO users_new (user_id, github_id, username, email, avatar_url, created_at, updated_at)
+    SELECT user_id, github_id, username, email, avatar_url, created_at, updated_at FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+-- dropped along with the old table
+CREATE TRIGGER IF NOT EXISTS content_version_users_update AFTER UPDATE ON users
+BEGIN
+    INSERT OR IGNORE INTO content_versions (user_id) VALUES (NEW.user_id);
+    UPDATE content_versions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE user_id = NEW.user_id;
+END;
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    -- the name of the provider, e.g. github or the OIDC_NAME
+    provider TEXT NOT NULL,
+    -- the provider's id of the user
+    subject TEXT NOT NULL,
+    username TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    avatar_url TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+INSERT OR IGNORE INTO user_identities (user_id, provider, subject, username, email, avatar_url)
+    SELECT user_id, 'github', github_id, username, IFNULL(email, ''), IFNULL(avatar_url, '') FROM users WHERE github_id IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,19 @@
+-- local accounts are identities with the "email" provider, the subject is their lowercased email.
+-- Only they have a password, which is an argon2id or bcrypt hash
+ALTER TABLE user_identities ADD COLUMN password_hash TEXT;
+
+-- emailed links that log in once, a link with a username creates the account of a new email
+CREATE TABLE IF NOT EXISTS login_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- the sha256 of the link's token
+    token_hash TEXT NOT NULL,
+    email TEXT NOT NULL,
+    username TEXT,
+    return_to TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    UNIQUE(token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_links_email ON login_links(email);
@@ -0,0 +1,21 @@
+-- logged in browsers, the user-session cookie only holds the session's signed token. Sessions expire once they
+-- haven't been used for the session ttl, deleting a row logs that browser out
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- the sha256 of the session's token
+    token_hash TEXT NOT NULL,
+    user_id INTEGER,
+    -- the gob encoded values of the session
+    data BLOB,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_active_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    UNIQUE(token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_expires ON user_sessions(expires_at);
@@ -0,0 +1,28 @@
+-- users have an instance role, one of admin, author, reader. Everyone could write before roles, so existing users
+-- are authors and the first of them is the admin
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'author';
+-- suspended users can't log in or use their tokens
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP;
+
+UPDATE users SET role = 'admin' WHERE user_id = (SELECT MIN(user_id) FROM users);
+
+-- invite codes sign up one new user with a role, they're needed when REGISTRATION is "invite"
+CREATE TABLE IF NOT EXISTS invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- the sha256 of the code
+    code_hash TEXT NOT NULL,
+    code_prefix TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'author',
+    created_by INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    used_by INTEGER,
+    used_at TIMESTAMP,
+
+    FOREIGN KEY (created_by) REFERENCES users(user_id),
+    FOREIGN KEY (used_by) REFERENCES users(user_id),
+    UNIQUE(code_hash)
+);
+
+-- a magic link signing up a new email keeps its invite until it's used
+ALTER TABLE login_links ADD COLUMN invite TEXT;
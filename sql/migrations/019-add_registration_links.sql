@@ -0,0 +1,3 @@
+-- registering emails a login link to confirm the email, the password is kept on the link until it's used and the
+-- account is created
+ALTER TABLE login_links ADD COLUMN password_hash TEXT;
@@ -0,0 +1,4 @@
+-- when the confirmation email of a subscriber was last sent, confirmation links expire & aren't re-sent too often.
+-- Pending subscribers from before this were sent one when they were created
+ALTER TABLE subscribers ADD COLUMN confirmation_sent_at TIMESTAMP;
+UPDATE subscribers SET confirmation_sent_at = created_at WHERE status = 'pending';
@@ -0,0 +1,509 @@
+package actions
+
+import (
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/russross/blackfriday/v2"
+)
+
+// this file is responsible for presenting users as activitypub actors and delivering their post changes to followers
+
+const ACTIVITY_CONTENT_TYPE = "application/activity+json"
+const ACTIVITY_CONTEXT = "https://www.w3.org/ns/activitystreams"
+const PUBLIC_COLLECTION = "https://www.w3.org/ns/activitystreams#Public"
+
+var SIGNED_HEADERS = []string{"(request-target)", "host", "date", "digest"}
+
+// federationClient only connects to public addresses over https, the urls it fetches come from remote documents
+// and unauthenticated requests so they can't be allowed to reach the server's own network
+var federationClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublic}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("Too many redirects")
+		}
+		return checkRemoteURL(req.URL.String())
+	},
+}
+
+// checkRemoteURL rejects urls that federation requests can't be made to
+func checkRemoteURL(remote string) error {
+	parsed, err := url.Parse(remote)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" || parsed.Hostname() == "" {
+		return fmt.Errorf("Remote url must be https: %s", remote)
+	}
+	return nil
+}
+
+// dialPublic is checked against the resolved address of every connection, so a host can't resolve to a private address
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("Refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+func ServerURL() string {
+	return strings.TrimSuffix(os.Getenv("SERVER_URL"), "/")
+}
+
+// ServerHost is the domain used for webfinger 'acct:' resources
+func ServerHost() string {
+	parsed, err := url.Parse(ServerURL())
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func ActorURL(username string) string {
+	return ServerURL() + "/ap/users/" + url.PathEscape(username)
+}
+
+func ArticleURL(username string, postID int) string {
+	return ActorURL(username) + "/posts/" + strconv.Itoa(postID)
+}
+
+// GetActorKey returns the signing key of a user, generating one on first use
+func GetActorKey(userID int) (*types.ActorKey, error) {
+	key, err := database.GetActorKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		return key, nil
+	}
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	err = database.CreateActorKey(types.ActorKey{
+		UserID:     userID,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// re-read the key in case another request inserted one first
+	return database.GetActorKey(userID)
+}
+
+func BuildActor(user *types.User, key *types.ActorKey) types.ActivityActor {
+	actor_url := ActorURL(user.Username)
+	actor := types.ActivityActor{
+		Context:           []string{ACTIVITY_CONTEXT, "https://w3id.org/security/v1"},
+		ID:                actor_url,
+		Type:              "Person",
+		PreferredUsername: user.Username,
+		Name:              user.Username,
+		Inbox:             actor_url + "/inbox",
+		Outbox:            actor_url + "/outbox",
+		Followers:         actor_url + "/followers",
+		PublicKey: types.ActivityPublicKey{
+			ID:           actor_url + "#main-key",
+			Owner:        actor_url,
+			PublicKeyPem: key.PublicKey,
+		},
+	}
+	if user.AvatarURL != "" {
+		actor.Icon = &types.ActivityImage{Type: "Image", URL: user.AvatarURL}
+	}
+	return actor
+}
+
+func BuildArticle(user *types.User, post types.Post) types.ActivityArticle {
+	actor_url := ActorURL(user.Username)
+	// posts without a publish date were published when they were created
+	published := post.PublishAt
+	if published.IsZero() {
+		published = post.CreatedAt
+	}
+	article := types.ActivityArticle{
+		ID:           ArticleURL(user.Username, post.Id),
+		Type:         "Article",
+		AttributedTo: actor_url,
+		Name:         post.Title,
+		Summary:      post.Description,
+		Content:      renderContent(post),
+		MediaType:    "text/html",
+		URL:          ArticleURL(user.Username, post.Id),
+		Published:    published.UTC().Format(time.RFC3339),
+		To:           []string{PUBLIC_COLLECTION},
+		Cc:           []string{actor_url + "/followers"},
+		Tag:          make([]types.ActivityTag, 0, len(post.Tags)),
+	}
+	if post.UpdatedAt.After(published) {
+		article.Updated = post.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	for _, tag := range post.Tags {
+		article.Tag = append(article.Tag, types.ActivityTag{Type: "Hashtag", Name: "#" + tag})
+	}
+	return article
+}
+
+// BuildActivity wraps an object in an activity sent by the user
+func BuildActivity(user *types.User, kind string, object interface{}, objectID string) types.Activity {
+	actor_url := ActorURL(user.Username)
+	now := time.Now().UTC()
+	return types.Activity{
+		Context:   ACTIVITY_CONTEXT,
+		ID:        fmt.Sprintf("%s#%s-%d", objectID, strings.ToLower(kind), now.UnixNano()),
+		Type:      kind,
+		Actor:     actor_url,
+		Object:    object,
+		Published: now.Format(time.RFC3339),
+		To:        []string{PUBLIC_COLLECTION},
+		Cc:        []string{actor_url + "/followers"},
+	}
+}
+
+// renderContent converts the post content into the html that fediverse servers expect
+func renderContent(post types.Post) string {
+	if post.Format == "md" || post.Format == "" {
+		return string(blackfriday.Run([]byte(post.Content)))
+	}
+	return "<pre>" + html.EscapeString(post.Content) + "</pre>"
+}
+
+// FederatePost notifies the followers of a user about a change to one of their posts.
+// Posts that become published are sent as 'Create', posts that were already sent out are sent as 'Update' or 'Delete'.
+func FederatePost(user *types.User, post types.Post, deleted bool) {
+	federated, err := database.IsPostFederated(post.Id)
+	if err != nil {
+		log.Error("Error checking federated post", "id", post.Id, "err", err)
+		return
+	}
+	published := !deleted && utils.IsPublished(post)
+
+	var activity types.Activity
+	article_id := ArticleURL(user.Username, post.Id)
+	switch {
+	case published && !federated:
+		activity = BuildActivity(user, "Create", BuildArticle(user, post), article_id)
+	case published && federated:
+		activity = BuildActivity(user, "Update", BuildArticle(user, post), article_id)
+	case !published && federated:
+		activity = BuildActivity(user, "Delete", map[string]string{"id": article_id, "type": "Tombstone"}, article_id)
+	default:
+		return
+	}
+
+	err = database.SetPostFederated(post.Id, user.ID, published)
+	if err != nil {
+		log.Error("Error updating federated post", "id", post.Id, "err", err)
+		return
+	}
+
+	followers, err := database.GetFollowers(user.ID)
+	if err != nil {
+		log.Error("Error fetching followers", "user_id", user.ID, "err", err)
+		return
+	}
+
+	// servers with a shared inbox only need the activity delivered once
+	inboxes := make(map[string]bool)
+	for _, follower := range followers {
+		if follower.SharedInbox != "" {
+			inboxes[follower.SharedInbox] = true
+		} else {
+			inboxes[follower.Inbox] = true
+		}
+	}
+
+	go func() {
+		for inbox := range inboxes {
+			if err := DeliverActivity(user, inbox, activity); err != nil {
+				log.Error("Error delivering activity", "inbox", inbox, "type", activity.Type, "err", err)
+			}
+		}
+	}()
+}
+
+// HandleFollow stores the remote actor as a follower and replies with an 'Accept'
+func HandleFollow(user *types.User, follow types.Activity, remote *types.ActivityActor) error {
+	follower := types.Follower{
+		UserID: user.ID,
+		Actor:  remote.ID,
+		Inbox:  remote.Inbox,
+	}
+	if remote.Endpoints != nil {
+		follower.SharedInbox = remote.Endpoints.SharedInbox
+	}
+	if follower.Inbox == "" {
+		return errors.New("Remote actor has no inbox")
+	}
+
+	err := database.AddFollower(follower)
+	if err != nil {
+		return err
+	}
+
+	follow.Context = nil
+	accept := BuildActivity(user, "Accept", follow, ActorURL(user.Username))
+	accept.To = []string{remote.ID}
+	accept.Cc = nil
+	go func() {
+		if err := DeliverActivity(user, remote.Inbox, accept); err != nil {
+			log.Error("Error delivering accept", "inbox", remote.Inbox, "err", err)
+		}
+	}()
+	return nil
+}
+
+// DeliverActivity posts a signed activity to a remote inbox
+func DeliverActivity(user *types.User, inbox string, activity types.Activity) error {
+	key, err := GetActorKey(user.ID)
+	if err != nil {
+		return err
+	}
+	private, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRemoteURL(inbox); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ACTIVITY_CONTENT_TYPE)
+	req.Header.Set("Accept", ACTIVITY_CONTENT_TYPE)
+
+	err = signRequest(req, ActorURL(user.Username)+"#main-key", private, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Inbox responded with status code: %d", resp.StatusCode)
+	}
+	log.Info("Delivered activity", "inbox", inbox, "type", activity.Type)
+	return nil
+}
+
+// FetchRemoteActor dereferences an actor id into its actor document
+func FetchRemoteActor(actorURL string) (*types.ActivityActor, error) {
+	if err := checkRemoteURL(actorURL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", actorURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ACTIVITY_CONTENT_TYPE)
+
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching actor failed with status code: %d", resp.StatusCode)
+	}
+
+	var actor types.ActivityActor
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor)
+	if err != nil {
+		return nil, err
+	}
+	if actor.ID == "" {
+		return nil, errors.New("Actor document has no id")
+	}
+	return &actor, nil
+}
+
+// VerifyRequest checks the http signature of an incoming request and returns the actor that signed it
+func VerifyRequest(r *http.Request, body []byte) (*types.ActivityActor, error) {
+	params := parseSignatureHeader(r.Header.Get("Signature"))
+	key_id, signature := params["keyId"], params["signature"]
+	if key_id == "" || signature == "" {
+		return nil, errors.New("Missing http signature")
+	}
+	headers := strings.Fields(params["headers"])
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	for _, required := range SIGNED_HEADERS {
+		if !slices.Contains(headers, required) {
+			return nil, fmt.Errorf("Signature doesn't cover %s", required)
+		}
+	}
+
+	digest := sha256.Sum256(body)
+	if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]) {
+		return nil, errors.New("Digest doesn't match body")
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(date).Abs() > time.Hour {
+		return nil, errors.New("Signature date is too far from the current time")
+	}
+
+	// the key id is the actor url with a fragment pointing to the key
+	actor_url, _, _ := strings.Cut(key_id, "#")
+	actor, err := FetchRemoteActor(actor_url)
+	if err != nil {
+		return nil, errors.Join(errors.New("Error fetching signing actor"), err)
+	}
+	// any server can host a document claiming to be any actor, so it has to be the actor at the url it was fetched
+	// from & own the key
+	if actor.ID != actor_url {
+		return nil, errors.New("Actor id doesn't match the url it was fetched from")
+	}
+	if actor.PublicKey.ID != key_id || actor.PublicKey.Owner != actor.ID {
+		return nil, errors.New("Signing key doesn't belong to actor")
+	}
+
+	public, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256([]byte(signingString(r, headers)))
+	err = rsa.VerifyPKCS1v15(public, crypto.SHA256, hashed[:], decoded)
+	if err != nil {
+		return nil, errors.Join(errors.New("Invalid http signature"), err)
+	}
+
+	return actor, nil
+}
+
+func signRequest(req *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	digest := sha256.Sum256(body)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	hashed := sha256.Sum256([]byte(signingString(req, SIGNED_HEADERS)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(SIGNED_HEADERS, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
+
+// signingString builds the string covered by the signature, as described in draft-cavage-http-signatures
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, header := range headers {
+		var value string
+		switch header {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			// outgoing requests only have the host in the url
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			value = r.Header.Get(header)
+		}
+		lines[i] = header + ": " + value
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[key] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+func parsePrivateKey(encoded string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("Invalid private key")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func parsePublicKey(encoded string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("Invalid public key")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Public key isn't an RSA key")
+	}
+	return public, nil
+}
@@ -143,6 +143,11 @@ func handleDevToArticle(article interface{}, user *types.User, integration *type
 		Description: article.(map[string]interface{})["description"].(string),
 	}
 	post_id, err := database.CreatePost(newPost)
+	// users without a devlog category get the post in root
+	if errors.Is(err, database.ErrCategoryNotFound) {
+		newPost.Category = "root"
+		post_id, err = database.CreatePost(newPost)
+	}
 	if err != nil {
 		return err
 	}
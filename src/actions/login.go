@@ -0,0 +1,33 @@
+package actions
+
+import (
+	"blog-server/utils"
+	"fmt"
+	"html"
+	"net/url"
+)
+
+// this file is responsible for emailing login links to local accounts
+
+func LoginLinkURL(token string) string {
+	return ServerURL() + "/auth/email/callback?token=" + url.QueryEscape(token)
+}
+
+// SendLoginLink emails a login link, a username means the link signs up a new account with it
+func SendLoginLink(email string, username string, token string) error {
+	link := LoginLinkURL(token)
+	subject := "Your login link"
+	action := "log in"
+	if username != "" {
+		subject = "Finish signing up"
+		action = "create the account " + username + " and log in"
+	}
+	body := fmt.Sprintf(`<p>Use the link below to %s, it can only be used once and expires soon.</p><p><a href="%s">Log in</a></p><p>If you didn't request this you can ignore this email.</p>`,
+		html.EscapeString(action), link)
+
+	return utils.SendMail(utils.Mail{
+		To:      email,
+		Subject: subject,
+		HTML:    body,
+	})
+}
@@ -0,0 +1,96 @@
+package actions
+
+import (
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"fmt"
+	"html"
+	"net/url"
+
+	"github.com/charmbracelet/log"
+)
+
+// this file is responsible for emailing posts out to the newsletter subscribers of their author
+
+func NewsletterURL(action string, token string) string {
+	return ServerURL() + "/newsletter/" + action + "?token=" + url.QueryEscape(token)
+}
+
+// SendConfirmation emails a pending subscriber the link to confirm their subscription
+func SendConfirmation(user *types.User, subscriber *types.Subscriber) error {
+	link := NewsletterURL("confirm", subscriber.Token)
+	body := fmt.Sprintf(`<p>Please confirm your subscription to posts by %s.</p><p><a href="%s">Confirm subscription</a></p><p>If you didn't request this you can ignore this email.</p>`,
+		html.EscapeString(user.Username), link)
+
+	return utils.SendMail(utils.Mail{
+		To:      subscriber.Email,
+		Subject: "Confirm your subscription to " + user.Username,
+		HTML:    body,
+	})
+}
+
+// SendNewsletter emails a post to the confirmed subscribers of its author, the first time it becomes published
+func SendNewsletter(user *types.User, post types.Post) {
+	if !utils.MailEnabled() || !utils.IsPublished(post) {
+		return
+	}
+
+	send_id, err := database.CreateNewsletterSend(types.NewsletterSend{
+		PostID:  post.Id,
+		UserID:  user.ID,
+		Subject: post.Title,
+	})
+	if err != nil {
+		log.Error("Error creating newsletter send", "post_id", post.Id, "err", err)
+		return
+	}
+	if send_id == -1 {
+		// this post has already been sent
+		return
+	}
+
+	subscribers, err := database.GetSubscribers(user.ID, "confirmed")
+	if err != nil {
+		log.Error("Error fetching subscribers", "user_id", user.ID, "err", err)
+		return
+	}
+
+	log.Info("Sending newsletter", "post_id", post.Id, "subscribers", len(subscribers))
+
+	go func() {
+		for _, subscriber := range subscribers {
+			delivery_id, err := database.CreateNewsletterDelivery(send_id, subscriber.ID)
+			if err != nil {
+				log.Error("Error creating newsletter delivery", "send_id", send_id, "err", err)
+				continue
+			}
+
+			status, message := "sent", ""
+			if err := utils.SendMail(newsletterMail(user, post, subscriber)); err != nil {
+				log.Error("Error sending newsletter", "send_id", send_id, "subscriber_id", subscriber.ID, "err", err)
+				status, message = "failed", err.Error()
+			}
+
+			if err := database.UpdateNewsletterDelivery(delivery_id, status, message); err != nil {
+				log.Error("Error updating newsletter delivery", "id", delivery_id, "err", err)
+			}
+		}
+	}()
+}
+
+func newsletterMail(user *types.User, post types.Post, subscriber types.Subscriber) utils.Mail {
+	unsubscribe := NewsletterURL("unsubscribe", subscriber.Token)
+	body := fmt.Sprintf(`<h1>%s</h1>%s<hr><p>You are receiving this because you subscribed to posts by %s. <a href="%s">Unsubscribe</a></p>`,
+		html.EscapeString(post.Title), renderContent(post), html.EscapeString(user.Username), unsubscribe)
+
+	return utils.Mail{
+		To:      subscriber.Email,
+		Subject: post.Title,
+		HTML:    body,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package actions
+
+import (
+	"blog-server/database"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// this file is responsible for sending out posts that were saved with a future publish date once they go live
+
+const DEFAULT_PUBLISH_INTERVAL = time.Minute
+
+// StartScheduledPublishing checks for scheduled posts going live every PUBLISH_INTERVAL (e.g. 30s)
+func StartScheduledPublishing() {
+	interval := DEFAULT_PUBLISH_INTERVAL
+	if value := os.Getenv("PUBLISH_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Error("Invalid PUBLISH_INTERVAL, using the default", "value", value)
+		} else {
+			interval = parsed
+		}
+	}
+	log.Info("Checking for scheduled posts", "interval", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			PublishScheduledPosts()
+		}
+	}()
+}
+
+// PublishScheduledPosts sends the posts that have become published since they were saved to newsletter subscribers
+// & followers, posts that were already sent are skipped by the newsletter send log and the federated posts
+func PublishScheduledPosts() {
+	posts, err := database.GetScheduledPosts()
+	if err != nil {
+		log.Error("Error fetching scheduled posts", "err", err)
+		return
+	}
+
+	for _, post := range posts {
+		user, err := database.GetUserByID(post.AuthorID)
+		if err != nil || user == nil {
+			log.Error("Error fetching post author", "post_id", post.Id, "author_id", post.AuthorID, "err", err)
+			continue
+		}
+		SendNewsletter(user, post)
+
+		// federated posts would be sent again as an 'Update'
+		federated, err := database.IsPostFederated(post.Id)
+		if err != nil {
+			log.Error("Error checking federated post", "id", post.Id, "err", err)
+			continue
+		}
+		if !federated {
+			FederatePost(user, post, false)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package actions
+
+import (
+	"blog-server/types"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// this file ranks the existing tags of an author for a draft post, it only uses the author's own posts
+
+const (
+	SUGGEST_SIMILARITY_WEIGHT   = 0.5
+	SUGGEST_MENTION_WEIGHT      = 0.3
+	SUGGEST_COOCCURRENCE_WEIGHT = 0.2
+)
+
+var wordPattern = regexp.MustCompile("[a-z0-9]+")
+
+func tokenize(text string) []string {
+	return wordPattern.FindAllString(strings.ToLower(text), -1)
+}
+
+func termFrequencies(tokens []string) map[string]float64 {
+	frequencies := make(map[string]float64)
+	for _, token := range tokens {
+		frequencies[token]++
+	}
+	return frequencies
+}
+
+// SuggestTags scores every tag the author has used against the draft and returns the best limit tags.
+//
+// A tag scores on three signals, each between 0 and 1:
+//   - similarity: the cosine similarity of the draft's tf-idf vector and the combined vector of the posts with the tag
+//   - mentions: how often the words of the tag appear in the draft
+//   - co-occurrence: how often the tag is used alongside the draft's tags, or the tags it mentions
+func SuggestTags(draft types.TagSuggestionRequest, posts []types.Post, limit int) []types.TagSuggestion {
+	// document frequencies across the author's corpus
+	document_frequency := make(map[string]float64)
+	post_terms := make([]map[string]float64, len(posts))
+	for i, post := range posts {
+		post_terms[i] = termFrequencies(tokenize(post.Title + " " + post.Content))
+		for term := range post_terms[i] {
+			document_frequency[term]++
+		}
+	}
+	idf := func(term string) float64 {
+		// terms in every post carry no weight
+		return math.Log((1 + float64(len(posts))) / (1 + document_frequency[term]))
+	}
+
+	// a tag's profile is the sum of the terms of every post it's on, tag_counts is how many posts use it
+	profiles := make(map[string]map[string]float64)
+	tag_counts := make(map[string]float64)
+	pair_counts := make(map[string]map[string]float64)
+	for i, post := range posts {
+		for _, tag := range post.Tags {
+			if profiles[tag] == nil {
+				profiles[tag] = make(map[string]float64)
+				pair_counts[tag] = make(map[string]float64)
+			}
+			tag_counts[tag]++
+			for term, count := range post_terms[i] {
+				profiles[tag][term] += count
+			}
+			for _, other := range post.Tags {
+				if other != tag {
+					pair_counts[tag][other]++
+				}
+			}
+		}
+	}
+
+	draft_tokens := tokenize(draft.Title + " " + draft.Content)
+	draft_terms := termFrequencies(draft_tokens)
+	draft_vector := make(map[string]float64, len(draft_terms))
+	for term, count := range draft_terms {
+		draft_vector[term] = count * idf(term)
+	}
+
+	existing := make(map[string]bool)
+	for _, tag := range draft.Tags {
+		existing[tag] = true
+	}
+
+	// seeds are the tags we're confident belong to the draft, mentioned tags are trusted as much as their mention score
+	mentions := make(map[string]float64)
+	seeds := make(map[string]float64)
+	for tag := range profiles {
+		mentions[tag] = countMentions(draft_tokens, tokenize(tag))
+		if mentions[tag] > 0 {
+			seeds[tag] = mentionScore(mentions[tag])
+		}
+	}
+	for _, tag := range draft.Tags {
+		seeds[tag] = 1
+	}
+
+	suggestions := make([]types.TagSuggestion, 0)
+	for tag, profile := range profiles {
+		if existing[tag] {
+			continue
+		}
+
+		tag_vector := make(map[string]float64, len(profile))
+		for term, count := range profile {
+			tag_vector[term] = count * idf(term)
+		}
+
+		// the probability of the tag being used with one of the seeds
+		cooccurrence := 0.0
+		for seed, confidence := range seeds {
+			if seed == tag || tag_counts[seed] == 0 {
+				continue
+			}
+			cooccurrence = math.Max(cooccurrence, confidence*pair_counts[seed][tag]/tag_counts[seed])
+		}
+
+		suggestion := types.TagSuggestion{
+			Tag:          tag,
+			Similarity:   cosineSimilarity(draft_vector, tag_vector),
+			Mentions:     int(mentions[tag]),
+			CoOccurrence: cooccurrence,
+		}
+		suggestion.Score = SUGGEST_SIMILARITY_WEIGHT*suggestion.Similarity + SUGGEST_MENTION_WEIGHT*mentionScore(mentions[tag]) + SUGGEST_COOCCURRENCE_WEIGHT*suggestion.CoOccurrence
+		if suggestion.Score > 0 {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score == suggestions[j].Score {
+			return suggestions[i].Tag < suggestions[j].Tag
+		}
+		return suggestions[i].Score > suggestions[j].Score
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// mentionScore maps a number of mentions between 0 and 1, each mention counts for less than the last
+func mentionScore(mentions float64) float64 {
+	return 1 - 1/(1+mentions)
+}
+
+// countMentions counts the occurrences of a (possibly multi word) tag in the tokens of the draft
+func countMentions(tokens []string, tag []string) float64 {
+	if len(tag) == 0 {
+		return 0
+	}
+	count := 0.0
+	for i := 0; i+len(tag) <= len(tokens); i++ {
+		match := true
+		for j := range tag {
+			if tokens[i+j] != tag[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			count++
+		}
+	}
+	return count
+}
+
+func cosineSimilarity(a, b map[string]float64) float64 {
+	dot, a_length, b_length := 0.0, 0.0, 0.0
+	for term, value := range a {
+		dot += value * b[term]
+		a_length += value * value
+	}
+	for _, value := range b {
+		b_length += value * value
+	}
+	if a_length == 0 || b_length == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(a_length) * math.Sqrt(b_length))
+}
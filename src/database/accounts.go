@@ -0,0 +1,110 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// EMAIL_PROVIDER is the identity provider of local accounts, their subject is the lowercased email
+const EMAIL_PROVIDER = "email"
+
+// GetLocalAccount fetches the local account of an email, or nil if there isn't one
+func GetLocalAccount(email string) (*types.Identity, error) {
+	var identity types.Identity
+	var password_hash sql.NullString
+	err := db.QueryRow(`
+		SELECT id, user_id, provider, subject, username, email, avatar_url, created_at, last_login_at, password_hash
+		FROM user_identities WHERE provider = ? AND subject = ?`, EMAIL_PROVIDER, email).Scan(
+		&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Username, &identity.Email,
+		&identity.AvatarURL, &identity.CreatedAt, &identity.LastLoginAt, &password_hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity.PasswordHash = password_hash.String
+	return &identity, nil
+}
+
+// TouchIdentity records a login with an identity
+func TouchIdentity(id int) error {
+	_, err := db.Exec("UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+// CreateLoginLink stores a login link, the returned token is only sent in the email
+func CreateLoginLink(link types.LoginLink) (string, error) {
+	token, err := randToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO login_links (token_hash, email, username, invite, password_hash, return_to, expires_at)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?)`,
+		hashToken(token), link.Email, link.Username, link.Invite, link.PasswordHash, link.ReturnTo, timestamp(&link.ExpiresAt))
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("Created login link", "email", link.Email)
+	return token, nil
+}
+
+// LoginLinkSentSince returns whether a login link was sent to an email after the given time
+func LoginLinkSentSince(email string, since time.Time) (bool, error) {
+	var sent bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM login_links WHERE email = ? AND created_at > ?)", email, timestamp(&since)).Scan(&sent)
+	return sent, err
+}
+
+// RecordFailedLogin stores a failed password login, attempts older than a day are removed as they're no longer counted
+func RecordFailedLogin(email string, ip string) error {
+	_, err := db.Exec("DELETE FROM login_attempts WHERE created_at < datetime('now', '-1 day')")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO login_attempts (email, ip) VALUES (?, ?)", email, ip)
+	return err
+}
+
+// FailedLoginsSince counts the failed password logins of an email, and from an address, after the given time
+func FailedLoginsSince(email string, ip string, since time.Time) (int, int, error) {
+	var by_email, by_ip int
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(email = ?), 0), COALESCE(SUM(ip = ?), 0) FROM login_attempts
+		WHERE created_at > ? AND (email = ? OR ip = ?)`, email, ip, timestamp(&since), email, ip).Scan(&by_email, &by_ip)
+	return by_email, by_ip, err
+}
+
+// ClearFailedLogins forgets the failed logins of an email once it logs in
+func ClearFailedLogins(email string) error {
+	_, err := db.Exec("DELETE FROM login_attempts WHERE email = ?", email)
+	return err
+}
+
+// UseLoginLink marks the link of a token as used, returning it. Links that are used, expired or unknown are nil
+func UseLoginLink(token string) (*types.LoginLink, error) {
+	var link types.LoginLink
+	var username, invite, password_hash sql.NullString
+	err := db.QueryRow(`
+		UPDATE login_links SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, email, username, invite, password_hash, return_to, expires_at`, hashToken(token)).Scan(
+		&link.ID, &link.Email, &username, &invite, &password_hash, &link.ReturnTo, &link.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	link.Username = username.String
+	link.Invite = invite.String
+	link.PasswordHash = password_hash.String
+	return &link, nil
+}
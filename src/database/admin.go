@@ -0,0 +1,242 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+	"errors"
+
+	"github.com/charmbracelet/log"
+)
+
+var ErrLastAdmin = errors.New("The instance needs at least one admin")
+
+// HasUsers returns whether anyone has signed up, the first user can sign up whatever the registration mode
+func HasUsers() (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users)").Scan(&exists)
+	return exists, err
+}
+
+func GetUsers() ([]types.User, error) {
+	rows, err := db.Query("SELECT " + USER_COLUMNS + " FROM users ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]types.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// otherAdmins returns whether there's an admin other than the user that isn't suspended
+func otherAdmins(tx *sql.Tx, userID int) (bool, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE role = ? AND suspended_at IS NULL AND user_id != ?)", ROLE_ADMIN, userID).Scan(&exists)
+	return exists, err
+}
+
+// UpdateUser changes the role of a user, or suspends them. Suspending a user logs out their sessions, and the
+// last active admin can't be demoted or suspended
+func UpdateUser(userID int, update types.UserUpdate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM users WHERE user_id = ?", userID).Scan(&role)
+	if err != nil {
+		return err
+	}
+	demoted := update.Role != nil && *update.Role != ROLE_ADMIN
+	suspended := update.Suspended != nil && *update.Suspended
+	if role == ROLE_ADMIN && (demoted || suspended) {
+		others, err := otherAdmins(tx, userID)
+		if err != nil {
+			return err
+		}
+		if !others {
+			return ErrLastAdmin
+		}
+	}
+
+	if update.Role != nil {
+		_, err = tx.Exec("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?", *update.Role, userID)
+		if err != nil {
+			return err
+		}
+	}
+	if update.Suspended != nil {
+		_, err = tx.Exec(`
+			UPDATE users SET suspended_at = CASE WHEN ? THEN IFNULL(suspended_at, CURRENT_TIMESTAMP) END, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ?`, *update.Suspended, userID)
+		if err != nil {
+			return err
+		}
+	}
+	if suspended {
+		_, err = tx.Exec("DELETE FROM user_sessions WHERE user_id = ?", userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err == nil {
+		log.Info("Updated user", "id", userID)
+	}
+	return err
+}
+
+// DeleteUser deletes a user and everything they own, the last active admin can't be deleted
+func DeleteUser(userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM users WHERE user_id = ?", userID).Scan(&role)
+	if err != nil {
+		return err
+	}
+	if role == ROLE_ADMIN {
+		others, err := otherAdmins(tx, userID)
+		if err != nil {
+			return err
+		}
+		if !others {
+			return ErrLastAdmin
+		}
+	}
+
+	// children before the rows they reference, content_versions last as the delete triggers bump it
+	statements := []string{
+		"DELETE FROM tags WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?)",
+		"DELETE FROM posts_projects WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?)",
+		"DELETE FROM fetch_links WHERE post_id IN (SELECT id FROM posts WHERE author_id = ?)",
+		"DELETE FROM federated_posts WHERE user_id = ?",
+		"DELETE FROM newsletter_deliveries WHERE send_id IN (SELECT id FROM newsletter_sends WHERE user_id = ?)",
+		"DELETE FROM newsletter_sends WHERE user_id = ?",
+		"DELETE FROM subscribers WHERE user_id = ?",
+		"DELETE FROM posts WHERE author_id = ?",
+		"DELETE FROM fetch_queue WHERE user_id = ?",
+		"DELETE FROM projects_cache WHERE user_id = ?",
+		"DELETE FROM devpad_api_tokens WHERE user_id = ?",
+		"DELETE FROM categories WHERE owner_id = ?",
+		"DELETE FROM user_tags WHERE owner_id = ?",
+		"DELETE FROM followers WHERE user_id = ?",
+		"DELETE FROM actor_keys WHERE user_id = ?",
+		"DELETE FROM access_keys WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM user_sessions WHERE user_id = ?",
+		"UPDATE invites SET created_by = NULL WHERE created_by = ?",
+		"UPDATE invites SET used_by = NULL WHERE used_by = ?",
+		"DELETE FROM users WHERE user_id = ?",
+		"DELETE FROM content_versions WHERE user_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	invalidate(userID, postCacheKinds...)
+	log.Info("Deleted user", "id", userID)
+	return nil
+}
+
+const INVITE_COLUMNS = "id, code_prefix, role, IFNULL(created_by, 0), created_at, expires_at, used_by, used_at"
+
+func scanInvite(row interface{ Scan(...any) error }) (types.Invite, error) {
+	var invite types.Invite
+	var expires_at, used_at sql.NullTime
+	var used_by sql.NullInt64
+	err := row.Scan(&invite.ID, &invite.Prefix, &invite.Role, &invite.CreatedBy, &invite.CreatedAt, &expires_at, &used_by, &used_at)
+	if err != nil {
+		return invite, err
+	}
+	invite.ExpiresAt = nullTime(expires_at)
+	invite.UsedAt = nullTime(used_at)
+	if used_by.Valid {
+		id := int(used_by.Int64)
+		invite.UsedBy = &id
+	}
+	return invite, nil
+}
+
+// CreateInvite stores a new invite, returning its id & code
+func CreateInvite(invite types.Invite) (int, string, error) {
+	code, err := randToken(16)
+	if err != nil {
+		return 0, "", err
+	}
+	result, err := db.Exec(`
+		INSERT INTO invites (code_hash, code_prefix, role, created_by, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		hashToken(code), tokenPrefix(code), invite.Role, invite.CreatedBy, timestamp(invite.ExpiresAt))
+	if err != nil {
+		return 0, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+	log.Info("Created invite", "id", id, "role", invite.Role, "created_by", invite.CreatedBy)
+	return int(id), code, nil
+}
+
+func GetInvite(id int) (types.Invite, error) {
+	return scanInvite(db.QueryRow("SELECT "+INVITE_COLUMNS+" FROM invites WHERE id = ?", id))
+}
+
+func GetInvites() ([]types.Invite, error) {
+	rows, err := db.Query("SELECT " + INVITE_COLUMNS + " FROM invites ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := make([]types.Invite, 0)
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// ValidInvite returns whether an invite code can still be used, it's only used up when the user is created
+func ValidInvite(code string) (bool, error) {
+	var valid bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM invites
+		WHERE code_hash = ? AND used_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP))`, hashToken(code)).Scan(&valid)
+	return valid, err
+}
+
+func DeleteInvite(id int) error {
+	result, err := db.Exec("DELETE FROM invites WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return sql.ErrNoRows
+	}
+	log.Info("Deleted invite", "id", id)
+	return nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+)
+
+var BULK_OPERATIONS = []string{"set_category", "add_tags", "remove_tags", "archive", "unarchive", "set_project", "delete"}
+
+// BulkUpdatePosts applies an operation to many posts in one transaction.
+// Posts that don't exist or aren't owned by the user are skipped & reported in the results, any other error rolls back every post.
+func BulkUpdatePosts(user *types.User, operation types.BulkOperation) ([]types.BulkResult, error) {
+	if user == nil {
+		return nil, errors.New("Invalid user reference")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]types.BulkResult, 0, len(operation.IDs))
+	for _, id := range operation.IDs {
+		// same ownership check as deleting a single post
+		var authorID int
+		err := tx.QueryRow("SELECT author_id FROM posts WHERE id = ?", id).Scan(&authorID)
+		if errors.Is(err, sql.ErrNoRows) {
+			results = append(results, types.BulkResult{ID: id, Status: "not_found", Error: "Post not found"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if authorID != user.ID {
+			results = append(results, types.BulkResult{ID: id, Status: "forbidden", Error: "Post authorID doesn't match userID"})
+			continue
+		}
+
+		err = applyBulkOperation(tx, id, operation)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Error applying %s to post %d", operation.Operation, id), err)
+		}
+		results = append(results, types.BulkResult{ID: id, Status: "ok"})
+	}
+
+	err = tx.Commit()
+	invalidate(user.ID, postCacheKinds...)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Applied bulk operation", "operation", operation.Operation, "posts", len(operation.IDs))
+	return results, nil
+}
+
+func applyBulkOperation(tx *sql.Tx, id int, operation types.BulkOperation) error {
+	var err error
+	switch operation.Operation {
+	case "set_category":
+		_, err = tx.Exec("UPDATE posts SET category = ? WHERE id = ?", operation.Category, id)
+	case "archive", "unarchive":
+		_, err = tx.Exec("UPDATE posts SET archived = ? WHERE id = ?", operation.Operation == "archive", id)
+	case "add_tags":
+		for _, tag := range operation.Tags {
+			_, err = tx.Exec("INSERT OR IGNORE INTO tags (post_id, tag) VALUES (?, ?)", id, tag)
+			if err != nil {
+				return err
+			}
+		}
+	case "remove_tags":
+		for _, tag := range operation.Tags {
+			_, err = tx.Exec("DELETE FROM tags WHERE post_id = ? AND tag = ?", id, tag)
+			if err != nil {
+				return err
+			}
+		}
+	case "set_project":
+		_, err = tx.Exec("DELETE FROM posts_projects WHERE post_id = ?", id)
+		if err == nil && operation.ProjectID != "" {
+			_, err = tx.Exec("INSERT INTO posts_projects (post_id, project_uuid) VALUES (?, ?)", id, operation.ProjectID)
+		}
+	case "delete":
+		for _, query := range []string{"DELETE FROM tags WHERE post_id = ?", "DELETE FROM posts_projects WHERE post_id = ?", "DELETE FROM posts WHERE id = ?"} {
+			_, err = tx.Exec(query, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown operation: %s", operation.Operation)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE posts SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
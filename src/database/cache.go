@@ -0,0 +1,236 @@
+package database
+
+import (
+	"blog-server/types"
+	"container/list"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// the kinds of cached reads, writes invalidate the kinds they can change for the user they belong to
+const (
+	CACHE_POSTS      = "posts"
+	CACHE_POST       = "post"
+	CACHE_CATEGORIES = "categories"
+	CACHE_TAGS       = "tags"
+)
+
+// every kind a change to a post can show up in, post counts are part of categories & tags
+var postCacheKinds = []string{CACHE_POSTS, CACHE_POST, CACHE_CATEGORIES, CACHE_TAGS}
+
+// categories decide which posts are listed under a category, and renaming one renames it on its posts
+var categoryCacheKinds = []string{CACHE_CATEGORIES, CACHE_POSTS, CACHE_POST}
+
+const (
+	DEFAULT_CACHE_SIZE = 1000
+	DEFAULT_CACHE_TTL  = 5 * time.Minute
+)
+
+type cacheEntry struct {
+	key     string
+	userID  int
+	kind    string
+	value   any
+	expires time.Time
+}
+
+// readCache is a least recently used cache of database reads with a time to live, entries are indexed by user
+// so a write only has to look at the entries of the user it changed
+type readCache struct {
+	mutex    sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// the front of order is the most recently used entry
+	order   *list.List
+	entries map[string]*list.Element
+	users   map[int]map[string]*list.Element
+	// generations are bumped by invalidations, a read that started before one isn't stored
+	generations map[int]uint64
+	stats       map[string]*types.CacheKindStats
+}
+
+var cache = newReadCache(DEFAULT_CACHE_SIZE, DEFAULT_CACHE_TTL)
+
+func newReadCache(capacity int, ttl time.Duration) *readCache {
+	stats := make(map[string]*types.CacheKindStats)
+	for _, kind := range postCacheKinds {
+		stats[kind] = &types.CacheKindStats{}
+	}
+	return &readCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		order:       list.New(),
+		entries:     make(map[string]*list.Element),
+		users:       make(map[int]map[string]*list.Element),
+		generations: make(map[int]uint64),
+		stats:       stats,
+	}
+}
+
+// loadCacheConfig sizes the cache from CACHE_SIZE (entries, 0 disables caching) and CACHE_TTL (e.g. 30s)
+func loadCacheConfig() {
+	capacity, ttl := DEFAULT_CACHE_SIZE, DEFAULT_CACHE_TTL
+	if value := os.Getenv("CACHE_SIZE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Error("Invalid CACHE_SIZE, using the default", "value", value)
+		} else {
+			capacity = parsed
+		}
+	}
+	if value := os.Getenv("CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Error("Invalid CACHE_TTL, using the default", "value", value)
+		} else {
+			ttl = parsed
+		}
+	}
+	cache = newReadCache(capacity, ttl)
+	log.Info("Configured read cache", "size", capacity, "ttl", ttl)
+}
+
+// cached returns the value of a read from the cache, or loads & stores it. Values are cloned on the way out so
+// callers can't change the cached copy
+func cached[T any](userID int, kind string, query string, load func() (T, error), clone func(T) T) (T, error) {
+	key := fmt.Sprintf("%d:%s:%s", userID, kind, query)
+	if value, ok := cache.get(key, kind); ok {
+		return clone(value.(T)), nil
+	}
+
+	generation := cache.generation(userID)
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	cache.set(key, userID, kind, value, generation)
+	return clone(value), nil
+}
+
+func (c *readCache) get(key string, kind string) (any, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if ok && time.Now().After(element.Value.(*cacheEntry).expires) {
+		c.remove(element)
+		ok = false
+	}
+	if !ok {
+		c.stats[kind].Misses++
+		return nil, false
+	}
+	c.stats[kind].Hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).value, true
+}
+
+func (c *readCache) generation(userID int) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generations[userID]
+}
+
+func (c *readCache) set(key string, userID int, kind string, value any, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.capacity == 0 || c.generations[userID] != generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.order.Len() >= c.capacity {
+		c.stats[c.order.Back().Value.(*cacheEntry).kind].Evictions++
+		c.remove(c.order.Back())
+	}
+
+	element := c.order.PushFront(&cacheEntry{key: key, userID: userID, kind: kind, value: value, expires: time.Now().Add(c.ttl)})
+	c.entries[key] = element
+	if c.users[userID] == nil {
+		c.users[userID] = make(map[string]*list.Element)
+	}
+	c.users[userID][key] = element
+}
+
+// remove expects the mutex to be held
+func (c *readCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	delete(c.users[entry.userID], entry.key)
+	if len(c.users[entry.userID]) == 0 {
+		delete(c.users, entry.userID)
+	}
+}
+
+// invalidate drops the cached reads of the given kinds for a user, called by every write
+func invalidate(userID int, kinds ...string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.generations[userID]++
+	for _, element := range cache.users[userID] {
+		entry := element.Value.(*cacheEntry)
+		for _, kind := range kinds {
+			if entry.kind == kind {
+				cache.stats[kind].Invalidations++
+				cache.remove(element)
+				break
+			}
+		}
+	}
+}
+
+// invalidatePost drops the cached reads of the author of a post
+func invalidatePost(postID int) {
+	authorID, err := postAuthor(postID)
+	if err != nil {
+		log.Error("Error finding post author to invalidate cache", "id", postID, "err", err)
+		return
+	}
+	invalidate(authorID, postCacheKinds...)
+}
+
+// GetCacheStats reports the hits, misses, evictions & invalidations of each kind of cached read
+func GetCacheStats() types.CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	stats := types.CacheStats{
+		Entries:    cache.order.Len(),
+		Capacity:   cache.capacity,
+		TTLSeconds: int(cache.ttl.Seconds()),
+		Kinds:      make(map[string]types.CacheKindStats),
+	}
+	for kind, kind_stats := range cache.stats {
+		stats.Kinds[kind] = *kind_stats
+	}
+	for element := cache.order.Front(); element != nil; element = element.Next() {
+		kind := element.Value.(*cacheEntry).kind
+		kind_stats := stats.Kinds[kind]
+		kind_stats.Entries++
+		stats.Kinds[kind] = kind_stats
+	}
+	return stats
+}
+
+func clonePost(post types.Post) types.Post {
+	post.Tags = slices.Clone(post.Tags)
+	return post
+}
+
+func clonePosts(posts []types.Post) []types.Post {
+	cloned := make([]types.Post, len(posts))
+	for i, post := range posts {
+		cloned[i] = clonePost(post)
+	}
+	return cloned
+}
@@ -3,23 +3,64 @@ package database
 import (
 	"blog-server/types"
 	"blog-server/utils"
+	"database/sql"
 	"errors"
+	"maps"
+	"slices"
+
+	"github.com/charmbracelet/log"
 )
 
+const categoryColumns = "id, owner_id, name, parent, description, display_order, slug, cover_image"
+
+var ErrCategoryNotFound = errors.New("category doesn't exist")
+
+// checkCategory returns ErrCategoryNotFound unless the owner has the category, every owner has root
+func checkCategory(ownerID int, name string) error {
+	if name == "root" {
+		return nil
+	}
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE owner_id = ? AND name = ?)", ownerID, name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+func scanCategory(row interface{ Scan(...any) error }) (types.Category, error) {
+	var category types.Category
+	err := row.Scan(&category.ID, &category.OwnerID, &category.Name, &category.Parent, &category.Description, &category.DisplayOrder, &category.Slug, &category.CoverImage)
+	if err != nil {
+		return category, err
+	}
+	// categories created before slugs existed are addressed by their name
+	if category.Slug == "" {
+		category.Slug = utils.Slugify(category.Name)
+	}
+	return category, nil
+}
+
 func GetCategories(user *types.User) ([]types.Category, error) {
 	if user == nil {
 		return nil, errors.New("Invalid user reference")
 	}
+	return cached(user.ID, CACHE_CATEGORIES, "list", func() ([]types.Category, error) { return loadCategories(user) }, slices.Clone)
+}
+
+func loadCategories(user *types.User) ([]types.Category, error) {
 	var categories []types.Category
-	rows, err := db.Query("SELECT name, parent FROM categories WHERE owner_id = ?", user.ID)
+	rows, err := db.Query("SELECT "+categoryColumns+" FROM categories WHERE owner_id = ? ORDER BY display_order, name", user.ID)
 	if err != nil {
 		return categories, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var category types.Category
-		category.OwnerID = user.ID
-		err := rows.Scan(&category.Name, &category.Parent)
+		category, err := scanCategory(rows)
 		if err != nil {
 			return categories, err
 		}
@@ -32,34 +73,74 @@ func GetCategories(user *types.User) ([]types.Category, error) {
 }
 
 func GetCategory(user *types.User, name string) (types.Category, error) {
-	var category types.Category
 	if user == nil {
-		return category, errors.New("Invalid user reference")
+		return types.Category{}, errors.New("Invalid user reference")
 	}
-	err := db.QueryRow("SELECT name, parent, owner_id FROM categories WHERE owner_id = ? AND name = ?", user.ID, name).Scan(&category.Name, &category.Parent, &category.OwnerID)
-	if err != nil {
-		return category, err
-	}
-	return category, nil
+	return scanCategory(db.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE owner_id = ? AND name = ?", user.ID, name))
 
 }
 
-func ConstructCategoryGraph(categories []types.Category, root string, userID int) types.CategoryNode {
+// ConstructCategoryGraph builds the tree of categories below root, counting the posts directly in each category
+// as well as the posts in all of its children. categories are expected to already be in display order
+func ConstructCategoryGraph(categories []types.Category, root string, userID int, counts map[string]int) types.CategoryNode {
 	var node = types.CategoryNode{
-		Name:     root,
-		Children: make([]types.CategoryNode, 0),
-		OwnerID:  userID,
+		Name:      root,
+		Children:  make([]types.CategoryNode, 0),
+		OwnerID:   userID,
+		PostCount: counts[root],
 	}
+	for _, cat := range categories {
+		if cat.Name == root {
+			node.Description = cat.Description
+			node.DisplayOrder = cat.DisplayOrder
+			node.Slug = cat.Slug
+			node.CoverImage = cat.CoverImage
+		}
+	}
+	node.TotalPostCount = node.PostCount
 	for _, cat := range categories {
 		if cat.Parent == node.Name {
-			node.Children = append(node.Children, ConstructCategoryGraph(categories, cat.Name, userID))
+			child := ConstructCategoryGraph(categories, cat.Name, userID, counts)
+			node.TotalPostCount += child.TotalPostCount
+			node.Children = append(node.Children, child)
 		}
 	}
 	return node
 }
 
+// GetCategoryPostCounts counts the posts of a user in each category, the same posts /posts/{category} would return
+func GetCategoryPostCounts(user *types.User) (map[string]int, error) {
+	return cached(user.ID, CACHE_CATEGORIES, "counts", func() (map[string]int, error) { return loadCategoryPostCounts(user) }, maps.Clone)
+}
+
+func loadCategoryPostCounts(user *types.User) (map[string]int, error) {
+	counts := make(map[string]int)
+	rows, err := db.Query("SELECT category, COUNT(*) FROM posts WHERE author_id = ? GROUP BY category", user.ID)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		var count int
+		err := rows.Scan(&category, &count)
+		if err != nil {
+			return counts, err
+		}
+		counts[category] = count
+	}
+	return counts, nil
+}
+
+// CreateCategory adds a category, returning ErrCategoryNotFound if its parent doesn't exist
 func CreateCategory(category types.Category) error {
-	_, err := db.Exec(`INSERT INTO categories (owner_id, name, parent) VALUES (?, ?, ?)`, category.OwnerID, category.Name, category.Parent)
+	if err := checkCategory(category.OwnerID, category.Parent); err != nil {
+		return err
+	}
+	_, err := db.Exec(`INSERT INTO categories (owner_id, name, parent, description, display_order, slug, cover_image) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		category.OwnerID, category.Name, category.Parent, category.Description, category.DisplayOrder, category.Slug, category.CoverImage)
+	invalidate(category.OwnerID, categoryCacheKinds...)
 	return err
 }
 
@@ -99,5 +180,64 @@ func DeleteCategory(user *types.User, name string) error {
 
     query.Exec(name, user.ID);
 
+    invalidate(user.ID, categoryCacheKinds...);
     return nil;
 }
+
+// UpdateCategory renames, reparents and updates the metadata of a category, cascading a rename to its child categories & posts in one transaction
+func UpdateCategory(user *types.User, name string, updated types.Category) error {
+	if user == nil {
+		return errors.New("Invalid user reference")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE categories SET name = ?, parent = ?, description = ?, display_order = ?, slug = ?, cover_image = ? WHERE owner_id = ? AND name = ?",
+		updated.Name, updated.Parent, updated.Description, updated.DisplayOrder, updated.Slug, updated.CoverImage, user.ID, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if updated.Name != name {
+		_, err = tx.Exec("UPDATE categories SET parent = ? WHERE owner_id = ? AND parent = ?", updated.Name, user.ID, name)
+		if err != nil {
+			return err
+		}
+		// the posts change, so their version & validators do too
+		_, err = tx.Exec("UPDATE posts SET category = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE author_id = ? AND category = ?", updated.Name, user.ID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	invalidate(user.ID, categoryCacheKinds...)
+	if err == nil {
+		log.Info("Updated category", "name", name, "new_name", updated.Name, "parent", updated.Parent)
+	}
+	return err
+}
+
+// IsDescendant returns whether category is the ancestor category itself or one of its children
+func IsDescendant(categories []types.Category, ancestor string, category string) bool {
+	if category == ancestor {
+		return true
+	}
+	for _, child := range utils.GetChildrenCategories(categories, ancestor) {
+		if child.Name == category {
+			return true
+		}
+	}
+	return false
+}
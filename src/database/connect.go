@@ -27,6 +27,12 @@ func Connect() {
     }
 
     db = connection
+    loadCacheConfig();
+
+    err = hashTokens();
+    if err != nil {
+        log.Fatal("Failed to hash stored tokens.", "err", err);
+    }
 }
 
 func Connection() (*sql.DB) {
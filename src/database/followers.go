@@ -0,0 +1,93 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+	"errors"
+
+	"github.com/charmbracelet/log"
+)
+
+func GetActorKey(userID int) (*types.ActorKey, error) {
+	var key types.ActorKey
+	err := db.QueryRow("SELECT user_id, public_key, private_key, created_at FROM actor_keys WHERE user_id = ?", userID).Scan(&key.UserID, &key.PublicKey, &key.PrivateKey, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func CreateActorKey(key types.ActorKey) error {
+	// if two requests race to generate a key, the first one wins
+	_, err := db.Exec("INSERT OR IGNORE INTO actor_keys (user_id, public_key, private_key) VALUES (?, ?, ?)", key.UserID, key.PublicKey, key.PrivateKey)
+	if err == nil {
+		log.Info("Created actor key", "user_id", key.UserID)
+	}
+	return err
+}
+
+func AddFollower(follower types.Follower) error {
+	_, err := db.Exec(`
+    INSERT INTO followers (user_id, actor, inbox, shared_inbox) VALUES (?, ?, ?, ?)
+    ON CONFLICT (user_id, actor) DO UPDATE SET inbox = excluded.inbox, shared_inbox = excluded.shared_inbox`,
+		follower.UserID, follower.Actor, follower.Inbox, follower.SharedInbox)
+	if err == nil {
+		log.Info("Added follower", "user_id", follower.UserID, "actor", follower.Actor)
+	}
+	return err
+}
+
+func RemoveFollower(userID int, actor string) error {
+	_, err := db.Exec("DELETE FROM followers WHERE user_id = ? AND actor = ?", userID, actor)
+	if err == nil {
+		log.Info("Removed follower", "user_id", userID, "actor", actor)
+	}
+	return err
+}
+
+func GetFollowers(userID int) ([]types.Follower, error) {
+	var followers []types.Follower
+	rows, err := db.Query("SELECT id, user_id, actor, inbox, shared_inbox, created_at FROM followers WHERE user_id = ?", userID)
+	if err != nil {
+		return followers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var follower types.Follower
+		err := rows.Scan(&follower.ID, &follower.UserID, &follower.Actor, &follower.Inbox, &follower.SharedInbox, &follower.CreatedAt)
+		if err != nil {
+			return followers, err
+		}
+		followers = append(followers, follower)
+	}
+	if followers == nil {
+		followers = make([]types.Follower, 0)
+	}
+	return followers, nil
+}
+
+func CountFollowers(userID int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM followers WHERE user_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+func IsPostFederated(postID int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM federated_posts WHERE post_id = ?", postID).Scan(&count)
+	return count > 0, err
+}
+
+func SetPostFederated(postID int, userID int, federated bool) error {
+	var err error
+	if federated {
+		_, err = db.Exec("INSERT OR IGNORE INTO federated_posts (post_id, user_id) VALUES (?, ?)", postID, userID)
+	} else {
+		_, err = db.Exec("DELETE FROM federated_posts WHERE post_id = ?", postID)
+	}
+	return err
+}
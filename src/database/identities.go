@@ -0,0 +1,146 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+	"errors"
+
+	"github.com/charmbracelet/log"
+)
+
+var ErrIdentityLinked = errors.New("Identity is linked to another user")
+var ErrLastIdentity = errors.New("Can't remove the last identity of a user")
+
+func insertIdentity(tx *sql.Tx, identity types.Identity) error {
+	_, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, username, email, avatar_url, password_hash)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''))`,
+		identity.UserID, identity.Provider, identity.Subject, identity.Username, identity.Email, identity.AvatarURL, identity.PasswordHash)
+	return err
+}
+
+// GetUserByIdentity fetches the user a provider's login belongs to, or nil if it's a new login.
+// The identity's details are updated from the provider on each login
+func GetUserByIdentity(identity types.Identity) (*types.User, error) {
+	var userID int
+	err := db.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", identity.Provider, identity.Subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		UPDATE user_identities SET username = ?, email = ?, avatar_url = ?, last_login_at = CURRENT_TIMESTAMP
+		WHERE provider = ? AND subject = ?`,
+		identity.Username, identity.Email, identity.AvatarURL, identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByID(userID)
+}
+
+// LinkIdentity adds a provider's login to an existing user, logging in with either then logs in as the same user
+func LinkIdentity(userID int, identity types.Identity) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner int
+	err = tx.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", identity.Provider, identity.Subject).Scan(&owner)
+	if err == nil {
+		if owner != userID {
+			return ErrIdentityLinked
+		}
+		// already linked
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	identity.UserID = userID
+	err = insertIdentity(tx, identity)
+	if err != nil {
+		return err
+	}
+	if identity.Provider == "github" {
+		_, err = tx.Exec("UPDATE users SET github_id = ? WHERE user_id = ? AND github_id IS NULL", identity.Subject, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err == nil {
+		log.Info("Linked identity", "user_id", userID, "provider", identity.Provider)
+	}
+	return err
+}
+
+func GetIdentities(userID int) ([]types.Identity, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, provider, subject, username, email, avatar_url, created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]types.Identity, 0)
+	for rows.Next() {
+		var identity types.Identity
+		err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Username,
+			&identity.Email, &identity.AvatarURL, &identity.CreatedAt, &identity.LastLoginAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// DeleteIdentity unlinks a provider from a user, users need at least one identity left to log in with
+func DeleteIdentity(userID int, identityID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var provider string
+	err = tx.QueryRow("SELECT provider FROM user_identities WHERE id = ? AND user_id = ?", identityID, userID).Scan(&provider)
+	if err != nil {
+		return err
+	}
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM user_identities WHERE user_id = ?", userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastIdentity
+	}
+
+	_, err = tx.Exec("DELETE FROM user_identities WHERE id = ?", identityID)
+	if err != nil {
+		return err
+	}
+	if provider == "github" {
+		_, err = tx.Exec(`
+			UPDATE users SET github_id = (SELECT CAST(subject AS INTEGER) FROM user_identities WHERE user_id = ? AND provider = 'github' LIMIT 1)
+			WHERE user_id = ?`, userID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err == nil {
+		log.Info("Unlinked identity", "user_id", userID, "id", identityID)
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const subscriberColumns = "id, user_id, email, status, token, created_at, confirmed_at, confirmation_sent_at"
+
+func scanSubscriber(row interface{ Scan(...any) error }) (*types.Subscriber, error) {
+	var subscriber types.Subscriber
+	err := row.Scan(&subscriber.ID, &subscriber.UserID, &subscriber.Email, &subscriber.Status, &subscriber.Token, &subscriber.CreatedAt, &subscriber.ConfirmedAt, &subscriber.ConfirmationSentAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &subscriber, nil
+}
+
+func GetSubscriberByEmail(userID int, email string) (*types.Subscriber, error) {
+	return scanSubscriber(db.QueryRow("SELECT "+subscriberColumns+" FROM subscribers WHERE user_id = ? AND email = ?", userID, email))
+}
+
+func GetSubscriberByToken(token string) (*types.Subscriber, error) {
+	return scanSubscriber(db.QueryRow("SELECT "+subscriberColumns+" FROM subscribers WHERE token = ?", token))
+}
+
+// ConfirmationSentSince returns whether a confirmation email was sent to an address after the given time, for any author
+func ConfirmationSentSince(email string, since time.Time) (bool, error) {
+	var sent bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM subscribers WHERE lower(email) = lower(?) AND confirmation_sent_at > ?)", email, timestamp(&since)).Scan(&sent)
+	return sent, err
+}
+
+// UpsertSubscriber creates a pending subscriber, or resets an existing one back to pending with a fresh token, for a
+// confirmation email that's about to be sent
+func UpsertSubscriber(userID int, email string) (*types.Subscriber, error) {
+	token, err := randToken(24)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+    INSERT INTO subscribers (user_id, email, status, token, confirmation_sent_at) VALUES (?, ?, 'pending', ?, CURRENT_TIMESTAMP)
+    ON CONFLICT (user_id, email) DO UPDATE SET status = 'pending', token = excluded.token, confirmed_at = NULL,
+        confirmation_sent_at = CURRENT_TIMESTAMP`,
+		userID, email, token)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Upserted subscriber", "user_id", userID)
+	return GetSubscriberByEmail(userID, email)
+}
+
+func SetSubscriberStatus(id int, status string) error {
+	var err error
+	if status == "confirmed" {
+		_, err = db.Exec("UPDATE subscribers SET status = ?, confirmed_at = CURRENT_TIMESTAMP WHERE id = ?", status, id)
+	} else {
+		_, err = db.Exec("UPDATE subscribers SET status = ? WHERE id = ?", status, id)
+	}
+	if err == nil {
+		log.Info("Updated subscriber", "id", id, "status", status)
+	}
+	return err
+}
+
+func GetSubscribers(userID int, status string) ([]types.Subscriber, error) {
+	var subscribers []types.Subscriber
+	query := "SELECT " + subscriberColumns + " FROM subscribers WHERE user_id = ?"
+	params := []any{userID}
+	if status != "" {
+		query += " AND status = ?"
+		params = append(params, status)
+	}
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return subscribers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		subscriber, err := scanSubscriber(rows)
+		if err != nil {
+			return subscribers, err
+		}
+		subscribers = append(subscribers, *subscriber)
+	}
+	if subscribers == nil {
+		subscribers = make([]types.Subscriber, 0)
+	}
+	return subscribers, nil
+}
+
+// CreateNewsletterSend records that a post is being sent out, returning -1 if the post has already been sent
+func CreateNewsletterSend(send types.NewsletterSend) (int, error) {
+	result, err := db.Exec("INSERT OR IGNORE INTO newsletter_sends (post_id, user_id, subject) VALUES (?, ?, ?)", send.PostID, send.UserID, send.Subject)
+	if err != nil {
+		return -1, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+	if affected == 0 {
+		return -1, nil
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+func CreateNewsletterDelivery(sendID int, subscriberID int) (int, error) {
+	result, err := db.Exec("INSERT INTO newsletter_deliveries (send_id, subscriber_id) VALUES (?, ?)", sendID, subscriberID)
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+func UpdateNewsletterDelivery(id int, status string, message string) error {
+	_, err := db.Exec("UPDATE newsletter_deliveries SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", status, message, id)
+	return err
+}
+
+// GetNewsletterSends fetches the send log of a user, including the delivery status for each subscriber
+func GetNewsletterSends(userID int) ([]types.NewsletterSend, error) {
+	var sends []types.NewsletterSend
+	rows, err := db.Query(`
+    SELECT
+        newsletter_sends.id,
+        newsletter_sends.post_id,
+        newsletter_sends.user_id,
+        newsletter_sends.subject,
+        newsletter_sends.created_at,
+        IFNULL(
+            (SELECT json_group_array(json_object(
+                'id', newsletter_deliveries.id,
+                'send_id', newsletter_deliveries.send_id,
+                'subscriber_id', newsletter_deliveries.subscriber_id,
+                'email', subscribers.email,
+                'status', newsletter_deliveries.status,
+                'error', newsletter_deliveries.error,
+                'updated_at', strftime('%Y-%m-%dT%H:%M:%SZ', newsletter_deliveries.updated_at)))
+            FROM newsletter_deliveries
+            LEFT JOIN subscribers ON subscribers.id = newsletter_deliveries.subscriber_id
+            WHERE newsletter_deliveries.send_id = newsletter_sends.id),
+        '[]') AS deliveries
+    FROM
+        newsletter_sends
+    WHERE
+        newsletter_sends.user_id = ?
+    ORDER BY
+        newsletter_sends.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var send types.NewsletterSend
+		var deliveries string
+		err := rows.Scan(&send.ID, &send.PostID, &send.UserID, &send.Subject, &send.CreatedAt, &deliveries)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(deliveries), &send.Deliveries); err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+	if sends == nil {
+		sends = make([]types.NewsletterSend, 0)
+	}
+	return sends, nil
+}
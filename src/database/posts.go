@@ -4,8 +4,10 @@ import (
 	"blog-server/types"
 	"blog-server/utils"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/log"
@@ -19,10 +21,20 @@ const (
 )
 
 func FetchPost(user *types.User, identifier Identifier, needle interface{}) (types.Post, error) {
-	var post types.Post
+	return FetchPostFields(user, identifier, needle, nil)
+}
+
+// FetchPostFields fetches a post selecting only the columns of the given json fields, nil selects every field
+func FetchPostFields(user *types.User, identifier Identifier, needle interface{}, fields []string) (types.Post, error) {
 	if user == nil {
-		return post, errors.New("No user specified")
+		return types.Post{}, errors.New("No user specified")
 	}
+	query := fmt.Sprintf("%s=%v:%s", identifier, needle, strings.Join(fields, ","))
+	return cached(user.ID, CACHE_POST, query, func() (types.Post, error) { return loadPost(user, identifier, needle, fields) }, clonePost)
+}
+
+func loadPost(user *types.User, identifier Identifier, needle interface{}, fields []string) (types.Post, error) {
+	var post types.Post
 	var where string
 	if identifier == "id" {
 		where = "posts.id = ?"
@@ -30,67 +42,140 @@ func FetchPost(user *types.User, identifier Identifier, needle interface{}) (typ
 		where = "posts.slug = ?"
 	}
 
+	// a single post's description is always generated from its content
+	if slices.Contains(fields, "description") && !slices.Contains(fields, "content") {
+		fields = append(slices.Clone(fields), "content")
+	}
+	fields = selectedPostFields(fields)
 	var base = `
     SELECT
-        posts.id,
-        posts.author_id,
-        posts.slug,
-        posts.title,
-        posts.description,
-        posts.content,
-        posts.format,
-        posts.category,
-        posts.archived,
-        posts.publish_at,
-        posts.created_at, 
-        posts.updated_at,
-        GROUP_CONCAT(tags.tag) AS tags
+        ` + postColumns(fields) + `
     FROM
         posts
     LEFT JOIN
         tags ON posts.id = tags.post_id
+    LEFT JOIN
+        posts_projects ON posts.id = posts_projects.post_id
     WHERE
         posts.author_id = ? AND
-        ` + where + `;
+        ` + where + `
+    GROUP BY
+        posts.id;
     `
 	var tags sql.NullString
 
-	err := db.QueryRow(base, user.ID, needle).Scan(
-		&post.Id,
-		&post.AuthorID,
-		&post.Slug,
-		&post.Title,
-		&post.Description,
-		&post.Content,
-		&post.Format,
-		&post.Category,
-		&post.Archived,
-		&post.PublishAt,
-		&post.CreatedAt,
-		&post.UpdatedAt,
-		&tags)
-
+	err := db.QueryRow(base, user.ID, needle).Scan(postScanTargets(fields, &post, &tags)...)
 	if err != nil {
 		return post, err
 	}
 
-	if tags.Valid {
-		post.Tags = strings.Split(tags.String, ",")
-	} else {
-		post.Tags = []string{}
+	if slices.Contains(fields, "tags") {
+		post.Tags = splitTags(tags)
+	}
+	if slices.Contains(fields, "description") {
+		post.Description = utils.GetDescription(post.Content)
 	}
 
-	post.Description = utils.GetDescription(post.Content)
+	return post, nil
+}
 
-	// check for project_id link
-	post.ProjectID = GetPostProjectID(post.Id)
+// POST_FIELDS are the json fields of a post in the order they're selected
+var POST_FIELDS = []string{"id", "author_id", "slug", "title", "description", "content", "format", "category", "archived", "publish_at", "version", "created_at", "updated_at", "tags", "project_id"}
 
-	return post, nil
+// DESCRIPTION_SOURCE is selected instead of the content when only the description is wanted, it's the content of posts
+// without a description so theirs can be generated, and empty for the rest so their content isn't read
+const DESCRIPTION_SOURCE = "description_source"
+
+// selectedPostFields adds the fields that are always selected: the id, version & updated_at identify a post and its revision,
+// and descriptions are generated from the content when a post doesn't have one
+func selectedPostFields(fields []string) []string {
+	if fields == nil {
+		return POST_FIELDS
+	}
+	selected := make([]string, 0, len(fields)+4)
+	for _, field := range POST_FIELDS {
+		required := field == "id" || field == "version" || field == "updated_at"
+		if required || slices.Contains(fields, field) {
+			selected = append(selected, field)
+		}
+	}
+	if slices.Contains(fields, "description") && !slices.Contains(fields, "content") {
+		selected = append(selected, DESCRIPTION_SOURCE)
+	}
+	return selected
+}
+
+func postColumns(fields []string) string {
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i], _ = postColumn(field, nil, nil)
+	}
+	return strings.Join(columns, ", ")
 }
 
-// author_id should be inside the post object
+func postScanTargets(fields []string, post *types.Post, tags *sql.NullString) []any {
+	targets := make([]any, len(fields))
+	for i, field := range fields {
+		_, targets[i] = postColumn(field, post, tags)
+	}
+	return targets
+}
+
+// postColumn returns the column a post field is selected from, and where it's scanned into
+func postColumn(field string, post *types.Post, tags *sql.NullString) (string, any) {
+	if post == nil {
+		post = &types.Post{}
+	}
+	switch field {
+	case "id":
+		return "posts.id", &post.Id
+	case "author_id":
+		return "posts.author_id", &post.AuthorID
+	case "slug":
+		return "posts.slug", &post.Slug
+	case "title":
+		return "posts.title", &post.Title
+	case "description":
+		return "posts.description", &post.Description
+	case "content":
+		return "posts.content", &post.Content
+	case DESCRIPTION_SOURCE:
+		return "CASE WHEN posts.description = '' THEN posts.content ELSE '' END", &post.Content
+	case "format":
+		return "posts.format", &post.Format
+	case "category":
+		return "posts.category", &post.Category
+	case "archived":
+		return "posts.archived", &post.Archived
+	case "publish_at":
+		return "posts.publish_at", &post.PublishAt
+	case "version":
+		return "posts.version", &post.Version
+	case "created_at":
+		return "posts.created_at", &post.CreatedAt
+	case "updated_at":
+		return "posts.updated_at", &post.UpdatedAt
+	case "tags":
+		return "GROUP_CONCAT(tags.tag) AS tags", tags
+	case "project_id":
+		return "IFNULL(posts_projects.project_uuid, '') AS project_uuid", &post.ProjectID
+	}
+	return "", nil
+}
+
+func splitTags(tags sql.NullString) []string {
+	if tags.Valid {
+		return strings.Split(tags.String, ",")
+	}
+	return []string{}
+}
+
+// author_id should be inside the post object, ErrCategoryNotFound is returned if the author doesn't have the category
 func CreatePost(post types.Post) (int, error) {
-	var err error
+	err := checkCategory(post.AuthorID, post.Category)
+	if err != nil {
+		return -1, err
+	}
 	// Insert the new post into the database
 	_, err = db.Exec(
 		`INSERT INTO posts (author_id, slug, title, description, content, format, category, publish_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
@@ -129,12 +214,20 @@ func CreatePost(post types.Post) (int, error) {
 			return -1, err
 		}
 	}
+	invalidate(post.AuthorID, postCacheKinds...)
 	log.Info("Inserted new post", "slug", post.Slug, "id", post.Id)
 	return post.Id, err
 }
 
 func DeletePost(id int) error {
-	_, err := db.Exec("DELETE FROM posts WHERE id = ?", id)
+	// the author has to be found before the post is gone
+	authorID, err := postAuthor(id)
+	if err != nil {
+		return err
+	}
+	defer invalidate(authorID, postCacheKinds...)
+
+	_, err = db.Exec("DELETE FROM posts WHERE id = ?", id)
 	if err == nil {
 		log.Info("Deleted Post", "id", id)
 	}
@@ -146,10 +239,26 @@ func DeletePost(id int) error {
 	return err
 }
 
+var ErrVersionConflict = errors.New("post has been modified since the given version")
+var ErrSlugTaken = errors.New("slug is used by another post")
+
+// UpdatePost overwrites a post, if updatedPost.Version is set the update is only applied when it matches the stored version.
+// ErrCategoryNotFound is returned if the author doesn't have the category
 func UpdatePost(updatedPost *types.Post) error {
-	var err error
+	// a stale copy is a conflict before its category is checked, renaming a category makes its posts' copies stale
+	if updatedPost.Version > 0 {
+		var version int
+		err := db.QueryRow("SELECT version FROM posts WHERE id = ?", updatedPost.Id).Scan(&version)
+		if err == nil && version != updatedPost.Version {
+			return ErrVersionConflict
+		}
+	}
+	err := checkCategory(updatedPost.AuthorID, updatedPost.Category)
+	if err != nil {
+		return err
+	}
 	// update post
-	_, err = db.Exec(`
+	query := `
     UPDATE 
         posts 
     SET 
@@ -160,9 +269,12 @@ func UpdatePost(updatedPost *types.Post) error {
         format = ?,
         category = ?,
         archived = ?,
-        publish_at = ? 
+        publish_at = ?,
+        version = version + 1,
+        updated_at = CURRENT_TIMESTAMP
     WHERE 
-        id = ?`,
+        id = ?`
+	params := []any{
 		updatedPost.Slug,
 		updatedPost.Title,
 		updatedPost.Description,
@@ -171,10 +283,22 @@ func UpdatePost(updatedPost *types.Post) error {
 		updatedPost.Category,
 		updatedPost.Archived,
 		updatedPost.PublishAt,
-		updatedPost.Id)
+		updatedPost.Id}
+	if updatedPost.Version > 0 {
+		query += " AND version = ?"
+		params = append(params, updatedPost.Version)
+	}
+	result, err := db.Exec(query, params...)
 	if err != nil {
 		return err
 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 && updatedPost.Version > 0 {
+		return ErrVersionConflict
+	}
 	// update tags
 	_, err = db.Exec("DELETE FROM tags WHERE post_id = ?", updatedPost.Id)
 	if err != nil {
@@ -193,18 +317,37 @@ func UpdatePost(updatedPost *types.Post) error {
 
 	// update project_id link
 	err = UpdatePostProjectID(updatedPost.Id, updatedPost.ProjectID)
+	invalidate(updatedPost.AuthorID, postCacheKinds...)
 	log.Info("Updated Post", "id", updatedPost.Id)
 	return err
 }
 
-func GetPosts(user *types.User, category, tag string, limit, offset int, project_uuid string) ([]types.Post, int, error) {
+// GetPosts fetches a page of posts matching the filter, fields are the json fields to select (nil for every field)
+func GetPosts(user *types.User, filter types.PostFilter, limit, offset int, fields []string) ([]types.Post, int, error) {
+	type page struct {
+		posts []types.Post
+		total int
+	}
+	// filters hold pointers, so they're keyed by their json
+	query, err := json.Marshal([]any{filter, limit, offset, fields})
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := cached(user.ID, CACHE_POSTS, string(query), func() (page, error) {
+		posts, total, err := loadPosts(user, filter, limit, offset, fields)
+		return page{posts, total}, err
+	}, func(p page) page { return page{clonePosts(p.posts), p.total} })
+	return result.posts, result.total, err
+}
+
+func loadPosts(user *types.User, filter types.PostFilter, limit, offset int, fields []string) ([]types.Post, int, error) {
 	var posts []types.Post
 	var totalPosts int
 
-	log.Info("Searching for posts", "category", category, "tag", tag, "project_uuid", project_uuid)
+	log.Info("Searching for posts", "filter", filter)
 
 	// Step 1: Get search categories
-	search_categories, err := getSearchCategories(user, category)
+	search_categories, err := getSearchCategories(user, filter.Category)
 	if err != nil {
 		return posts, totalPosts, errors.Join(errors.New("error getting categories"), err)
 	}
@@ -212,10 +355,10 @@ func GetPosts(user *types.User, category, tag string, limit, offset int, project
 	log.Info("Searching through categories", "searchCategories", search_categories)
 
 	// Step 2: Build WHERE clause and parameters
-	where_clause, params := buildWhereClause(user.ID, search_categories, tag, project_uuid)
+	where_clause, params := buildWhereClause(user.ID, search_categories, filter)
 
 	// Step 3: Get total post count
-	totalPosts, err = getTotalPostsCount(where_clause, params, tag)
+	totalPosts, err = getTotalPostsCount(where_clause, params)
 	if err != nil {
 		return posts, totalPosts, errors.Join(errors.New("error getting total posts"), err)
 	}
@@ -223,7 +366,7 @@ func GetPosts(user *types.User, category, tag string, limit, offset int, project
 	log.Infof("Found %d posts", totalPosts)
 
 	// Step 4: Fetch paginated posts
-	posts, err = fetchPaginatedPosts(where_clause, params, limit, offset)
+	posts, err = fetchPaginatedPosts(where_clause, params, limit, offset, fields)
 	if err != nil {
 		return posts, totalPosts, errors.Join(errors.New("error fetching posts"), err)
 	}
@@ -253,7 +396,7 @@ func getSearchCategories(user *types.User, category string) ([]string, error) {
 	return search_categories, nil
 }
 
-func buildWhereClause(authorID int, categories []string, tag, project_uuid string) (string, []any) {
+func buildWhereClause(authorID int, categories []string, filter types.PostFilter) (string, []any) {
 	placeholders := make([]string, len(categories))
 	for i := range categories {
 		placeholders[i] = "?"
@@ -268,28 +411,58 @@ func buildWhereClause(authorID int, categories []string, tag, project_uuid strin
 
 	where := "posts.author_id = ? AND posts.category IN (" + inClause + ")"
 
-	if tag != "" {
-		where += " AND tags.tag = ?"
+	// tags are matched with subqueries rather than a join, so a post only ever matches once
+	for _, tag := range filter.TagsAll {
+		where += " AND EXISTS (SELECT 1 FROM tags WHERE tags.post_id = posts.id AND tags.tag = ?)"
 		params = append(params, tag)
 	}
 
-	if project_uuid != "" {
+	if len(filter.TagsAny) > 0 {
+		where += " AND EXISTS (SELECT 1 FROM tags WHERE tags.post_id = posts.id AND tags.tag IN (" + strings.TrimSuffix(strings.Repeat("?,", len(filter.TagsAny)), ",") + "))"
+		for _, tag := range filter.TagsAny {
+			params = append(params, tag)
+		}
+	}
+
+	if len(filter.TagsNone) > 0 {
+		where += " AND NOT EXISTS (SELECT 1 FROM tags WHERE tags.post_id = posts.id AND tags.tag IN (" + strings.TrimSuffix(strings.Repeat("?,", len(filter.TagsNone)), ",") + "))"
+		for _, tag := range filter.TagsNone {
+			params = append(params, tag)
+		}
+	}
+
+	if filter.Archived != nil {
+		where += " AND posts.archived = ?"
+		params = append(params, *filter.Archived)
+	}
+
+	if filter.Format != "" {
+		where += " AND posts.format = ?"
+		params = append(params, filter.Format)
+	}
+
+	if filter.PublishedAfter != nil {
+		where += " AND datetime(posts.publish_at) >= datetime(?)"
+		params = append(params, filter.PublishedAfter.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	if filter.PublishedBefore != nil {
+		where += " AND datetime(posts.publish_at) <= datetime(?)"
+		params = append(params, filter.PublishedBefore.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	if filter.ProjectID != "" {
 		where += " AND posts_projects.project_uuid = ?"
-		params = append(params, project_uuid)
+		params = append(params, filter.ProjectID)
 	}
 
 	return where, params
 }
 
-func getTotalPostsCount(where string, params []any, tag string) (int, error) {
+func getTotalPostsCount(where string, params []any) (int, error) {
 	var total int
-	var query string
 
-	if tag == "" {
-		query = "SELECT COUNT(*) FROM posts LEFT JOIN posts_projects ON posts.id = posts_projects.post_id WHERE " + where
-	} else {
-		query = "SELECT COUNT(*) FROM posts LEFT JOIN tags ON tags.post_id = posts.id LEFT JOIN posts_projects ON posts.id = posts_projects.post_id WHERE " + where
-	}
+	query := "SELECT COUNT(DISTINCT posts.id) FROM posts LEFT JOIN posts_projects ON posts.id = posts_projects.post_id WHERE " + where
 
 	err := db.QueryRow(query, params...).Scan(&total)
 	if err != nil {
@@ -299,24 +472,12 @@ func getTotalPostsCount(where string, params []any, tag string) (int, error) {
 	return total, nil
 }
 
-func fetchPaginatedPosts(where string, params []any, limit, offset int) ([]types.Post, error) {
+func fetchPaginatedPosts(where string, params []any, limit, offset int, fields []string) ([]types.Post, error) {
 	var posts []types.Post
 
+	fields = selectedPostFields(fields)
 	query := `SELECT 
-		posts.id, 
-		posts.author_id,
-		posts.slug, 
-		posts.title, 
-		posts.description,
-		posts.content, 
-		posts.format,
-		posts.category, 
-		posts.archived,
-		posts.publish_at,
-		posts.created_at, 
-		posts.updated_at,
-		GROUP_CONCAT(tags.tag) AS tags,
-		IFNULL(posts_projects.project_uuid, '') AS project_uuid
+		` + postColumns(fields) + `
 	FROM 
 		posts 
 	LEFT JOIN
@@ -341,24 +502,20 @@ func fetchPaginatedPosts(where string, params []any, limit, offset int) ([]types
 	for rows.Next() {
 		var post types.Post
 		var tags sql.NullString
-		var project_uuid string
 
-		err := rows.Scan(&post.Id, &post.AuthorID, &post.Slug, &post.Title, &post.Description, &post.Content, &post.Format, &post.Category, &post.Archived, &post.PublishAt, &post.CreatedAt, &post.UpdatedAt, &tags, &project_uuid)
+		err := rows.Scan(postScanTargets(fields, &post, &tags)...)
 		if err != nil {
 			return nil, err
 		}
 
-		if tags.Valid {
-			post.Tags = strings.Split(tags.String, ",")
-		} else {
-			post.Tags = []string{}
+		if slices.Contains(fields, "tags") {
+			post.Tags = splitTags(tags)
 		}
 
-		if post.Description == "" {
+		if slices.Contains(fields, "description") && post.Description == "" {
 			post.Description = utils.GetDescription(post.Content)
 		}
 
-		post.ProjectID = project_uuid
 		posts = append(posts, post)
 	}
 
@@ -382,9 +539,10 @@ func RemoveCategoryFromPosts(user *types.User, cat_list []string) error {
 	}
 	inClause := strings.Join(placeholders, ",")
 
-	query := fmt.Sprintf("UPDATE posts SET category = 'root' WHERE author_id = ? AND category IN (%s)", inClause)
+	query := fmt.Sprintf("UPDATE posts SET category = 'root', version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE author_id = ? AND category IN (%s)", inClause)
 	log.Info("Removing category from posts", "query", query, "params", params)
 	_, err := db.Exec(query, params...)
+	invalidate(user.ID, postCacheKinds...)
 	return err
 }
 
@@ -403,6 +561,7 @@ func GetPostsByTitle(user *types.User, title string) (*types.Post, error) {
         posts.category, 
         posts.archived,
         posts.publish_at,
+        posts.version,
         posts.created_at, 
         posts.updated_at,
         GROUP_CONCAT(tags.tag) AS tags
@@ -424,6 +583,7 @@ func GetPostsByTitle(user *types.User, title string) (*types.Post, error) {
 		&post.Category,
 		&post.Archived,
 		&post.PublishAt,
+		&post.Version,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 		&tags)
@@ -449,6 +609,12 @@ func GetPostsByTitle(user *types.User, title string) (*types.Post, error) {
 	return &post, nil
 }
 
+func postAuthor(postID int) (int, error) {
+	var authorID int
+	err := db.QueryRow("SELECT author_id FROM posts WHERE id = ?", postID).Scan(&authorID)
+	return authorID, err
+}
+
 func GetPostProjectID(postID int) string {
 	var projectID string
 	err := db.QueryRow("SELECT project_uuid FROM posts_projects WHERE post_id = ?", postID).Scan(&projectID)
@@ -464,6 +630,7 @@ func GetPostProjectID(postID int) string {
 }
 
 func UpdatePostProjectID(postID int, projectID string) error {
+	defer invalidatePost(postID)
 	_, err := db.Exec("DELETE FROM posts_projects WHERE post_id = ?", postID)
 	if err != nil {
 		return err
@@ -476,3 +643,132 @@ func UpdatePostProjectID(postID int, projectID string) error {
 	}
 	return nil
 }
+
+// GetPublishedPosts fetches the publicly visible posts of a user, these are posts that aren't archived and are past their publish date
+func GetPublishedPosts(userID int, limit, offset int) ([]types.Post, int, error) {
+	where := "posts.author_id = ? AND posts.archived = 0 AND datetime(posts.publish_at) <= datetime('now')"
+	params := []any{userID}
+
+	totalPosts, err := getTotalPostsCount(where, params)
+	if err != nil {
+		return nil, 0, errors.Join(errors.New("error getting total posts"), err)
+	}
+
+	posts, err := fetchPaginatedPosts(where, params, limit, offset, nil)
+	if err != nil {
+		return nil, 0, errors.Join(errors.New("error fetching posts"), err)
+	}
+
+	return posts, totalPosts, nil
+}
+
+// GetScheduledPosts fetches the posts that went live in the last day and haven't been sent to newsletter subscribers
+// or federated. The window stops posts published before an upgrade from being sent out all at once
+func GetScheduledPosts() ([]types.Post, error) {
+	where := `posts.archived = 0 AND datetime(posts.publish_at) <= datetime('now') AND datetime(posts.publish_at) > datetime('now', '-1 day')
+		AND (posts.id NOT IN (SELECT post_id FROM newsletter_sends) OR posts.id NOT IN (SELECT post_id FROM federated_posts))`
+	return fetchPaginatedPosts(where, nil, -1, 0, nil)
+}
+
+// PatchPost updates only the changed fields of a post within a single transaction, a version > 0 is checked against the stored version
+func PatchPost(id int, patch types.PostPatch, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sets := []string{"version = version + 1", "updated_at = CURRENT_TIMESTAMP"}
+	var params []any
+	set := func(column string, value any) {
+		sets = append(sets, column+" = ?")
+		params = append(params, value)
+	}
+	if patch.Slug != nil {
+		// slugs are unique, so a taken one is a validation error rather than a failed update
+		var taken bool
+		err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE slug = ? AND id != ?)", *patch.Slug, id).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSlugTaken
+		}
+		set("slug", *patch.Slug)
+	}
+	if patch.Title != nil {
+		set("title", *patch.Title)
+	}
+	if patch.Description != nil {
+		set("description", *patch.Description)
+	}
+	if patch.Content != nil {
+		set("content", *patch.Content)
+	}
+	if patch.Format != nil {
+		set("format", *patch.Format)
+	}
+	if patch.Category != nil {
+		set("category", *patch.Category)
+	}
+	if patch.Archived != nil {
+		set("archived", *patch.Archived)
+	}
+	if patch.PublishAt != nil {
+		set("publish_at", *patch.PublishAt)
+	}
+
+	query := "UPDATE posts SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+	params = append(params, id)
+	if version > 0 {
+		query += " AND version = ?"
+		params = append(params, version)
+	}
+	result, err := tx.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if version > 0 {
+			return ErrVersionConflict
+		}
+		return sql.ErrNoRows
+	}
+
+	if patch.Tags != nil {
+		_, err = tx.Exec("DELETE FROM tags WHERE post_id = ?", id)
+		if err != nil {
+			return err
+		}
+		for _, tag := range *patch.Tags {
+			_, err = tx.Exec("INSERT INTO tags (post_id, tag) VALUES (?, ?)", id, tag)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if patch.ProjectID != nil {
+		_, err = tx.Exec("DELETE FROM posts_projects WHERE post_id = ?", id)
+		if err != nil {
+			return err
+		}
+		if *patch.ProjectID != "" {
+			_, err = tx.Exec("INSERT INTO posts_projects (post_id, project_uuid) VALUES (?, ?)", id, *patch.ProjectID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	invalidatePost(id)
+	if err == nil {
+		log.Info("Patched Post", "id", id)
+	}
+	return err
+}
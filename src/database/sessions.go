@@ -0,0 +1,222 @@
+package database
+
+import (
+	"blog-server/types"
+	"blog-server/utils"
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// SESSION_TOUCH_INTERVAL throttles extending a session, so a busy browser doesn't write on every request
+const SESSION_TOUCH_INTERVAL = time.Minute
+
+// SessionStore is a gorilla sessions store kept in the user_sessions table. The cookie only holds the session's
+// token, so a session can be listed & revoked, and it expires once it hasn't been used for Options.MaxAge
+type SessionStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+var sessionStore *SessionStore
+
+// CreateSessionStore signs session cookies with the codecs & options of the cookie store
+func CreateSessionStore(cookies *sessions.CookieStore) {
+	sessionStore = &SessionStore{Codecs: cookies.Codecs, Options: cookies.Options}
+
+	result, err := db.Exec("DELETE FROM user_sessions WHERE expires_at <= CURRENT_TIMESTAMP")
+	if err != nil {
+		log.Fatal("Failed to delete expired sessions.", "err", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Info("Deleted expired sessions", "count", deleted)
+	}
+}
+
+func GetSessionStore() *SessionStore {
+	return sessionStore
+}
+
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session of the request's cookie. A cookie that can't be decoded, e.g. one from before sessions
+// were stored or signed with an old secret, starts a new session rather than failing the request
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.Codecs...); err != nil {
+		return session, nil
+	}
+
+	var data []byte
+	err = db.QueryRow("SELECT data FROM user_sessions WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP", hashToken(token)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if len(data) > 0 {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+			return session, err
+		}
+	}
+	session.ID = token
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session and sets its cookie, a negative MaxAge deletes the session
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := db.Exec("DELETE FROM user_sessions WHERE token_hash = ?", hashToken(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		token, err := randToken(32)
+		if err != nil {
+			return err
+		}
+		session.ID = token
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	var user_id sql.NullInt64
+	if id, ok := session.Values["user_id"].(int); ok {
+		user_id = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+	expires := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+
+	_, err := db.Exec(`
+		INSERT INTO user_sessions (token_hash, user_id, data, user_agent, ip, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (token_hash) DO UPDATE SET
+		user_id = excluded.user_id,
+		data = excluded.data,
+		user_agent = excluded.user_agent,
+		ip = excluded.ip,
+		last_active_at = CURRENT_TIMESTAMP,
+		expires_at = excluded.expires_at`,
+		hashToken(session.ID), user_id, data.Bytes(), r.UserAgent(), utils.ClientIP(r), timestamp(&expires))
+	if err != nil {
+		return err
+	}
+
+	return s.setCookie(w, session)
+}
+
+func (s *SessionStore) setCookie(w http.ResponseWriter, session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Touch slides the expiry of a session that's being used, and sends its cookie again with the new expiry
+func (s *SessionStore) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.IsNew || session.ID == "" {
+		return nil
+	}
+	expires := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	since := time.Now().Add(-SESSION_TOUCH_INTERVAL)
+	result, err := db.Exec(`
+		UPDATE user_sessions SET last_active_at = CURRENT_TIMESTAMP, expires_at = ?, ip = ?, user_agent = ?
+		WHERE token_hash = ? AND last_active_at < ?`,
+		timestamp(&expires), utils.ClientIP(r), r.UserAgent(), hashToken(session.ID), timestamp(&since))
+	if err != nil {
+		return err
+	}
+	if touched, _ := result.RowsAffected(); touched == 0 {
+		return nil
+	}
+	return s.setCookie(w, session)
+}
+
+// Renew deletes the stored session so saving it starts a new one, called on login so a session from before it
+// can't be used to ride along
+func (s *SessionStore) Renew(session *sessions.Session) error {
+	if session.ID != "" {
+		if _, err := db.Exec("DELETE FROM user_sessions WHERE token_hash = ?", hashToken(session.ID)); err != nil {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// GetSessions lists the active sessions of a user, marking the one with the current token
+func GetSessions(userID int, current string) ([]types.Session, error) {
+	rows, err := db.Query(`
+		SELECT id, token_hash, user_agent, ip, created_at, last_active_at, expires_at
+		FROM user_sessions WHERE user_id = ? AND expires_at > CURRENT_TIMESTAMP ORDER BY last_active_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]types.Session, 0)
+	for rows.Next() {
+		var session types.Session
+		var token_hash string
+		err := rows.Scan(&session.ID, &token_hash, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastActiveAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = current != "" && token_hash == hashToken(current)
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession revokes one of a user's sessions, logging that browser out
+func DeleteSession(userID int, sessionID int) error {
+	result, err := db.Exec("DELETE FROM user_sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return sql.ErrNoRows
+	}
+	log.Info("Revoked session", "user_id", userID, "id", sessionID)
+	return nil
+}
+
+// DeleteSessions revokes every session of a user except the one with the keep token, which can be ""
+func DeleteSessions(userID int, keep string) (int, error) {
+	result, err := db.Exec("DELETE FROM user_sessions WHERE user_id = ? AND token_hash != ?", userID, hashToken(keep))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	log.Info("Revoked sessions", "user_id", userID, "count", deleted)
+	return int(deleted), err
+}
@@ -2,37 +2,196 @@ package database
 
 import (
 	"blog-server/types"
+	"blog-server/utils"
+	"database/sql"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
 )
 
-func GetTags(user *types.User) ([]string, error) {
-	var tags []string
+// GetTags fetches the tags of a user along with how many posts use them & when they were first & last used.
+// Tags that are no longer on any post are only included with unused
+func GetTags(user *types.User, unused bool) ([]types.Tag, error) {
 	if user == nil {
-		return tags, errors.New("Invalid user reference")
+		return nil, errors.New("Invalid user reference")
+	}
+	return cached(user.ID, CACHE_TAGS, fmt.Sprintf("unused=%t", unused), func() ([]types.Tag, error) { return loadTags(user, unused) }, slices.Clone)
+}
+
+func loadTags(user *types.User, unused bool) ([]types.Tag, error) {
+	var tags []types.Tag
+	query := `
+    SELECT
+        user_tags.id,
+        user_tags.name,
+        user_tags.description,
+        user_tags.colour,
+        user_tags.slug,
+        COUNT(posts.id),
+        strftime('%Y-%m-%dT%H:%M:%SZ', MIN(posts.created_at)),
+        strftime('%Y-%m-%dT%H:%M:%SZ', MAX(posts.created_at))
+    FROM
+        user_tags
+    LEFT JOIN tags ON tags.tag = user_tags.name
+    LEFT JOIN posts ON posts.id = tags.post_id AND posts.author_id = user_tags.owner_id
+    WHERE
+        user_tags.owner_id = ?
+    GROUP BY
+        user_tags.id`
+	if !unused {
+		query += " HAVING COUNT(posts.id) > 0"
 	}
-	rows, err := db.Query("SELECT DISTINCT tags.tag FROM posts LEFT JOIN tags ON tags.post_id = posts.id WHERE posts.author_id = ? AND tags.tag IS NOT NULL", user.ID)
+	query += " ORDER BY user_tags.name"
+
+	rows, err := db.Query(query, user.ID)
 	if err != nil {
 		return tags, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var tag string
-		err := rows.Scan(&tag)
+		var tag types.Tag
+		var first_used, last_used sql.NullString
+		err := rows.Scan(&tag.ID, &tag.Name, &tag.Description, &tag.Colour, &tag.Slug, &tag.Count, &first_used, &last_used)
 		if err != nil {
 			return tags, err
 		}
+		tag.FirstUsed = parseTimestamp(first_used)
+		tag.LastUsed = parseTimestamp(last_used)
+		if tag.Slug == "" {
+			tag.Slug = utils.Slugify(tag.Name)
+		}
 		tags = append(tags, tag)
 	}
+	if tags == nil {
+		tags = make([]types.Tag, 0)
+	}
 
-	return tags, err
+	return tags, nil
+}
+
+func UpdateTag(user *types.User, tag types.Tag) error {
+	_, err := db.Exec("UPDATE user_tags SET description = ?, colour = ?, slug = ? WHERE owner_id = ? AND name = ?", tag.Description, tag.Colour, tag.Slug, user.ID, tag.Name)
+	invalidate(user.ID, CACHE_TAGS)
+	if err == nil {
+		log.Info("Updated tag", "name", tag.Name)
+	}
+	return err
+}
+
+// parseTimestamp parses an aggregated timestamp, which sqlite doesn't return as a time
+func parseTimestamp(value sql.NullString) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value.String)
+	if err != nil {
+		return nil
+	}
+	return &parsed
 }
 
 func CreateTag(postID int, tag string) error {
 	_, err := db.Exec("INSERT INTO tags (post_id, tag) VALUES (?, ?)", postID, tag)
-	return err
+	if err != nil {
+		return err
+	}
+	return touchPost(postID)
 }
 
 func DeleteTag(postID int, tag string) error {
 	_, err := db.Exec("DELETE FROM tags WHERE post_id = ? AND tag = ?", postID, tag)
+	if err != nil {
+		return err
+	}
+	return touchPost(postID)
+}
+
+// touchPost bumps the version of a post after its tags change, so cached copies & stale edits are detected
+func touchPost(postID int) error {
+	_, err := db.Exec("UPDATE posts SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", postID)
+	invalidatePost(postID)
 	return err
 }
+
+// ReplaceTags swaps the given tags for the into tag on every post of the user, or removes them if into is empty.
+// The affected post ids are returned, with preview they are only counted and nothing is changed.
+func ReplaceTags(user *types.User, tags []string, into string, preview bool) ([]int, error) {
+	if user == nil {
+		return nil, errors.New("Invalid user reference")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	params := []any{user.ID}
+	for _, tag := range tags {
+		params = append(params, tag)
+	}
+	in_clause := "(" + strings.TrimSuffix(strings.Repeat("?,", len(tags)), ",") + ")"
+
+	rows, err := tx.Query("SELECT DISTINCT tags.post_id FROM tags JOIN posts ON posts.id = tags.post_id WHERE posts.author_id = ? AND tags.tag IN "+in_clause+" ORDER BY tags.post_id", params...)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if preview {
+		return ids, nil
+	}
+
+	// a renamed tag keeps its metadata, unless it's merged into a tag that already has its own
+	if into != "" {
+		_, err = tx.Exec("INSERT OR IGNORE INTO user_tags (owner_id, name, description, colour) SELECT owner_id, ?, description, colour FROM user_tags WHERE owner_id = ? AND name = ?", into, user.ID, tags[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM user_tags WHERE owner_id = ? AND name IN "+in_clause+" AND name != ?", append(params, into)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if into != "" {
+			// posts that already have the into tag keep their single copy of it
+			_, err = tx.Exec("INSERT OR IGNORE INTO tags (post_id, tag) VALUES (?, ?)", id, into)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = tx.Exec("DELETE FROM tags WHERE post_id = ? AND tag IN "+in_clause+" AND tag != ?", append(append([]any{id}, params[1:]...), into)...)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec("UPDATE posts SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	invalidate(user.ID, postCacheKinds...)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Replaced tags", "tags", tags, "into", into, "posts", len(ids))
+	return ids, nil
+}
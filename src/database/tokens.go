@@ -3,9 +3,12 @@ package database
 import (
 	"blog-server/types"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 )
@@ -19,47 +22,109 @@ func randToken(n int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-func CreateToken(token types.AccessKey) (int, error) {
+// TOKEN_PREFIX_LENGTH is how much of a token's value is kept to identify it
+const TOKEN_PREFIX_LENGTH = 8
+
+// hashToken hashes the value of a token, login link, session or invite. Only the hash is stored, so a value can't be
+// retrieved again after it's created
+func hashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func tokenPrefix(value string) string {
+	return value[:min(len(value), TOKEN_PREFIX_LENGTH)]
+}
+
+// CreateToken stores a new token, returning its id & value
+func CreateToken(token types.AccessKey) (int, string, error) {
 	var err error
 	// generate key value
 	value, err := randToken(24)
 	if err != nil {
-		return -1, err
+		return -1, "", err
 	}
 
 	_, err = db.Exec(
-		`INSERT INTO access_keys (key_value, user_id, name, note, enabled) VALUES (?,?,?,?,?)`,
-		value,
+		`INSERT INTO access_keys (key_value, key_prefix, hashed, user_id, name, note, enabled, scopes, expires_at) VALUES (?,?,1,?,?,?,?,?,?)`,
+		hashToken(value),
+		tokenPrefix(value),
 		token.UserID,
 		token.Name,
 		token.Note,
-		token.Enabled)
+		token.Enabled,
+		strings.Join(token.Scopes, " "),
+		timestamp(token.ExpiresAt))
 	if err != nil {
-		return -1, err
+		return -1, "", err
 	}
-	log.Info("Created new token", "user_id", token.UserID, "value", value)
+	log.Info("Created new token", "user_id", token.UserID, "key_prefix", tokenPrefix(value))
 	var id int
 	row := db.QueryRow("SELECT last_insert_rowid()")
 	err = row.Scan(&id)
 	if err != nil {
-		return -1, err
+		return -1, "", err
 	}
-	return id, nil
+	return id, value, nil
 }
 
-func GetToken(id int) (types.AccessKey, error) {
+// timestamp formats an optional time the way sqlite's CURRENT_TIMESTAMP does, so they can be compared
+func timestamp(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// a fetched token never has its value
+const TOKEN_COLUMNS = "key_id, key_prefix, user_id, name, note, enabled, created_at, updated_at, scopes, expires_at, last_used_at, last_used_ip, previous_expires_at"
+
+// scanToken scans a row of TOKEN_COLUMNS
+func scanToken(row interface{ Scan(...any) error }) (types.AccessKey, error) {
 	var token types.AccessKey
+	var scopes string
+	var expires, last_used, previous_expires sql.NullTime
+	err := row.Scan(&token.ID, &token.Prefix, &token.UserID, &token.Name, &token.Note, &token.Enabled, &token.CreatedAt, &token.UpdatedAt,
+		&scopes, &expires, &last_used, &token.LastUsedIP, &previous_expires)
+	token.Scopes = strings.Fields(scopes)
+	token.ExpiresAt = nullTime(expires)
+	token.LastUsedAt = nullTime(last_used)
+	token.PreviousExpiresAt = nullTime(previous_expires)
+	return token, err
+}
 
-	row := db.QueryRow("SELECT * FROM access_keys WHERE key_id = ?", id)
+func GetToken(id int) (types.AccessKey, error) {
+	return scanToken(db.QueryRow("SELECT "+TOKEN_COLUMNS+" FROM access_keys WHERE key_id = ?", id))
+}
 
-	err := row.Scan(&token.ID, &token.Value, &token.UserID, &token.Name, &token.Note, &token.Enabled, &token.CreatedAt, &token.UpdatedAt)
+// GetTokenByValue fetches the token sent with a request, or nil if there is no such token.
+// The old value of a rotated token finds the token until its grace period is over
+func GetTokenByValue(value string) (*types.AccessKey, error) {
+	token, err := scanToken(db.QueryRow("SELECT "+TOKEN_COLUMNS+` FROM access_keys
+        WHERE key_value = ? OR (previous_key_value = ? AND previous_expires_at > CURRENT_TIMESTAMP)`, hashToken(value), hashToken(value)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
-		return token, err
+		return nil, err
 	}
-	return token, nil
+	return &token, nil
 }
 
+// UpdateToken updates the name, note & enabled state of a token, its scopes unless they're nil and its expiry
+// if ExpiresAtSet
 func UpdateToken(token types.AccessKey) error {
+	var scopes sql.NullString
+	if token.Scopes != nil {
+		scopes = sql.NullString{String: strings.Join(token.Scopes, " "), Valid: true}
+	}
 	_, err := db.Exec(`
     UPDATE
         access_keys
@@ -67,11 +132,13 @@ func UpdateToken(token types.AccessKey) error {
         name = ?,
         note = ?,
         enabled = ?,
+        scopes = COALESCE(?, scopes),
+        expires_at = CASE WHEN ? THEN ? ELSE expires_at END,
         updated_at = CURRENT_TIMESTAMP
     WHERE
         key_id = ? AND
         user_id = ?;
-    `, token.Name, token.Note, token.Enabled, token.ID, token.UserID)
+    `, token.Name, token.Note, token.Enabled, scopes, token.ExpiresAtSet, timestamp(token.ExpiresAt), token.ID, token.UserID)
 
 	if err != nil {
 		return err
@@ -80,6 +147,96 @@ func UpdateToken(token types.AccessKey) error {
 	return nil
 }
 
+// RotateToken gives a token a new value, the old value keeps working until the grace period is over
+func RotateToken(token types.AccessKey, grace time.Duration) (string, error) {
+	value, err := randToken(24)
+	if err != nil {
+		return "", err
+	}
+	previous_expires := time.Now().Add(grace)
+	_, err = db.Exec(`
+    UPDATE
+        access_keys
+    SET
+        previous_key_value = key_value,
+        previous_expires_at = ?,
+        key_value = ?,
+        key_prefix = ?,
+        updated_at = CURRENT_TIMESTAMP
+    WHERE
+        key_id = ? AND
+        user_id = ?;
+    `, timestamp(&previous_expires), hashToken(value), tokenPrefix(value), token.ID, token.UserID)
+	if err != nil {
+		return "", err
+	}
+	log.Info("Rotated token", "id", token.ID, "key_prefix", tokenPrefix(value), "grace", grace)
+	return value, nil
+}
+
+// hashTokens hashes the values of tokens stored before tokens were hashed, see migration 014
+func hashTokens() error {
+	rows, err := db.Query("SELECT key_id, key_value, previous_key_value FROM access_keys WHERE hashed = 0")
+	if err != nil {
+		return err
+	}
+	type plain struct {
+		id       int
+		value    string
+		previous sql.NullString
+	}
+	var tokens []plain
+	for rows.Next() {
+		var token plain
+		if err := rows.Scan(&token.id, &token.value, &token.previous); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if len(tokens) == 0 {
+		return rows.Err()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, token := range tokens {
+		var previous sql.NullString
+		if token.previous.Valid {
+			previous = sql.NullString{String: hashToken(token.previous.String), Valid: true}
+		}
+		_, err = tx.Exec("UPDATE access_keys SET key_value = ?, key_prefix = ?, previous_key_value = ?, hashed = 1 WHERE key_id = ?",
+			hashToken(token.value), tokenPrefix(token.value), previous, token.id)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err == nil {
+		log.Info("Hashed stored tokens", "count", len(tokens))
+	}
+	return err
+}
+
+// TouchToken records when & where a token was last used, at most once a minute for the same address
+func TouchToken(tokenID int, ip string) error {
+	_, err := db.Exec(`
+    UPDATE
+        access_keys
+    SET
+        last_used_at = CURRENT_TIMESTAMP,
+        last_used_ip = ?
+    WHERE
+        key_id = ? AND
+        (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute') OR last_used_ip != ?);
+    `, ip, tokenID, ip)
+	return err
+}
+
 func DeleteToken(tokenID int) error {
 	_, err := db.Exec("DELETE FROM access_keys WHERE key_id = ?", tokenID)
 	if err == nil {
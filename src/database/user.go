@@ -4,101 +4,152 @@ import (
 	"blog-server/types"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/charmbracelet/log"
 )
 
-// Example function to create a new user in the database
-func CreateUser(githubUser *types.GitHubUser) (*types.User, error) {
-	// Insert user details into 'users' table
-	result, err := db.Exec(`
-		INSERT INTO users (github_id, username, email, avatar_url)
-		VALUES (?, ?, ?, ?)`,
-		githubUser.ID, githubUser.Login, githubUser.Email, githubUser.AvatarURL,
-	)
-	if err != nil {
-		return nil, err
-	}
+const USER_COLUMNS = "user_id, github_id, username, IFNULL(email, ''), IFNULL(avatar_url, ''), role, suspended_at, created_at, updated_at"
 
-	// Retrieve the inserted user's ID
-	userID, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
-	}
+// the instance roles of users, admins manage the instance, authors write posts & readers can only read
+const (
+	ROLE_ADMIN  = "admin"
+	ROLE_AUTHOR = "author"
+	ROLE_READER = "reader"
+)
 
-	/** @todo - create root category */
+var ROLES = []string{ROLE_ADMIN, ROLE_AUTHOR, ROLE_READER}
 
-	// Return the created user
-	return &types.User{
-		ID:        int(userID),
-		GitHubID:  githubUser.ID,
-		Username:  githubUser.Login,
-		Email:     githubUser.Email,
-		AvatarURL: githubUser.AvatarURL,
-	}, nil
-}
+// USERNAME_MAX_LENGTH is the longest a username can be, including the number added to a taken one
+const USERNAME_MAX_LENGTH = 64
 
-// getUserByGitHubID retrieves a user from the database by their GitHub ID.
-func GetUserByGitHubID(githubID int) (*types.User, error) {
-	// Execute a SQL query to fetch the user by GitHub ID
-	row := db.QueryRow("SELECT * FROM users WHERE github_id = ?", githubID)
+var ErrInvalidInvite = errors.New("Invite is invalid, used or expired")
 
-	// Create a User struct to hold the fetched data
+// scanUser scans a row of USER_COLUMNS, a missing user is nil
+func scanUser(row interface{ Scan(...any) error }) (*types.User, error) {
 	var user types.User
-
-	// Scan the row's values into the user struct
-	err := row.Scan(&user.ID, &user.GitHubID, &user.Username, &user.Email, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+	var github_id sql.NullInt64
+	var suspended_at sql.NullTime
+	err := row.Scan(&user.ID, &github_id, &user.Username, &user.Email, &user.AvatarURL, &user.Role, &suspended_at, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		// Handle the case when no user with the given GitHub ID is found
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil // User not found
 		}
 		return nil, err // Other database error
 	}
-
+	if github_id.Valid {
+		id := int(github_id.Int64)
+		user.GitHubID = &id
+	}
+	user.SuspendedAt = nullTime(suspended_at)
 	return &user, nil
 }
 
-func GetUserByID(userID int) (*types.User, error) {
-	row := db.QueryRow("SELECT * FROM users WHERE user_id = ?", userID)
-	var user types.User
+// CreateUser creates a user for someone logging in for the first time, along with the identity they logged in with.
+// Usernames are unique across providers, so a taken username gets a number added. The first user is the admin,
+// otherwise an invite is used up and gives the user its role instead of the given one
+func CreateUser(identity types.Identity, role string, invite string) (*types.User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	err := row.Scan(&user.ID, &user.GitHubID, &user.Username, &user.Email, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+	var first bool
+	err = tx.QueryRow("SELECT NOT EXISTS(SELECT 1 FROM users)").Scan(&first)
 	if err != nil {
+		return nil, err
+	}
+	if first {
+		role = ROLE_ADMIN
+	} else if invite != "" {
+		err = tx.QueryRow(`
+			UPDATE invites SET used_at = CURRENT_TIMESTAMP
+			WHERE code_hash = ? AND used_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+			RETURNING role`, hashToken(invite)).Scan(&role)
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil // User not found
+			return nil, ErrInvalidInvite
+		}
+		if err != nil {
+			return nil, err
 		}
-		return nil, err // Other database error
 	}
 
-	return &user, nil
-}
+	username := identity.Username
+	for i := 2; ; i++ {
+		var taken bool
+		err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&taken)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			break
+		}
+		suffix := fmt.Sprintf("-%d", i)
+		username = identity.Username[:min(len(identity.Username), USERNAME_MAX_LENGTH-len(suffix))] + suffix
+	}
 
-func GetUserByToken(token string) (*types.User, error) {
-	row := db.QueryRow("SELECT access_keys.user_id FROM access_keys WHERE key_value = ?", token)
+	// github_id is kept for GitHub users
+	var github_id sql.NullInt64
+	if identity.Provider == "github" {
+		if id, err := strconv.ParseInt(identity.Subject, 10, 64); err == nil {
+			github_id = sql.NullInt64{Int64: id, Valid: true}
+		}
+	}
 
-	var userID int
+	// Insert user details into 'users' table
+	result, err := tx.Exec(`
+		INSERT INTO users (github_id, username, email, avatar_url, role)
+		VALUES (?, ?, ?, ?, ?)`,
+		github_id, username, identity.Email, identity.AvatarURL, role,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	err := row.Scan(&userID)
+	// Retrieve the inserted user's ID
+	userID, err := result.LastInsertId()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil // User not found
+		return nil, err
+	}
+
+	identity.UserID = int(userID)
+	err = insertIdentity(tx, identity)
+	if err != nil {
+		return nil, err
+	}
+	if invite != "" && !first {
+		_, err = tx.Exec("UPDATE invites SET used_by = ? WHERE code_hash = ?", userID, hashToken(invite))
+		if err != nil {
+			return nil, err
 		}
-		return nil, err // Other database error
 	}
 
-	return GetUserByID(userID)
+	/** @todo - create root category */
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Created user", "id", userID, "username", username, "provider", identity.Provider, "role", role)
+	return GetUserByID(int(userID))
+}
+
+func GetUserByID(userID int) (*types.User, error) {
+	return scanUser(db.QueryRow("SELECT "+USER_COLUMNS+" FROM users WHERE user_id = ?", userID))
 }
 
 func GetTokens(userID int) ([]types.AccessKey, error) {
 	var tokens []types.AccessKey
-	rows, err := db.Query("SELECT * FROM access_keys WHERE user_id = ?", userID)
+	rows, err := db.Query("SELECT "+TOKEN_COLUMNS+" FROM access_keys WHERE user_id = ?", userID)
 
 	if err != nil {
 		return tokens, err
 	}
 
 	for rows.Next() {
-		var token types.AccessKey
-		err := rows.Scan(&token.ID, &token.Value, &token.UserID, &token.Name, &token.Note, &token.Enabled, &token.CreatedAt, &token.UpdatedAt)
+		token, err := scanToken(rows)
 		if err != nil {
 			return tokens, err
 		}
@@ -109,3 +160,7 @@ func GetTokens(userID int) ([]types.AccessKey, error) {
     }
 	return tokens, err
 }
+
+func GetUserByUsername(username string) (*types.User, error) {
+	return scanUser(db.QueryRow("SELECT "+USER_COLUMNS+" FROM users WHERE username = ?", username))
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"blog-server/types"
+	"database/sql"
+)
+
+// GetContentVersion fetches the version of a user's content, which the content_versions triggers bump on every change,
+// along with when their latest published post went out, as scheduled posts are published without a change
+func GetContentVersion(userID int) (types.ContentVersion, error) {
+	version := types.ContentVersion{UserID: userID}
+	var updated_at, last_published sql.NullString
+	err := db.QueryRow(`
+    SELECT
+        IFNULL((SELECT version FROM content_versions WHERE user_id = ?), 0),
+        (SELECT strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) FROM content_versions WHERE user_id = ?),
+        (SELECT strftime('%Y-%m-%dT%H:%M:%SZ', MAX(datetime(publish_at))) FROM posts
+            WHERE author_id = ? AND archived = 0 AND datetime(publish_at) <= datetime('now'))`,
+		userID, userID, userID).Scan(&version.Version, &updated_at, &last_published)
+	if err != nil {
+		return version, err
+	}
+	version.UpdatedAt = parseTimestamp(updated_at)
+	version.LastPublished = parseTimestamp(last_published)
+	return version, nil
+}
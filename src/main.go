@@ -2,6 +2,7 @@
 package main
 
 import (
+	"blog-server/actions"
 	"blog-server/database"
 	"blog-server/routes"
 	"blog-server/types"
@@ -11,6 +12,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
 	"syscall"
 	"time"
 
@@ -30,6 +33,7 @@ func init() {
 	log.Info("Loaded .env file")
 	routes.LoadAuthConfig()
 	log.Info("Loaded auth config")
+	routes.LoadCachePolicies()
 	utils.CreateStore()
 	log.Info("Created session store")
 }
@@ -38,34 +42,65 @@ func main() {
 	log.SetLevel(log.DebugLevel)
 	// set up database
 	database.Connect()
+	database.CreateSessionStore(utils.GetStore())
+	// send out scheduled posts once they go live
+	actions.StartScheduledPublishing()
 	// set up router with auth middleware
 	r := mux.NewRouter()
 	r.Use(AuthMiddleware)
+	r.Use(routes.ValidateRequest)
+	r.Use(routes.CacheControl)
 	// posts
 	r.HandleFunc("/posts", routes.FetchPosts).Methods("GET")
 	r.HandleFunc("/posts/{category}", routes.FetchPosts).Methods("GET")
+	r.HandleFunc("/posts/bulk", routes.BulkPosts).Methods("POST")
 	// individual post functions
 	r.HandleFunc("/post/{slug}", routes.GetPostBySlug).Methods("GET")
 	r.HandleFunc("/post/new", routes.CreatePost).Methods("POST")
 	r.HandleFunc("/post/edit", routes.EditPost).Methods("PUT")
+	r.HandleFunc("/post/{id}", routes.PatchPost).Methods("PATCH")
 	r.HandleFunc("/post/delete/{id}", routes.DeletePost).Methods("DELETE")
 	// category
 	r.HandleFunc("/categories", routes.GetCategories).Methods("GET")
 	r.HandleFunc("/category/new", routes.CreateCategory).Methods("POST")
 	r.HandleFunc("/category/delete/{name}", routes.DeleteCategory).Methods("DELETE")
+	r.HandleFunc("/category/{name}", routes.UpdateCategory).Methods("PUT")
+	// graphql
+	r.HandleFunc("/graphql", routes.GraphQL).Methods("POST")
+	// cache
+	r.HandleFunc("/cache/stats", routes.GetCacheStats).Methods("GET")
+
 	// tags
 	r.HandleFunc("/post/tag", routes.AddPostTag).Methods("PUT")
 	r.HandleFunc("/post/tag", routes.DeletePostTag).Methods("DELETE")
 	r.HandleFunc("/tags", routes.GetTags).Methods("GET")
+	r.HandleFunc("/tags/merge", routes.MergeTags).Methods("POST")
+	r.HandleFunc("/tags/suggest", routes.SuggestTags).Methods("POST")
+	r.HandleFunc("/tag/{tag}", routes.RenameTag).Methods("PUT")
+	r.HandleFunc("/tag/{tag}", routes.DeleteTag).Methods("DELETE")
+	r.HandleFunc("/tag/{tag}", routes.PatchTag).Methods("PATCH")
 	// auth
 	r.HandleFunc("/auth/user", routes.GetUserInfo).Methods("GET")
-	r.HandleFunc("/auth/github/login", routes.GithubLogin).Methods("GET")
-	r.HandleFunc("/auth/github/callback", routes.GithubCallback).Methods("GET")
+	r.HandleFunc("/auth/providers", routes.GetLoginProviders).Methods("GET")
+	r.HandleFunc("/auth/identities", routes.GetIdentities).Methods("GET")
+	r.HandleFunc("/auth/identities/{id}", routes.DeleteIdentity).Methods("DELETE")
+	r.HandleFunc("/auth/register", routes.Register).Methods("POST")
+	r.HandleFunc("/auth/password/login", routes.PasswordLogin).Methods("POST")
+	r.HandleFunc("/auth/email/login", routes.SendLoginLink).Methods("POST")
+	r.HandleFunc("/auth/email/callback", routes.LoginLinkPage).Methods("GET")
+	r.HandleFunc("/auth/email/callback", routes.LoginLinkCallback).Methods("POST")
+	r.HandleFunc("/auth/{provider}/login", routes.Login).Methods("GET")
+	r.HandleFunc("/auth/{provider}/callback", routes.LoginCallback).Methods("GET")
 	r.HandleFunc("/auth/logout", routes.Logout).Methods("GET")
+	r.HandleFunc("/auth/sessions", routes.GetSessions).Methods("GET")
+	r.HandleFunc("/auth/sessions", routes.DeleteSessions).Methods("DELETE")
+	r.HandleFunc("/auth/sessions/{id}", routes.DeleteSession).Methods("DELETE")
+	r.HandleFunc("/auth/registration", routes.GetRegistration).Methods("GET")
 	// api tokens
 	r.HandleFunc("/tokens", routes.GetUserTokens).Methods("GET")
 	r.HandleFunc("/token/new", routes.CreateToken).Methods("POST")
 	r.HandleFunc("/token/edit", routes.EditToken).Methods("PUT")
+	r.HandleFunc("/token/rotate/{id}", routes.RotateToken).Methods("POST")
 	r.HandleFunc("/token/delete/{id}", routes.DeleteToken).Methods("DELETE")
 	// integrations
 	r.HandleFunc("/links", routes.GetUserIntegrations).Methods("GET")
@@ -76,13 +111,39 @@ func main() {
     r.HandleFunc("/projects", routes.GetProjects).Methods("GET")
     r.HandleFunc("/project/key", routes.SetProjectKey).Methods("PUT")
     r.HandleFunc("/project/posts/{project_id}", routes.FetchPosts).Methods("GET")
-    
+	// activitypub
+	r.HandleFunc("/.well-known/webfinger", routes.Webfinger).Methods("GET")
+	r.HandleFunc("/ap/users/{username}", routes.GetActor).Methods("GET")
+	r.HandleFunc("/ap/users/{username}/outbox", routes.GetOutbox).Methods("GET")
+	r.HandleFunc("/ap/users/{username}/followers", routes.GetFollowers).Methods("GET")
+	r.HandleFunc("/ap/users/{username}/inbox", routes.PostInbox).Methods("POST")
+	r.HandleFunc("/ap/users/{username}/posts/{id}", routes.GetArticle).Methods("GET")
+	// newsletter
+	r.HandleFunc("/newsletter/subscribe/{username}", routes.Subscribe).Methods("POST")
+	r.HandleFunc("/newsletter/confirm", routes.ConfirmSubscription).Methods("GET")
+	r.HandleFunc("/newsletter/unsubscribe", routes.UnsubscribePage).Methods("GET")
+	r.HandleFunc("/newsletter/unsubscribe", routes.Unsubscribe).Methods("POST")
+	r.HandleFunc("/newsletter/subscribers", routes.GetSubscribers).Methods("GET")
+	r.HandleFunc("/newsletter/sends", routes.GetNewsletterSends).Methods("GET")
+	// instance administration
+	r.HandleFunc("/admin/users", routes.GetUsers).Methods("GET")
+	r.HandleFunc("/admin/users/{id}", routes.UpdateUser).Methods("PATCH")
+	r.HandleFunc("/admin/users/{id}", routes.DeleteUser).Methods("DELETE")
+	r.HandleFunc("/admin/invites", routes.GetInvites).Methods("GET")
+	r.HandleFunc("/admin/invites", routes.CreateInvite).Methods("POST")
+	r.HandleFunc("/admin/invites/{id}", routes.DeleteInvite).Methods("DELETE")
+	// api description, generated from the routes above
+	r.HandleFunc("/openapi.json", routes.GetOpenAPI).Methods("GET")
+	if err := routes.LoadOpenAPI(r, isExempt); err != nil {
+		log.Fatal("Error generating OpenAPI document", "err", err)
+	}
 
 	// modify cors
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "https://f0rbit.github.io", "https://blog.forbit.dev", "http://blog.forbit.dev", "blog.forbit.dev", "http://forbit.dev", "https://forbit.dev", "http://www.forbit.dev", "https://www.forbit.dev"},
-		AllowedHeaders:   []string{"Content-Type"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "If-Match", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"ETag", "Last-Modified"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowCredentials: true,
 	})
 
@@ -114,7 +175,40 @@ func main() {
 	log.Info("Graceful shutdown complete.")
 }
 
-var EXEMPT_URL = []string{"/auth/github/login", "/auth/logout", "/auth/test", "/auth/user", "/auth/github/callback"}
+var EXEMPT_URL = []string{"/auth/providers", "/auth/register", "/auth/registration", "/auth/logout", "/auth/test", "/auth/user", "/newsletter/confirm", "/newsletter/unsubscribe", "/openapi.json"}
+
+// public routes, e.g. activitypub which is served to other servers
+var EXEMPT_PREFIX = []string{"/ap/", "/.well-known/", "/newsletter/subscribe/"}
+
+// the login routes of every provider, e.g. /auth/gitea/login, and of local accounts, e.g. /auth/password/login
+var EXEMPT_PATTERN = regexp.MustCompile(`^/auth/[^/]+/(login|callback)$`)
+
+func isExempt(path string) bool {
+	for _, url := range EXEMPT_URL {
+		if url == path {
+			return true
+		}
+	}
+	for _, prefix := range EXEMPT_PREFIX {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return EXEMPT_PATTERN.MatchString(path)
+}
+
+// forbidden responds if the user is suspended, or their role doesn't cover the route
+func forbidden(user *types.User, w http.ResponseWriter, r *http.Request) bool {
+	if user.SuspendedAt != nil {
+		utils.LogError("User is suspended", errors.New("User is suspended"), http.StatusForbidden, w)
+		return true
+	}
+	if scope := routes.RoleMissingScope(user.Role, r); scope != "" && !isExempt(r.URL.Path) {
+		utils.LogError("The "+user.Role+" role can't use the "+scope+" scope", errors.New("Role scopes don't cover the route"), http.StatusForbidden, w)
+		return true
+	}
+	return false
+}
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -123,23 +217,52 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		// first check for an "API_TOKEN" in the headers
 		auth_token := r.Header.Get(routes.AUTH_HEADER)
 		if auth_token != "" {
-			user, err := database.GetUserByToken(auth_token)
+			token, err := database.GetTokenByValue(auth_token)
+			if err != nil {
+				utils.LogError("Error fetching token", err, http.StatusNotFound, w)
+				return
+			}
+			if token == nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			if !token.Enabled {
+				http.Error(w, "Token is disabled", http.StatusUnauthorized)
+				return
+			}
+			if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+				http.Error(w, "Token has expired", http.StatusUnauthorized)
+				return
+			}
+			// tokens can only be used for the routes their scopes cover
+			if scope := routes.MissingScope(token, routes.RequiredScopes(r)); scope != "" && !isExempt(r.URL.Path) {
+				utils.LogError("Token is missing the "+scope+" scope", errors.New("Token scopes don't cover the route"), http.StatusForbidden, w)
+				return
+			}
+			user, err := database.GetUserByID(token.UserID)
 			if err != nil {
 				utils.LogError("Error fetching user by token", err, http.StatusNotFound, w)
 				return
 			}
-			if user != nil {
-				ctx := context.WithValue(r.Context(), "user", user)
-				next.ServeHTTP(w, r.WithContext(ctx))
+			// the token of a user that's gone
+			if user == nil {
+				utils.Unauthorized(w)
 				return
-			} else {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+			}
+			if forbidden(user, w, r) {
 				return
 			}
+			if err := database.TouchToken(token.ID, utils.ClientIP(r)); err != nil {
+				log.Error("Error recording token use", "id", token.ID, "err", err)
+			}
+			ctx := context.WithValue(r.Context(), "user", user)
+			ctx = context.WithValue(ctx, "token", token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
 		}
 
 		// Retrieve the user session
-		session, err := utils.GetStore().Get(r, "user-session")
+		session, err := database.GetSessionStore().Get(r, utils.USER_SESSION)
 
 		if err != nil {
 			utils.LogError("Error obtaining session", err, http.StatusInternalServerError, w)
@@ -152,14 +275,23 @@ func AuthMiddleware(next http.Handler) http.Handler {
 				utils.LogError("User not found", err, http.StatusNotFound, w)
 				return
 			}
+			if user == nil {
+				utils.Unauthorized(w)
+				return
+			}
+			if forbidden(user, w, r) {
+				return
+			}
+			// sessions expire once they haven't been used for a while
+			if err := database.GetSessionStore().Touch(r, w, session); err != nil {
+				log.Error("Error extending session", "err", err)
+			}
 		} else {
 			// check if the path is exempt from auth check
-			for _, url := range EXEMPT_URL {
-				if url == r.URL.Path {
-					ctx := context.WithValue(r.Context(), "user", user)
-					next.ServeHTTP(w, r.WithContext(ctx))
-					return
-				}
+			if isExempt(r.URL.Path) {
+				ctx := context.WithValue(r.Context(), "user", user)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
 			}
 			// otherwise return 401
 			utils.Unauthorized(w)
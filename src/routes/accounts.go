@@ -0,0 +1,426 @@
+// accounts.go
+package routes
+
+import (
+	"blog-server/actions"
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	PASSWORD_MIN_LENGTH = 8
+	// bcrypt only uses the first 72 bytes of a password
+	PASSWORD_MAX_LENGTH = 72
+	// how long an emailed login link can be used for
+	LOGIN_LINK_TTL = 15 * time.Minute
+	// another link isn't sent to the same email until this has passed, registering sends a link too
+	LOGIN_LINK_INTERVAL = time.Minute
+	// password logins are refused once an email, or an address, has this many failed logins within the window
+	LOGIN_ATTEMPT_WINDOW     = 15 * time.Minute
+	LOGIN_ATTEMPTS_PER_EMAIL = 5
+	LOGIN_ATTEMPTS_PER_IP    = 20
+)
+
+// usernames are part of urls, e.g. the activitypub actor
+var usernamePattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z0-9_.-]{1,%d}$`, database.USERNAME_MAX_LENGTH))
+var usernameInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// passwordLogin & magicLinkLogin are set by AUTH_PASSWORD & AUTH_MAGIC_LINK, both are off by default
+var passwordLogin, magicLinkLogin bool
+
+// dummyHash is checked against when there isn't an account, so a login takes as long either way
+var dummyHash string
+
+func loadLocalAccounts() {
+	passwordLogin = os.Getenv("AUTH_PASSWORD") == "true"
+	magicLinkLogin = os.Getenv("AUTH_MAGIC_LINK") == "true"
+	if magicLinkLogin && !utils.MailEnabled() {
+		log.Error("AUTH_MAGIC_LINK needs an SMTP relay, magic links are disabled")
+		magicLinkLogin = false
+	}
+	// registering emails a link to confirm the email is the registrant's
+	if passwordLogin && !utils.MailEnabled() {
+		log.Error("AUTH_PASSWORD needs an SMTP relay, passwords are disabled")
+		passwordLogin = false
+	}
+	if passwordLogin {
+		var err error
+		dummyHash, err = utils.HashPassword("dummy password")
+		if err != nil {
+			log.Fatal("Error hashing dummy password", "err", err)
+		}
+	}
+}
+
+// parseEmail returns the lowercased address of an email, or "" if it isn't one
+func parseEmail(raw string) string {
+	address, err := mail.ParseAddress(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(address.Address)
+}
+
+// cleanUsername makes a provider's username match usernamePattern, replacing the characters it doesn't allow with
+// dashes. It's empty if there's nothing left
+func cleanUsername(username string) string {
+	username = strings.Trim(usernameInvalid.ReplaceAllString(username, "-"), "-.")
+	return strings.TrimRight(username[:min(len(username), database.USERNAME_MAX_LENGTH)], "-.")
+}
+
+// validateUsername checks a new account's username is valid & not taken
+func validateUsername(username string) ([]types.FieldError, error) {
+	if !usernamePattern.MatchString(username) {
+		return []types.FieldError{{Field: "username", Message: "must be 1-64 letters, numbers, '.', '_' or '-'"}}, nil
+	}
+	existing, err := database.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return []types.FieldError{{Field: "username", Message: "is taken"}}, nil
+	}
+	return nil, nil
+}
+
+// checkInvite checks the invite of a sign up is valid, before a link to finish signing up is sent
+func checkInvite(invite string) error {
+	if invite == "" {
+		return nil
+	}
+	valid, err := database.ValidInvite(invite)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return database.ErrInvalidInvite
+	}
+	return nil
+}
+
+// POST /auth/register
+// The account is created when the emailed link is used, so only the owner of an email can set its password. The
+// response is the same whether or not the email has an account, so it can't be used to find out who has one
+func Register(w http.ResponseWriter, r *http.Request) {
+	if !passwordLogin {
+		utils.LogError("Password login is not enabled", errors.New("AUTH_PASSWORD isn't set"), http.StatusNotFound, w)
+		return
+	}
+
+	var body types.RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
+		return
+	}
+	if err := canSignUp(body.Invite); err != nil {
+		loginError("Error checking registration", err, w)
+		return
+	}
+	if err := checkInvite(body.Invite); err != nil {
+		loginError("Error checking invite", err, w)
+		return
+	}
+
+	fields, err := validateUsername(body.Username)
+	if err != nil {
+		utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+		return
+	}
+	email := parseEmail(body.Email)
+	if email == "" {
+		fields = append(fields, types.FieldError{Field: "email", Message: "is not a valid email"})
+	}
+	if len(body.Password) < PASSWORD_MIN_LENGTH || len(body.Password) > PASSWORD_MAX_LENGTH {
+		fields = append(fields, types.FieldError{Field: "password", Message: "must be 8-72 characters"})
+	}
+	return_to := returnURL(body.ReturnTo)
+	if body.ReturnTo != "" && return_to == "" {
+		fields = append(fields, types.FieldError{Field: "return_to", Message: "is not an allowed url"})
+	}
+	if len(fields) > 0 {
+		utils.ValidationError("Invalid account", fields, w)
+		return
+	}
+
+	account, err := database.GetLocalAccount(email)
+	if err != nil {
+		utils.LogError("Error fetching account", err, http.StatusInternalServerError, w)
+		return
+	}
+	if account != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	sent, err := database.LoginLinkSentSince(email, time.Now().Add(-LOGIN_LINK_INTERVAL))
+	if err != nil {
+		utils.LogError("Error fetching login links", err, http.StatusInternalServerError, w)
+		return
+	}
+	if sent {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	hash, err := utils.HashPassword(body.Password)
+	if err != nil {
+		utils.LogError("Error hashing password", err, http.StatusInternalServerError, w)
+		return
+	}
+	token, err := database.CreateLoginLink(types.LoginLink{
+		Email:        email,
+		Username:     body.Username,
+		Invite:       body.Invite,
+		PasswordHash: hash,
+		ReturnTo:     return_to,
+		ExpiresAt:    time.Now().Add(LOGIN_LINK_TTL),
+	})
+	if err != nil {
+		utils.LogError("Error creating login link", err, http.StatusInternalServerError, w)
+		return
+	}
+	err = actions.SendLoginLink(email, body.Username, token)
+	if err != nil {
+		utils.LogError("Error sending login link", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// POST /auth/password/login
+func PasswordLogin(w http.ResponseWriter, r *http.Request) {
+	if !passwordLogin {
+		utils.LogError("Password login is not enabled", errors.New("AUTH_PASSWORD isn't set"), http.StatusNotFound, w)
+		return
+	}
+
+	var body types.PasswordLoginRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
+		return
+	}
+
+	email, ip := parseEmail(body.Email), utils.ClientIP(r)
+	by_email, by_ip, err := database.FailedLoginsSince(email, ip, time.Now().Add(-LOGIN_ATTEMPT_WINDOW))
+	if err != nil {
+		utils.LogError("Error fetching failed logins", err, http.StatusInternalServerError, w)
+		return
+	}
+	if by_email >= LOGIN_ATTEMPTS_PER_EMAIL || by_ip >= LOGIN_ATTEMPTS_PER_IP {
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	account, err := database.GetLocalAccount(email)
+	if err != nil {
+		utils.LogError("Error fetching account", err, http.StatusInternalServerError, w)
+		return
+	}
+	hash := dummyHash
+	if account != nil && account.PasswordHash != "" {
+		hash = account.PasswordHash
+	}
+	valid, err := utils.CheckPassword(body.Password, hash)
+	if err != nil {
+		utils.LogError("Error checking password", err, http.StatusInternalServerError, w)
+		return
+	}
+	if !valid || hash == dummyHash {
+		if err := database.RecordFailedLogin(email, ip); err != nil {
+			log.Error("Error recording failed login", "email", email, "err", err)
+		}
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	if err := database.ClearFailedLogins(email); err != nil {
+		log.Error("Error clearing failed logins", "email", email, "err", err)
+	}
+
+	user, err := database.GetUserByID(account.UserID)
+	if err != nil {
+		utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+		return
+	}
+	if err := database.TouchIdentity(account.ID); err != nil {
+		log.Error("Error recording login", "id", account.ID, "err", err)
+	}
+	if err := startSession(w, r, user); err != nil {
+		loginError("Couldn't save session", err, w)
+		return
+	}
+	utils.ResponseJSON(user, w)
+}
+
+// POST /auth/email/login
+// The response is the same whether or not the email has an account, so it can't be used to find out who has one
+func SendLoginLink(w http.ResponseWriter, r *http.Request) {
+	if !magicLinkLogin {
+		utils.LogError("Magic link login is not enabled", errors.New("AUTH_MAGIC_LINK isn't set"), http.StatusNotFound, w)
+		return
+	}
+
+	var body types.LoginLinkRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
+		return
+	}
+
+	var fields []types.FieldError
+	email := parseEmail(body.Email)
+	if email == "" {
+		fields = append(fields, types.FieldError{Field: "email", Message: "is not a valid email"})
+	}
+	return_to := returnURL(body.ReturnTo)
+	if return_to == "" {
+		fields = append(fields, types.FieldError{Field: "return_to", Message: "is not an allowed url"})
+	}
+	if len(fields) > 0 {
+		utils.ValidationError("Invalid login link request", fields, w)
+		return
+	}
+
+	account, err := database.GetLocalAccount(email)
+	if err != nil {
+		utils.LogError("Error fetching account", err, http.StatusInternalServerError, w)
+		return
+	}
+	username, invite := "", ""
+	if account == nil {
+		if body.Username == "" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		// signing up, the username & invite are checked again when the link is used
+		if err := canSignUp(body.Invite); err != nil {
+			loginError("Error checking registration", err, w)
+			return
+		}
+		if err := checkInvite(body.Invite); err != nil {
+			loginError("Error checking invite", err, w)
+			return
+		}
+		fields, err := validateUsername(body.Username)
+		if err != nil {
+			utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+			return
+		}
+		if len(fields) > 0 {
+			utils.ValidationError("Invalid login link request", fields, w)
+			return
+		}
+		username, invite = body.Username, body.Invite
+	}
+
+	sent, err := database.LoginLinkSentSince(email, time.Now().Add(-LOGIN_LINK_INTERVAL))
+	if err != nil {
+		utils.LogError("Error fetching login links", err, http.StatusInternalServerError, w)
+		return
+	}
+	if sent {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := database.CreateLoginLink(types.LoginLink{
+		Email:     email,
+		Username:  username,
+		Invite:    invite,
+		ReturnTo:  return_to,
+		ExpiresAt: time.Now().Add(LOGIN_LINK_TTL),
+	})
+	if err != nil {
+		utils.LogError("Error creating login link", err, http.StatusInternalServerError, w)
+		return
+	}
+	err = actions.SendLoginLink(email, username, token)
+	if err != nil {
+		utils.LogError("Error sending login link", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GET /auth/email/callback
+// Opening a login link only shows a page that posts its token, so a mail scanner following it doesn't use it up
+func LoginLinkPage(w http.ResponseWriter, r *http.Request) {
+	if !magicLinkLogin && !passwordLogin {
+		utils.LogError("Local accounts are not enabled", errors.New("AUTH_MAGIC_LINK & AUTH_PASSWORD aren't set"), http.StatusNotFound, w)
+		return
+	}
+	utils.ConfirmForm("Continue to log in, the link can only be used once.", "Log in", r.URL.Query().Get("token"), w)
+}
+
+// POST /auth/email/callback
+// Login links are also sent to confirm the email of a registration, which creates the account with its password
+func LoginLinkCallback(w http.ResponseWriter, r *http.Request) {
+	if !magicLinkLogin && !passwordLogin {
+		utils.LogError("Local accounts are not enabled", errors.New("AUTH_MAGIC_LINK & AUTH_PASSWORD aren't set"), http.StatusNotFound, w)
+		return
+	}
+
+	link, err := database.UseLoginLink(r.PostFormValue("token"))
+	if err != nil {
+		utils.LogError("Error fetching login link", err, http.StatusInternalServerError, w)
+		return
+	}
+	if link == nil {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+
+	account, err := database.GetLocalAccount(link.Email)
+	if err != nil {
+		utils.LogError("Error fetching account", err, http.StatusInternalServerError, w)
+		return
+	}
+	var user *types.User
+	if account != nil {
+		user, err = database.GetUserByID(account.UserID)
+		if err == nil {
+			err = database.TouchIdentity(account.ID)
+		}
+	} else if link.Username != "" {
+		// a taken username gets a number added, like the first login with a provider
+		user, err = createUser(types.Identity{
+			Provider:     database.EMAIL_PROVIDER,
+			Subject:      link.Email,
+			Username:     link.Username,
+			Email:        link.Email,
+			PasswordHash: link.PasswordHash,
+		}, link.Invite)
+	} else {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		loginError("Couldn't get/create user row", err, w)
+		return
+	}
+
+	if err := startSession(w, r, user); err != nil {
+		loginError("Couldn't save session", err, w)
+		return
+	}
+
+	return_to := link.ReturnTo
+	if returnURL(return_to) == "" {
+		return_to = os.Getenv("CLIENT_URL")
+	}
+	http.Redirect(w, r, return_to, http.StatusSeeOther)
+}
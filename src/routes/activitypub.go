@@ -0,0 +1,285 @@
+// activitypub.go
+package routes
+
+import (
+	"blog-server/actions"
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/mux"
+)
+
+const OUTBOX_PAGE_SIZE = 20
+
+// GET /.well-known/webfinger
+func Webfinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	account, found := strings.CutPrefix(resource, "acct:")
+	if !found {
+		utils.LogError("Invalid resource", errors.New("Webfinger resource isn't an acct: uri"), http.StatusBadRequest, w)
+		return
+	}
+
+	username, host, _ := strings.Cut(account, "@")
+	if host != actions.ServerHost() {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	response := types.WebFinger{
+		Subject: resource,
+		Aliases: []string{actions.ActorURL(user.Username)},
+		Links: []types.WebFingerLink{
+			{Rel: "self", Type: actions.ACTIVITY_CONTENT_TYPE, Href: actions.ActorURL(user.Username)},
+		},
+	}
+
+	utils.ResponseContentType(response, "application/jrd+json", w)
+}
+
+// GET /ap/users/{username}
+func GetActor(w http.ResponseWriter, r *http.Request) {
+	user := getActorUser(w, r)
+	if user == nil {
+		return
+	}
+	if contentNotModified(w, r, user.ID) {
+		return
+	}
+
+	key, err := actions.GetActorKey(user.ID)
+	if err != nil {
+		utils.LogError("Error fetching actor key", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseContentType(actions.BuildActor(user, key), actions.ACTIVITY_CONTENT_TYPE, w)
+}
+
+// GET /ap/users/{username}/outbox
+func GetOutbox(w http.ResponseWriter, r *http.Request) {
+	user := getActorUser(w, r)
+	if user == nil {
+		return
+	}
+	if contentNotModified(w, r, user.ID) {
+		return
+	}
+
+	outbox_url := actions.ActorURL(user.Username) + "/outbox"
+	page_str := r.URL.Query().Get("page")
+
+	// without a page we only describe the collection
+	if page_str == "" {
+		_, total, err := database.GetPublishedPosts(user.ID, 0, 0)
+		if err != nil {
+			utils.LogError("Error fetching published posts", err, http.StatusInternalServerError, w)
+			return
+		}
+		response := types.OrderedCollection{
+			Context:    actions.ACTIVITY_CONTEXT,
+			ID:         outbox_url,
+			Type:       "OrderedCollection",
+			TotalItems: total,
+			First:      outbox_url + "?page=1",
+		}
+		utils.ResponseContentType(response, actions.ACTIVITY_CONTENT_TYPE, w)
+		return
+	}
+
+	page, err := strconv.Atoi(page_str)
+	if err != nil || page < 1 {
+		utils.LogError("Invalid page", err, http.StatusBadRequest, w)
+		return
+	}
+
+	posts, total, err := database.GetPublishedPosts(user.ID, OUTBOX_PAGE_SIZE, (page-1)*OUTBOX_PAGE_SIZE)
+	if err != nil {
+		utils.LogError("Error fetching published posts", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	items := make([]interface{}, 0, len(posts))
+	for _, post := range posts {
+		article := actions.BuildArticle(user, post)
+		activity := actions.BuildActivity(user, "Create", article, article.ID)
+		activity.Context = nil
+		// the outbox should always describe the same activity for a post
+		activity.ID = article.ID + "#create"
+		activity.Published = article.Published
+		items = append(items, activity)
+	}
+
+	response := types.OrderedCollection{
+		Context:      actions.ACTIVITY_CONTEXT,
+		ID:           fmt.Sprintf("%s?page=%d", outbox_url, page),
+		Type:         "OrderedCollectionPage",
+		TotalItems:   total,
+		PartOf:       outbox_url,
+		OrderedItems: items,
+	}
+	if page*OUTBOX_PAGE_SIZE < total {
+		response.Next = fmt.Sprintf("%s?page=%d", outbox_url, page+1)
+	}
+
+	utils.ResponseContentType(response, actions.ACTIVITY_CONTENT_TYPE, w)
+}
+
+// GET /ap/users/{username}/followers
+func GetFollowers(w http.ResponseWriter, r *http.Request) {
+	user := getActorUser(w, r)
+	if user == nil {
+		return
+	}
+	if contentNotModified(w, r, user.ID) {
+		return
+	}
+
+	count, err := database.CountFollowers(user.ID)
+	if err != nil {
+		utils.LogError("Error counting followers", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	// we only publish the count, not who the followers are
+	response := types.OrderedCollection{
+		Context:    actions.ACTIVITY_CONTEXT,
+		ID:         actions.ActorURL(user.Username) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: count,
+	}
+
+	utils.ResponseContentType(response, actions.ACTIVITY_CONTENT_TYPE, w)
+}
+
+// GET /ap/users/{username}/posts/{id}
+func GetArticle(w http.ResponseWriter, r *http.Request) {
+	user := getActorUser(w, r)
+	if user == nil {
+		return
+	}
+	if contentNotModified(w, r, user.ID) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing post ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	post, err := database.FetchPost(user, database.ID, id)
+	if err != nil || !utils.IsPublished(post) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	article := actions.BuildArticle(user, post)
+	article.Context = actions.ACTIVITY_CONTEXT
+
+	utils.ResponseContentType(article, actions.ACTIVITY_CONTENT_TYPE, w)
+}
+
+// POST /ap/users/{username}/inbox
+func PostInbox(w http.ResponseWriter, r *http.Request) {
+	user := getActorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		utils.LogError("Error reading body", err, http.StatusBadRequest, w)
+		return
+	}
+
+	remote, err := actions.VerifyRequest(r, body)
+	if err != nil {
+		utils.LogError("Invalid signature", err, http.StatusUnauthorized, w)
+		return
+	}
+
+	var activity types.Activity
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		utils.LogError("Error decoding activity", err, http.StatusBadRequest, w)
+		return
+	}
+
+	if activity.Actor != remote.ID {
+		utils.LogError("Invalid actor", errors.New("Activity actor doesn't match signing actor"), http.StatusUnauthorized, w)
+		return
+	}
+
+	log.Info("Received activity", "type", activity.Type, "actor", activity.Actor, "user_id", user.ID)
+
+	switch activity.Type {
+	case "Follow":
+		if activityObjectID(activity.Object) != actions.ActorURL(user.Username) {
+			utils.LogError("Invalid follow object", errors.New("Follow object isn't this actor"), http.StatusBadRequest, w)
+			return
+		}
+		err = actions.HandleFollow(user, activity, remote)
+		if err != nil {
+			utils.LogError("Error handling follow", err, http.StatusInternalServerError, w)
+			return
+		}
+	case "Undo":
+		// we only care about undoing follows, an undo with an unknown actor is a no-op
+		object, ok := activity.Object.(map[string]interface{})
+		if ok && object["type"] == "Follow" {
+			err = database.RemoveFollower(user.ID, remote.ID)
+			if err != nil {
+				utils.LogError("Error removing follower", err, http.StatusInternalServerError, w)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getActorUser looks up the user from the {username} route variable, writing a 404 if they don't exist
+func getActorUser(w http.ResponseWriter, r *http.Request) *types.User {
+	user, err := database.GetUserByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+		return nil
+	}
+	if user == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	return user
+}
+
+// activityObjectID returns the id of an activity's object, which can either be inlined or just the id
+func activityObjectID(object interface{}) string {
+	switch value := object.(type) {
+	case string:
+		return value
+	case map[string]interface{}:
+		id, _ := value["id"].(string)
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,283 @@
+// admin.go
+package routes
+
+import (
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/mux"
+)
+
+// the registration modes, set by REGISTRATION
+const (
+	REGISTRATION_OPEN   = "open"
+	REGISTRATION_INVITE = "invite"
+	REGISTRATION_CLOSED = "closed"
+)
+
+// registration decides who can sign up, open by default as anyone could sign up before there were modes
+var registration = REGISTRATION_OPEN
+
+// defaultRole is the role of users that sign up without an invite, set by DEFAULT_ROLE
+var defaultRole = database.ROLE_AUTHOR
+
+var ErrRegistrationClosed = errors.New("Registration is closed")
+var ErrInviteRequired = errors.New("Registration needs an invite")
+var ErrUserSuspended = errors.New("User is suspended")
+
+func loadRegistration() {
+	registration = REGISTRATION_OPEN
+	if mode := os.Getenv("REGISTRATION"); mode != "" {
+		registration = mode
+	}
+	if !slices.Contains([]string{REGISTRATION_OPEN, REGISTRATION_INVITE, REGISTRATION_CLOSED}, registration) {
+		log.Error("Invalid REGISTRATION, registration is closed", "value", registration)
+		registration = REGISTRATION_CLOSED
+	}
+
+	defaultRole = database.ROLE_AUTHOR
+	if role := os.Getenv("DEFAULT_ROLE"); role != "" {
+		if role == database.ROLE_AUTHOR || role == database.ROLE_READER {
+			defaultRole = role
+		} else {
+			log.Error("Invalid DEFAULT_ROLE, using author", "value", role)
+		}
+	}
+	log.Info("Loaded registration", "mode", registration, "default_role", defaultRole)
+}
+
+// canSignUp checks someone new can sign up with the invite, the first user can always sign up
+func canSignUp(invite string) error {
+	if registration == REGISTRATION_OPEN || (registration == REGISTRATION_INVITE && invite != "") {
+		return nil
+	}
+	has_users, err := database.HasUsers()
+	if err != nil || !has_users {
+		return err
+	}
+	if registration == REGISTRATION_CLOSED {
+		return ErrRegistrationClosed
+	}
+	return ErrInviteRequired
+}
+
+// createUser signs up the user of a new login, following the registration mode
+func createUser(identity types.Identity, invite string) (*types.User, error) {
+	if err := canSignUp(invite); err != nil {
+		return nil, err
+	}
+	return database.CreateUser(identity, defaultRole, invite)
+}
+
+// loginError responds to a login that failed, sign ups that aren't allowed & suspended users are forbidden
+func loginError(message string, err error, w http.ResponseWriter) {
+	switch {
+	case errors.Is(err, ErrRegistrationClosed), errors.Is(err, ErrInviteRequired), errors.Is(err, database.ErrInvalidInvite), errors.Is(err, ErrUserSuspended):
+		utils.LogError(err.Error(), err, http.StatusForbidden, w)
+	default:
+		utils.LogError(message, err, http.StatusInternalServerError, w)
+	}
+}
+
+// GET /auth/registration
+func GetRegistration(w http.ResponseWriter, r *http.Request) {
+	utils.ResponseJSON(map[string]string{"mode": registration}, w)
+}
+
+// adminUser returns the logged in user if they're an admin, otherwise it responds & returns nil
+func adminUser(w http.ResponseWriter, r *http.Request) *types.User {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return nil
+	}
+	if user.Role != database.ROLE_ADMIN {
+		utils.LogError("Only admins can manage the instance", errors.New("User isn't an admin"), http.StatusForbidden, w)
+		return nil
+	}
+	return user
+}
+
+// GET /admin/users
+func GetUsers(w http.ResponseWriter, r *http.Request) {
+	if adminUser(w, r) == nil {
+		return
+	}
+
+	users, err := database.GetUsers()
+	if err != nil {
+		utils.LogError("Error fetching users", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(users, w)
+}
+
+// PATCH /admin/users/{id}
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if adminUser(w, r) == nil {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing user ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	var update types.UserUpdate
+	err = json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
+		return
+	}
+	if update.Role != nil && !slices.Contains(database.ROLES, *update.Role) {
+		utils.ValidationError("Invalid user update", []types.FieldError{{Field: "role", Message: "is not a known role"}}, w)
+		return
+	}
+
+	err = database.UpdateUser(id, update)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.LogError("User not found", err, http.StatusNotFound, w)
+		return
+	}
+	if errors.Is(err, database.ErrLastAdmin) {
+		utils.LogError("Can't demote or suspend the last admin", err, http.StatusConflict, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error updating user", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	user, err := database.GetUserByID(id)
+	if err != nil {
+		utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+		return
+	}
+	utils.ResponseJSON(user, w)
+}
+
+// DELETE /admin/users/{id}
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if adminUser(w, r) == nil {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing user ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	err = database.DeleteUser(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.LogError("User not found", err, http.StatusNotFound, w)
+		return
+	}
+	if errors.Is(err, database.ErrLastAdmin) {
+		utils.LogError("Can't delete the last admin", err, http.StatusConflict, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error deleting user", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET /admin/invites
+func GetInvites(w http.ResponseWriter, r *http.Request) {
+	if adminUser(w, r) == nil {
+		return
+	}
+
+	invites, err := database.GetInvites()
+	if err != nil {
+		utils.LogError("Error fetching invites", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(invites, w)
+}
+
+// POST /admin/invites
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	user := adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var invite types.Invite
+	err := json.NewDecoder(r.Body).Decode(&invite)
+	if err != nil {
+		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
+		return
+	}
+	if invite.Role == "" {
+		invite.Role = defaultRole
+	}
+
+	var fields []types.FieldError
+	if !slices.Contains(database.ROLES, invite.Role) {
+		fields = append(fields, types.FieldError{Field: "role", Message: "is not a known role"})
+	}
+	if invite.ExpiresAt != nil && invite.ExpiresAt.Before(time.Now()) {
+		fields = append(fields, types.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	if len(fields) > 0 {
+		utils.ValidationError("Invalid invite", fields, w)
+		return
+	}
+
+	invite.CreatedBy = user.ID
+	id, code, err := database.CreateInvite(invite)
+	if err != nil {
+		utils.LogError("Error creating invite", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	created, err := database.GetInvite(id)
+	if err != nil {
+		utils.LogError("Error fetching invite", err, http.StatusInternalServerError, w)
+		return
+	}
+	created.Code = code
+	utils.ResponseJSON(created, w)
+}
+
+// DELETE /admin/invites/{id}
+func DeleteInvite(w http.ResponseWriter, r *http.Request) {
+	if adminUser(w, r) == nil {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing invite ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	err = database.DeleteInvite(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.LogError("Invite not found", err, http.StatusNotFound, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error deleting invite", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -4,30 +4,83 @@ import (
 	"blog-server/database"
 	"blog-server/types"
 	"blog-server/utils"
-	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
 )
 
-var authConfig *oauth2.Config
+// returnOrigins are the origins a login can return to, the CLIENT_URL and those in AUTH_RETURN_URLS
+var returnOrigins []string
 
 func LoadAuthConfig() {
-	authConfig = &oauth2.Config{
-		ClientID:     os.Getenv("GITHUB_CLIENT"),
-		ClientSecret: os.Getenv("GITHUB_SECRET"),
-		RedirectURL:  os.Getenv("GITHUB_CALLBACK"),
-		Scopes:       []string{"read:user"},
-		Endpoint:     github.Endpoint,
+	loadProviders()
+	loadLocalAccounts()
+	loadRegistration()
+
+	returnOrigins = nil
+	for _, allowed := range append([]string{os.Getenv("CLIENT_URL")}, strings.Split(os.Getenv("AUTH_RETURN_URLS"), ",")...) {
+		if origin := urlOrigin(strings.TrimSpace(allowed)); origin != "" {
+			returnOrigins = append(returnOrigins, origin)
+		}
 	}
 }
 
 const AUTH_HEADER = "Auth-Token"
 
+const (
+	// the cookie holding the state, PKCE verifier & return url between the login & callback
+	OAUTH_STATE_SESSION = "oauth-state"
+	OAUTH_STATE_MAX_AGE = 10 * 60
+)
+
+// urlOrigin returns the scheme & host of an absolute url, or "" if it isn't one
+func urlOrigin(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// returnURL resolves the url to send the user to after logging in, paths are relative to the CLIENT_URL.
+// Urls on origins that aren't allowed return ""
+func returnURL(raw string) string {
+	client := os.Getenv("CLIENT_URL")
+	if raw == "" {
+		return client
+	}
+	// "//host" & "/\host" are protocol relative urls to other hosts in browsers
+	if strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") && !strings.HasPrefix(raw, "/\\") {
+		raw = strings.TrimSuffix(client, "/") + raw
+	}
+	if origin := urlOrigin(raw); origin != "" && slices.Contains(returnOrigins, origin) {
+		return raw
+	}
+	return ""
+}
+
+// randomState returns a random url safe string for the oauth state
+func randomState() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
 func GetUserInfo(w http.ResponseWriter, r *http.Request) {
     user := utils.GetUser(r);
 
@@ -40,69 +93,216 @@ func GetUserInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func GithubLogin(w http.ResponseWriter, r *http.Request) {
-	url := authConfig.AuthCodeURL("state", oauth2.AccessTypeOffline)
+// GET /auth/providers
+// Local accounts are listed as "password" & "email" when they're enabled
+func GetLoginProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(providers)+2)
+	for name := range providers {
+		names = append(names, name)
+	}
+	if passwordLogin {
+		names = append(names, "password")
+	}
+	if magicLinkLogin {
+		names = append(names, database.EMAIL_PROVIDER)
+	}
+	slices.Sort(names)
+	utils.ResponseJSON(names, w)
+}
+
+// GET /auth/{provider}/login
+func Login(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := providers[name]
+	if !ok {
+		utils.LogError("Unknown login provider", fmt.Errorf("No login provider %q", name), http.StatusNotFound, w)
+		return
+	}
+
+	// ?link=true adds the provider to the logged in user instead
+	link_user_id := 0
+	if r.URL.Query().Get("link") == "true" {
+		user := utils.GetUser(r)
+		if user == nil {
+			utils.Unauthorized(w)
+			return
+		}
+		link_user_id = user.ID
+	}
+
+	config, err := provider.Config(r.Context())
+	if err != nil {
+		utils.LogError("Error configuring login provider", err, http.StatusBadGateway, w)
+		return
+	}
+
+	return_to := returnURL(r.URL.Query().Get("return_to"))
+	if return_to == "" {
+		utils.ValidationError("Invalid return url", []types.FieldError{{Field: "return_to", Message: "is not an allowed url"}}, w)
+		return
+	}
+
+	state, err := randomState()
+	if err != nil {
+		utils.LogError("Error generating oauth state", err, http.StatusInternalServerError, w)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// the state is bound to this browser by a short lived cookie, which also keeps the PKCE verifier
+	session, _ := utils.GetStore().New(r, OAUTH_STATE_SESSION)
+	session.Options = &sessions.Options{
+		Domain:   utils.GetStore().Options.Domain,
+		Path:     "/auth/",
+		MaxAge:   OAUTH_STATE_MAX_AGE,
+		Secure:   utils.GetStore().Options.Secure,
+		HttpOnly: true,
+		// sent on the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+	session.Values["state"] = state
+	session.Values["verifier"] = verifier
+	session.Values["return_to"] = return_to
+	session.Values["provider"] = name
+	session.Values["link_user_id"] = link_user_id
+	// signing up can need an invite
+	session.Values["invite"] = r.URL.Query().Get("invite")
+	err = session.Save(r, w)
+	if err != nil {
+		utils.LogError("Couldn't save oauth state", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	url := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
-func GithubCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	token, err := authConfig.Exchange(context.Background(), code)
+// GET /auth/{provider}/callback
+func LoginCallback(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+
+	// the state cookie can only be used once
+	login, err := utils.GetStore().Get(r, OAUTH_STATE_SESSION)
+	state, _ := login.Values["state"].(string)
+	verifier, _ := login.Values["verifier"].(string)
+	return_to, _ := login.Values["return_to"].(string)
+	login_provider, _ := login.Values["provider"].(string)
+	link_user_id, _ := login.Values["link_user_id"].(int)
+	invite, _ := login.Values["invite"].(string)
+	login.Options.MaxAge = -1
+	login.Options.Path = "/auth/"
+	login.Save(r, w)
+
+	if err != nil || state == "" || login_provider != name || subtle.ConstantTimeCompare([]byte(state), []byte(r.URL.Query().Get("state"))) != 1 {
+		utils.LogError("Invalid oauth state", errors.New("Callback state doesn't match the login state"), http.StatusBadRequest, w)
+		return
+	}
+	if provider_error := r.URL.Query().Get("error"); provider_error != "" {
+		utils.LogError("Login was not authorized", errors.New(provider_error), http.StatusBadRequest, w)
+		return
+	}
+
+	provider, ok := providers[name]
+	if !ok {
+		utils.LogError("Unknown login provider", fmt.Errorf("No login provider %q", name), http.StatusNotFound, w)
+		return
+	}
+	config, err := provider.Config(r.Context())
 	if err != nil {
-		utils.LogError("Error during github callback", err, http.StatusInternalServerError, w)
+		utils.LogError("Error configuring login provider", err, http.StatusBadGateway, w)
 		return
 	}
 
-	// Use token to access user's GitHub data
-	user, err := handleGitHubUser(token)
+	code := r.URL.Query().Get("code")
+	token, err := config.Exchange(r.Context(), code, oauth2.VerifierOption(verifier))
 	if err != nil {
-		utils.LogError("Couldn't get/create user row", err, http.StatusInternalServerError, w)
+		utils.LogError("Error during "+name+" callback", err, http.StatusInternalServerError, w)
 		return
 	}
 
-	session, err := utils.GetStore().Get(r, "user-session")
+	identity, err := provider.Identity(r.Context(), token)
 	if err != nil {
-		utils.LogError("Couldn't get session object", err, http.StatusInternalServerError, w)
+		utils.LogError("Couldn't fetch "+name+" user", err, http.StatusBadGateway, w)
 		return
 	}
-	session.Values["user_id"] = user.ID
 
-	err = session.Save(r, w)
+	var user *types.User
+	if link_user_id != 0 {
+		// linking needs the user that started it to still be logged in
+		if current := utils.GetUser(r); current == nil || current.ID != link_user_id {
+			utils.Unauthorized(w)
+			return
+		}
+		err = database.LinkIdentity(link_user_id, identity)
+		if errors.Is(err, database.ErrIdentityLinked) {
+			utils.LogError("This "+name+" account is linked to another user", err, http.StatusConflict, w)
+			return
+		}
+		if err != nil {
+			utils.LogError("Couldn't link identity", err, http.StatusInternalServerError, w)
+			return
+		}
+		user = utils.GetUser(r)
+	} else {
+		user, err = loginUser(identity, invite)
+		if err != nil {
+			loginError("Couldn't get/create user row", err, w)
+			return
+		}
+	}
+
+	err = startSession(w, r, user)
 	if err != nil {
-		utils.LogError("Couldn't save session", err, http.StatusInternalServerError, w)
+		loginError("Couldn't save session", err, w)
 		return
 	}
 
-    http.Redirect(w, r, os.Getenv("CLIENT_URL"), http.StatusSeeOther)
+	// checked again, in case the allowed urls changed since the login
+	if returnURL(return_to) == "" {
+		return_to = os.Getenv("CLIENT_URL")
+	}
+	http.Redirect(w, r, return_to, http.StatusSeeOther)
+}
+
+// startSession logs a user in with a new session, however they logged in. Suspended users can't log in
+func startSession(w http.ResponseWriter, r *http.Request, user *types.User) error {
+	if user.SuspendedAt != nil {
+		return ErrUserSuspended
+	}
+	session, err := database.GetSessionStore().Get(r, utils.USER_SESSION)
+	if err != nil {
+		return err
+	}
+	err = database.GetSessionStore().Renew(session)
+	if err != nil {
+		return err
+	}
+	session.Values["user_id"] = user.ID
+	return session.Save(r, w)
 }
 
+// GET /auth/logout
+// Only the current session is logged out, see DELETE /auth/sessions to log out everywhere
 func Logout(w http.ResponseWriter, r *http.Request) {
-	session, _ := utils.GetStore().Get(r, "user-session")
+	session, _ := database.GetSessionStore().Get(r, utils.USER_SESSION)
 
-	delete(session.Values, "user_id")
+	session.Options.MaxAge = -1
 
 	session.Save(r, w)
 
 	http.Redirect(w, r, os.Getenv("CLIENT_URL"), http.StatusSeeOther)
 }
 
-// Example logic to handle user registration or retrieval
-func handleGitHubUser(token *oauth2.Token) (*types.User, error) {
-	// Fetch user details from GitHub
-	githubUser, err := fetchGitHubUserDetails(token)
-	if err != nil {
-		return nil, err
-	}
-
-	// Check if the user already exists in the database
-	user, err := database.GetUserByGitHubID(githubUser.ID)
+// loginUser finds the user of an identity, or signs one up if it's the first login
+func loginUser(identity types.Identity, invite string) (*types.User, error) {
+	user, err := database.GetUserByIdentity(identity)
 	if err != nil {
 		return nil, err
 	}
 
 	// If the user doesn't exist, create a new user record
 	if user == nil {
-		user, err = database.CreateUser(githubUser)
+		user, err = createUser(identity, invite)
 		if err != nil {
 			return nil, err
 		}
@@ -111,26 +311,50 @@ func handleGitHubUser(token *oauth2.Token) (*types.User, error) {
 	return user, nil
 }
 
-func fetchGitHubUserDetails(token *oauth2.Token) (*types.GitHubUser, error) {
-	// Create an HTTP client with the OAuth2 token
-	client := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(token))
+// GET /auth/identities
+func GetIdentities(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
 
-	// Make a GET request to the GitHub API to retrieve user details
-	resp, err := client.Get("https://api.github.com/user")
+	identities, err := database.GetIdentities(user.ID)
 	if err != nil {
-		return nil, err
+		utils.LogError("Error fetching identities", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(identities, w)
+}
+
+// DELETE /auth/identities/{id}
+func DeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("Invalid status code")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing identity ID", err, http.StatusBadRequest, w)
+		return
 	}
 
-	// Parse the JSON response into a GitHubUser struct
-	var githubUser types.GitHubUser
-	if err := json.NewDecoder(resp.Body).Decode(&githubUser); err != nil {
-		return nil, err
+	err = database.DeleteIdentity(user.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.LogError("Identity not found", err, http.StatusNotFound, w)
+		return
+	}
+	if errors.Is(err, database.ErrLastIdentity) {
+		utils.LogError("Can't remove the only login of a user", err, http.StatusConflict, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error deleting identity", err, http.StatusInternalServerError, w)
+		return
 	}
 
-	return &githubUser, nil
+	w.WriteHeader(http.StatusOK)
 }
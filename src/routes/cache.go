@@ -0,0 +1,101 @@
+// cache.go
+package routes
+
+import (
+	"blog-server/database"
+	"blog-server/utils"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/mux"
+)
+
+// cachePolicies are the Cache-Control headers of GET routes, by path template.
+// Private routes are revalidated on every use, which is cheap with ETags, public feeds can be cached for a while
+var cachePolicies = map[string]string{
+	"/posts":                          "private, no-cache",
+	"/posts/{category}":               "private, no-cache",
+	"/project/posts/{project_id}":     "private, no-cache",
+	"/post/{slug}":                    "private, no-cache",
+	"/categories":                     "private, no-cache",
+	"/tags":                           "private, no-cache",
+	"/ap/users/{username}":            "public, max-age=3600",
+	"/ap/users/{username}/outbox":     "public, max-age=300",
+	"/ap/users/{username}/followers":  "public, max-age=300",
+	"/ap/users/{username}/posts/{id}": "public, max-age=300",
+	"/openapi.json":                   "public, max-age=3600",
+}
+
+// LoadCachePolicies overrides the default policies with the CACHE_CONTROL env variable,
+// a JSON object of path templates to Cache-Control headers, an empty header removes the policy
+func LoadCachePolicies() {
+	config := os.Getenv("CACHE_CONTROL")
+	if config == "" {
+		return
+	}
+	var policies map[string]string
+	if err := json.Unmarshal([]byte(config), &policies); err != nil {
+		log.Error("Invalid CACHE_CONTROL, using the default cache policies", "err", err)
+		return
+	}
+	for path, policy := range policies {
+		if policy == "" {
+			delete(cachePolicies, path)
+		} else {
+			cachePolicies[path] = policy
+		}
+	}
+}
+
+// CacheControl sets the Cache-Control policy of the matched route on GET responses
+func CacheControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil && r.Method == http.MethodGet {
+			if path, err := route.GetPathTemplate(); err == nil && cachePolicies[path] != "" {
+				w.Header().Set("Cache-Control", cachePolicies[path])
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /cache/stats
+// The stats cover every user's entries, so only admins can see them
+func GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if adminUser(w, r) == nil {
+		return
+	}
+
+	utils.ResponseJSON(database.GetCacheStats(), w)
+}
+
+// contentNotModified sets the validators of a response built from a user's content and writes a 304 when the client's
+// copy is current. The ETag is derived from the content version & the request, so nothing has to be rendered to check it
+func contentNotModified(w http.ResponseWriter, r *http.Request, userID int) bool {
+	version, err := database.GetContentVersion(userID)
+	if err != nil {
+		// serve the response without validators rather than failing
+		log.Error("Error fetching content version", "err", err)
+		return false
+	}
+
+	var modified time.Time
+	var published int64
+	if version.UpdatedAt != nil {
+		modified = *version.UpdatedAt
+	}
+	if version.LastPublished != nil {
+		published = version.LastPublished.Unix()
+		if version.LastPublished.After(modified) {
+			modified = *version.LastPublished
+		}
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%d:%d:%s", userID, version.Version, published, r.URL.RequestURI())))
+	return utils.NotModified(w, r, fmt.Sprintf(`"%x"`, hash[:16]), modified)
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/mux"
 )
@@ -20,6 +21,10 @@ func GetCategories(w http.ResponseWriter, r *http.Request) {
         return;
     }
 
+    if contentNotModified(w, r, user.ID) {
+        return;
+    }
+
     serveCategories(user, w)
 }
 
@@ -49,7 +54,38 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
         return;
     }
 
+    if newCategory.Name == "" {
+        utils.LogError("Invalid category name", errors.New("Attempted to create category without a name"), http.StatusBadRequest, w);
+        return;
+    }
+    if newCategory.Parent == "" {
+        newCategory.Parent = "root"
+    }
+
+    categories, err := database.GetCategories(user);
+    if err != nil {
+        utils.LogError("Error fetching categories", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    // category names only have to be unique for each user
+    if categoryExists(categories, newCategory.Name) {
+        utils.LogError("Category already exists", errors.New("Attempted to create a category that already exists"), http.StatusConflict, w);
+        return;
+    }
+
+    if newCategory.Slug == "" {
+        newCategory.Slug = utils.Slugify(newCategory.Name)
+    }
+    if !validateCategoryMetadata(categories, newCategory, w) {
+        return;
+    }
+
     err = database.CreateCategory(newCategory)
+    if errors.Is(err, database.ErrCategoryNotFound) {
+        utils.LogError("Parent category not found", err, http.StatusBadRequest, w);
+        return;
+    }
     if err != nil {
         utils.LogError("Error creating category", err, http.StatusInternalServerError, w);
         return;
@@ -93,6 +129,124 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
     serveCategories(user, w)
 }
 
+// UpdateCategory handles the PUT /category/{name} route, renaming, moving and/or updating the metadata of a category
+func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+    user := utils.GetUser(r);
+    if user == nil {
+        utils.Unauthorized(w);
+        return;
+    }
+
+    name := mux.Vars(r)["name"]
+
+    var changes types.CategoryUpdate
+    err := json.NewDecoder(r.Body).Decode(&changes)
+    if err != nil {
+        utils.LogError("Error decoding category", err, http.StatusBadRequest, w);
+        return;
+    }
+
+    categories, err := database.GetCategories(user);
+    if err != nil {
+        utils.LogError("Error fetching categories", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    var updated *types.Category
+    for i := range categories {
+        if categories[i].Name == name {
+            existing := categories[i]
+            updated = &existing
+        }
+    }
+    if updated == nil {
+        utils.LogError("Category not found", errors.New("User does not own the category for update"), http.StatusNotFound, w);
+        return;
+    }
+
+    // omitted fields are left unchanged
+    if changes.Name != nil {
+        updated.Name = *changes.Name
+    }
+    if changes.Parent != nil {
+        updated.Parent = *changes.Parent
+    }
+    if changes.Description != nil {
+        updated.Description = *changes.Description
+    }
+    if changes.DisplayOrder != nil {
+        updated.DisplayOrder = *changes.DisplayOrder
+    }
+    if changes.Slug != nil {
+        updated.Slug = *changes.Slug
+        if updated.Slug == "" {
+            updated.Slug = utils.Slugify(updated.Name)
+        }
+    }
+    if changes.CoverImage != nil {
+        updated.CoverImage = *changes.CoverImage
+    }
+
+    if updated.Name == "" || updated.Name == "root" {
+        utils.LogError("Invalid category name", errors.New("Attempted to rename category to an invalid name"), http.StatusBadRequest, w);
+        return;
+    }
+
+    if updated.Name != name && categoryExists(categories, updated.Name) {
+        utils.LogError("Category already exists", errors.New("Attempted to rename category to an existing category"), http.StatusConflict, w);
+        return;
+    }
+
+    if !categoryExists(categories, updated.Parent) {
+        utils.LogError("Parent category not found", errors.New("Attempted to move category to a non-existent parent"), http.StatusBadRequest, w);
+        return;
+    }
+
+    // moving a category underneath itself would detach it from the tree
+    if database.IsDescendant(categories, name, updated.Parent) {
+        utils.LogError("Invalid parent category", errors.New("Attempted to move category underneath itself"), http.StatusBadRequest, w);
+        return;
+    }
+
+    if !validateCategoryMetadata(categories, *updated, w) {
+        return;
+    }
+
+    err = database.UpdateCategory(user, name, *updated)
+    if err != nil {
+        utils.LogError("Error updating category", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    serveCategories(user, w)
+}
+
+// validateCategoryMetadata checks the slug & cover image of a category, writing an error response if they're invalid
+func validateCategoryMetadata(categories []types.Category, category types.Category, w http.ResponseWriter) bool {
+    if category.Slug != utils.Slugify(category.Slug) {
+        utils.LogError("Invalid category slug", errors.New("Category slugs can only contain lowercase letters, numbers & dashes"), http.StatusBadRequest, w);
+        return false;
+    }
+
+    // slugs are used in urls, so they have to be unique for each user
+    for _, c := range categories {
+        if c.Slug == category.Slug && c.ID != category.ID {
+            utils.LogError("Category slug already exists", errors.New("Category slug is used by another category"), http.StatusConflict, w);
+            return false;
+        }
+    }
+
+    if category.CoverImage != "" {
+        cover, err := url.Parse(category.CoverImage)
+        if err != nil || (cover.Scheme != "http" && cover.Scheme != "https") || cover.Host == "" {
+            utils.LogError("Invalid cover image", errors.New("Category cover image isn't an http(s) url"), http.StatusBadRequest, w);
+            return false;
+        }
+    }
+
+    return true;
+}
+
 func serveCategories(user *types.User, w http.ResponseWriter) {
     // Fetch categories from the database
 	categories, err := database.GetCategories(user)
@@ -101,12 +255,13 @@ func serveCategories(user *types.User, w http.ResponseWriter) {
 		return
 	}
 
-    graph := database.ConstructCategoryGraph(categories, "root", user.ID);
-
-    response :=  map[string]interface{}{
-        "categories": categories,
-        "graph": graph,
+    counts, err := database.GetCategoryPostCounts(user)
+    if err != nil {
+        utils.LogError("Error counting category posts", err, http.StatusInternalServerError, w);
+        return
     }
 
-    utils.ResponseJSON(response, w);
+    graph := database.ConstructCategoryGraph(categories, "root", user.ID, counts);
+
+    utils.ResponseJSON(types.CategoryResponse{Categories: categories, Graph: graph}, w);
 }
@@ -0,0 +1,193 @@
+// graphql.go
+package routes
+
+import (
+	"blog-server/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	GRAPHQL_MAX_DEPTH      = 10
+	GRAPHQL_MAX_COMPLEXITY = 5000
+	// lists without a limit argument are assumed to be this long when estimating complexity
+	GRAPHQL_LIST_SIZE = 5
+	// and limit=-1 (no limit) lists this long
+	GRAPHQL_UNLIMITED_LIST_SIZE = 100
+)
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// POST /graphql
+func GraphQL(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	var request graphqlRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		utils.LogError("Error decoding graphql request", err, http.StatusBadRequest, w)
+		return
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(request.Query), Name: "GraphQL request"})})
+	if err != nil {
+		graphqlErrors(w, gqlerrors.FormatError(err))
+		return
+	}
+
+	validation := graphql.ValidateDocument(&graphqlSchema, document, nil)
+	if !validation.IsValid {
+		graphqlErrors(w, validation.Errors...)
+		return
+	}
+
+	err = checkQueryLimits(document, request.OperationName, request.Variables)
+	if err != nil {
+		graphqlErrors(w, gqlerrors.FormatError(err))
+		return
+	}
+
+	// every resolver works on behalf of the authenticated user
+	ctx := context.WithValue(r.Context(), graphqlContextKey{}, &graphqlLoader{user: user, token: utils.GetToken(r)})
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphqlSchema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+
+	utils.ResponseJSON(result, w)
+}
+
+func graphqlErrors(w http.ResponseWriter, errors ...gqlerrors.FormattedError) {
+	utils.ResponseJSONStatus(graphql.Result{Errors: errors}, http.StatusBadRequest, w)
+}
+
+// checkQueryLimits rejects operations that are nested too deeply, or would resolve too many fields.
+// The complexity of a field is 1 plus the complexity of its selections, multiplied by the expected length for lists
+func checkQueryLimits(document *ast.Document, operationName string, variables map[string]interface{}) error {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+	if operation == nil {
+		return fmt.Errorf("Unknown operation %q", operationName)
+	}
+
+	limits := queryLimits{fragments: fragments, variables: variables}
+	complexity, depth := limits.measure(operation.SelectionSet, graphqlSchema.QueryType(), 1, GRAPHQL_LIST_SIZE)
+	if depth > GRAPHQL_MAX_DEPTH {
+		return fmt.Errorf("Query depth of %d exceeds the maximum of %d", depth, GRAPHQL_MAX_DEPTH)
+	}
+	if complexity > GRAPHQL_MAX_COMPLEXITY {
+		return fmt.Errorf("Query complexity of %d exceeds the maximum of %d", complexity, GRAPHQL_MAX_COMPLEXITY)
+	}
+	return nil
+}
+
+type queryLimits struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// measure returns the complexity & depth of a selection set on the parent type.
+// list_size is the expected length of lists below, set by the nearest limit argument
+func (q queryLimits) measure(selections *ast.SelectionSet, parent *graphql.Object, depth int, list_size int) (int, int) {
+	if selections == nil || parent == nil {
+		return 0, depth - 1
+	}
+
+	complexity, max_depth := 0, depth
+	for _, selection := range selections.Selections {
+		var field_complexity, field_depth int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field_complexity, field_depth = q.measureField(selection, parent, depth, list_size)
+		case *ast.InlineFragment:
+			field_complexity, field_depth = q.measure(selection.SelectionSet, parent, depth, list_size)
+		case *ast.FragmentSpread:
+			if fragment, ok := q.fragments[selection.Name.Value]; ok {
+				field_complexity, field_depth = q.measure(fragment.SelectionSet, parent, depth, list_size)
+			}
+		}
+		complexity += field_complexity
+		max_depth = max(max_depth, field_depth)
+	}
+	return complexity, max_depth
+}
+
+func (q queryLimits) measureField(field *ast.Field, parent *graphql.Object, depth int, list_size int) (int, int) {
+	definition, ok := parent.Fields()[field.Name.Value]
+	if !ok {
+		// introspection fields like __typename
+		return 1, depth
+	}
+
+	for _, argument := range field.Arguments {
+		if argument.Name.Value == "limit" {
+			list_size = q.intValue(argument.Value, list_size)
+		}
+	}
+
+	multiplier := 1
+	field_type := definition.Type
+	for {
+		if non_null, ok := field_type.(*graphql.NonNull); ok {
+			field_type = non_null.OfType
+		} else if list, ok := field_type.(*graphql.List); ok {
+			multiplier *= list_size
+			field_type = list.OfType
+		} else {
+			break
+		}
+	}
+
+	object, _ := field_type.(*graphql.Object)
+	children, child_depth := q.measure(field.SelectionSet, object, depth+1, list_size)
+	return 1 + multiplier*children, max(depth, child_depth)
+}
+
+func (q queryLimits) intValue(value ast.Value, fallback int) int {
+	var limit int
+	switch value := value.(type) {
+	case *ast.IntValue:
+		fmt.Sscan(value.Value, &limit)
+	case *ast.Variable:
+		number, ok := q.variables[value.Name.Value].(float64)
+		if !ok {
+			return fallback
+		}
+		limit = int(number)
+	default:
+		return fallback
+	}
+	if limit <= 0 {
+		return GRAPHQL_UNLIMITED_LIST_SIZE
+	}
+	return limit
+}
@@ -0,0 +1,481 @@
+// graphql_schema.go
+package routes
+
+import (
+	"blog-server/actions"
+	"blog-server/database"
+	"blog-server/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/graphql-go/graphql"
+)
+
+// graphqlLoader caches the lookups of a single request, so fields resolved for every post (e.g. their project)
+// only hit the database once no matter how many posts are in the response
+type graphqlLoader struct {
+	user *types.User
+	// the token of the request, nil for sessions
+	token *types.AccessKey
+
+	categories_once sync.Once
+	categories      []types.Category
+	categories_err  error
+
+	tags_once sync.Once
+	tags      map[string]types.Tag
+	tags_err  error
+
+	projects_once sync.Once
+	projects      map[string]types.Project
+	projects_err  error
+}
+
+type graphqlContextKey struct{}
+
+func getLoader(p graphql.ResolveParams) *graphqlLoader {
+	return p.Context.Value(graphqlContextKey{}).(*graphqlLoader)
+}
+
+// requireScope errors when the request's token or the user's role doesn't have the scope the REST route of a field needs
+func (l *graphqlLoader) requireScope(scope string) error {
+	if l.token != nil && MissingScope(l.token, []string{scope}) != "" {
+		return fmt.Errorf("Token is missing the %s scope", scope)
+	}
+	if !slices.Contains(roleScopes[l.user.Role], scope) {
+		return fmt.Errorf("The %s role can't use the %s scope", l.user.Role, scope)
+	}
+	return nil
+}
+
+func (l *graphqlLoader) Categories() ([]types.Category, error) {
+	l.categories_once.Do(func() {
+		l.categories, l.categories_err = database.GetCategories(l.user)
+	})
+	return l.categories, l.categories_err
+}
+
+func (l *graphqlLoader) Tags() (map[string]types.Tag, error) {
+	l.tags_once.Do(func() {
+		var tags []types.Tag
+		tags, l.tags_err = database.GetTags(l.user, true)
+		l.tags = make(map[string]types.Tag, len(tags))
+		for _, tag := range tags {
+			l.tags[tag.Name] = tag
+		}
+	})
+	return l.tags, l.tags_err
+}
+
+func (l *graphqlLoader) Projects() (map[string]types.Project, error) {
+	l.projects_once.Do(func() {
+		l.projects = make(map[string]types.Project)
+		var cache *types.ProjectCache
+		cache, l.projects_err = actions.FetchProjects(l.user.ID, false)
+		if l.projects_err != nil || cache == nil || cache.Data == "" {
+			return
+		}
+		var projects []types.Project
+		l.projects_err = json.Unmarshal([]byte(cache.Data), &projects)
+		for _, project := range projects {
+			l.projects[project.ID] = project
+		}
+	})
+	return l.projects, l.projects_err
+}
+
+var graphqlUser = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"user_id":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"username":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"email":      &graphql.Field{Type: graphql.String},
+		"avatar_url": &graphql.Field{Type: graphql.String},
+		"role":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"created_at": &graphql.Field{Type: graphql.DateTime},
+	},
+})
+
+var graphqlCategory = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Category",
+	Fields: graphql.Fields{
+		"id":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"parent":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"description":   &graphql.Field{Type: graphql.String},
+		"display_order": &graphql.Field{Type: graphql.Int},
+		"slug":          &graphql.Field{Type: graphql.String},
+		"cover_image":   &graphql.Field{Type: graphql.String},
+	},
+})
+
+var graphqlCategoryNode = graphql.NewObject(graphql.ObjectConfig{
+	Name: "CategoryNode",
+	Fields: graphql.Fields{
+		"name":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"description":      &graphql.Field{Type: graphql.String},
+		"display_order":    &graphql.Field{Type: graphql.Int},
+		"slug":             &graphql.Field{Type: graphql.String},
+		"cover_image":      &graphql.Field{Type: graphql.String},
+		"post_count":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"total_post_count": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+func init() {
+	// the children refer to the type itself, so they can only be added once it exists
+	graphqlCategoryNode.AddFieldConfig("children", &graphql.Field{Type: graphql.NewList(graphqlCategoryNode)})
+}
+
+var graphqlTag = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Tag",
+	Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"description": &graphql.Field{Type: graphql.String},
+		"colour":      &graphql.Field{Type: graphql.String},
+		"slug":        &graphql.Field{Type: graphql.String},
+		"count":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"first_used":  &graphql.Field{Type: graphql.DateTime},
+		"last_used":   &graphql.Field{Type: graphql.DateTime},
+		"weight":      &graphql.Field{Type: graphql.Float},
+	},
+})
+
+var graphqlProject = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Project",
+	Fields: graphql.Fields{
+		"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"project_id": &graphql.Field{Type: graphql.String},
+		"name":       &graphql.Field{Type: graphql.String},
+		"visibility": &graphql.Field{Type: graphql.String},
+	},
+})
+
+var graphqlPost = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Post",
+	Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"author_id":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"slug":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"title":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"description": &graphql.Field{Type: graphql.String},
+		"content":     &graphql.Field{Type: graphql.String},
+		"format":      &graphql.Field{Type: graphql.String},
+		"category":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"tags":        &graphql.Field{Type: graphql.NewList(graphql.String)},
+		"archived":    &graphql.Field{Type: graphql.Boolean},
+		"publish_at":  &graphql.Field{Type: graphql.DateTime},
+		"created_at":  &graphql.Field{Type: graphql.DateTime},
+		"updated_at":  &graphql.Field{Type: graphql.DateTime},
+		"version":     &graphql.Field{Type: graphql.Int},
+		"project_id":  &graphql.Field{Type: graphql.String},
+		"category_details": &graphql.Field{
+			Type: graphqlCategory,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				categories, err := getLoader(p).Categories()
+				if err != nil {
+					return nil, err
+				}
+				name := p.Source.(types.Post).Category
+				for _, category := range categories {
+					if category.Name == name {
+						return category, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		"tag_details": &graphql.Field{
+			Type: graphql.NewList(graphqlTag),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				tags, err := getLoader(p).Tags()
+				if err != nil {
+					return nil, err
+				}
+				details := make([]types.Tag, 0)
+				for _, name := range p.Source.(types.Post).Tags {
+					if tag, ok := tags[name]; ok {
+						details = append(details, tag)
+					}
+				}
+				return details, nil
+			},
+		},
+		"project": &graphql.Field{
+			Type: graphqlProject,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				project_id := p.Source.(types.Post).ProjectID
+				if project_id == "" {
+					return nil, nil
+				}
+				projects, err := getLoader(p).Projects()
+				if err != nil {
+					return nil, err
+				}
+				if project, ok := projects[project_id]; ok {
+					return project, nil
+				}
+				return nil, nil
+			},
+		},
+	},
+})
+
+var graphqlPostsPage = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PostsPage",
+	Fields: graphql.Fields{
+		"posts":        &graphql.Field{Type: graphql.NewList(graphqlPost)},
+		"total_posts":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"total_pages":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"per_page":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"current_page": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlFetchLink = graphql.NewObject(graphql.ObjectConfig{
+	Name: "FetchLink",
+	Fields: graphql.Fields{
+		"post_id":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"identifier": &graphql.Field{Type: graphql.String},
+	},
+})
+
+var graphqlIntegration = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Integration",
+	Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"location":    &graphql.Field{Type: graphql.String},
+		"source":      &graphql.Field{Type: graphql.String},
+		"data":        &graphql.Field{Type: graphql.String},
+		"last_fetch":  &graphql.Field{Type: graphql.DateTime},
+		"created_at":  &graphql.Field{Type: graphql.DateTime},
+		"updated_at":  &graphql.Field{Type: graphql.DateTime},
+		"fetch_links": &graphql.Field{Type: graphql.NewList(graphqlFetchLink)},
+	},
+})
+
+// tokens are listed without their value
+var graphqlToken = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Token",
+	Fields: graphql.Fields{
+		"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"prefix":       &graphql.Field{Type: graphql.String},
+		"name":         &graphql.Field{Type: graphql.String},
+		"note":         &graphql.Field{Type: graphql.String},
+		"enabled":      &graphql.Field{Type: graphql.Boolean},
+		"created_at":   &graphql.Field{Type: graphql.DateTime},
+		"updated_at":   &graphql.Field{Type: graphql.DateTime},
+		"scopes":       &graphql.Field{Type: graphql.NewList(graphql.String)},
+		"expires_at":   &graphql.Field{Type: graphql.DateTime},
+		"last_used_at": &graphql.Field{Type: graphql.DateTime},
+		"last_used_ip": &graphql.Field{Type: graphql.String},
+	},
+})
+
+func stringListArg(p graphql.ResolveParams, name string) []string {
+	var list []string
+	values, _ := p.Args[name].([]interface{})
+	for _, value := range values {
+		if tag, ok := value.(string); ok && tag != "" {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
+
+var graphqlQuery = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"me": &graphql.Field{
+			Type: graphqlUser,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return getLoader(p).user, nil
+			},
+		},
+		"post": &graphql.Field{
+			Type: graphqlPost,
+			Args: graphql.FieldConfigArgument{
+				"id":   &graphql.ArgumentConfig{Type: graphql.Int},
+				"slug": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				user := getLoader(p).user
+				var post types.Post
+				var err error
+				if id, ok := p.Args["id"].(int); ok {
+					post, err = database.FetchPost(user, database.ID, id)
+				} else if slug, ok := p.Args["slug"].(string); ok {
+					post, err = database.FetchPost(user, database.Slug, slug)
+				} else {
+					return nil, errors.New("post requires an id or slug")
+				}
+				// missing posts resolve to null, like a 404
+				if err != nil {
+					return nil, nil
+				}
+				return post, nil
+			},
+		},
+		"posts": &graphql.Field{
+			Type: graphql.NewNonNull(graphqlPostsPage),
+			Args: graphql.FieldConfigArgument{
+				"category":         &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "root"},
+				"tags_all":         &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+				"tags_any":         &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+				"tags_none":        &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+				"archived":         &graphql.ArgumentConfig{Type: graphql.Boolean},
+				"format":           &graphql.ArgumentConfig{Type: graphql.String},
+				"published_after":  &graphql.ArgumentConfig{Type: graphql.String},
+				"published_before": &graphql.ArgumentConfig{Type: graphql.String},
+				"project":          &graphql.ArgumentConfig{Type: graphql.String},
+				"limit":            &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+				"offset":           &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				filter := types.PostFilter{
+					Category: p.Args["category"].(string),
+					TagsAll:  stringListArg(p, "tags_all"),
+					TagsAny:  stringListArg(p, "tags_any"),
+					TagsNone: stringListArg(p, "tags_none"),
+				}
+				if archived, ok := p.Args["archived"].(bool); ok {
+					filter.Archived = &archived
+				}
+				filter.Format, _ = p.Args["format"].(string)
+				filter.ProjectID, _ = p.Args["project"].(string)
+				if value, ok := p.Args["published_after"].(string); ok && value != "" {
+					date, err := parseFilterDate(value)
+					if err != nil {
+						return nil, errors.New("published_after must be a date")
+					}
+					filter.PublishedAfter = &date
+				}
+				if value, ok := p.Args["published_before"].(string); ok && value != "" {
+					date, err := parseFilterDate(value)
+					if err != nil {
+						return nil, errors.New("published_before must be a date")
+					}
+					filter.PublishedBefore = &date
+				}
+
+				limit, offset := p.Args["limit"].(int), p.Args["offset"].(int)
+				if limit == 0 || limit < -1 {
+					return nil, errors.New("limit must be positive, or -1 for every post")
+				}
+				posts, total, err := database.GetPosts(getLoader(p).user, filter, limit, offset, nil)
+				if err != nil {
+					return nil, err
+				}
+				return buildPostsResponse(posts, total, limit, offset), nil
+			},
+		},
+		"categories": &graphql.Field{
+			Type: graphql.NewList(graphqlCategory),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return getLoader(p).Categories()
+			},
+		},
+		"category_tree": &graphql.Field{
+			Type: graphqlCategoryNode,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				loader := getLoader(p)
+				categories, err := loader.Categories()
+				if err != nil {
+					return nil, err
+				}
+				counts, err := database.GetCategoryPostCounts(loader.user)
+				if err != nil {
+					return nil, err
+				}
+				return database.ConstructCategoryGraph(categories, "root", loader.user.ID, counts), nil
+			},
+		},
+		"tags": &graphql.Field{
+			Type: graphql.NewList(graphqlTag),
+			Args: graphql.FieldConfigArgument{
+				"unused": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				"cloud":  &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				tags, err := database.GetTags(getLoader(p).user, p.Args["unused"].(bool))
+				if err != nil {
+					return nil, err
+				}
+				if p.Args["cloud"].(bool) {
+					setTagWeights(tags)
+				}
+				return tags, nil
+			},
+		},
+		"projects": &graphql.Field{
+			Type: graphql.NewList(graphqlProject),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if err := getLoader(p).requireScope(SCOPE_INTEGRATIONS_SYNC); err != nil {
+					return nil, err
+				}
+				projects, err := getLoader(p).Projects()
+				if err != nil {
+					return nil, err
+				}
+				list := make([]types.Project, 0, len(projects))
+				for _, project := range projects {
+					list = append(list, project)
+				}
+				return list, nil
+			},
+		},
+		"integrations": &graphql.Field{
+			Type: graphql.NewList(graphqlIntegration),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if err := getLoader(p).requireScope(SCOPE_INTEGRATIONS_SYNC); err != nil {
+					return nil, err
+				}
+				integrations, err := database.GetUserIntegrations(getLoader(p).user.ID)
+				if err != nil {
+					return nil, err
+				}
+				// the embedded integration isn't resolved by the default resolver, so flatten it
+				list := make([]map[string]interface{}, 0, len(integrations))
+				for _, integration := range integrations {
+					fetch_links := make([]map[string]interface{}, 0, len(integration.FetchLinks))
+					for _, link := range integration.FetchLinks {
+						fetch_links = append(fetch_links, map[string]interface{}{"post_id": link.PostID, "identifier": link.Identifier})
+					}
+					list = append(list, map[string]interface{}{
+						"id":          integration.ID,
+						"location":    integration.Location,
+						"source":      integration.Source,
+						"data":        integration.Data,
+						"last_fetch":  integration.LastFetch,
+						"created_at":  integration.CreatedAt,
+						"updated_at":  integration.UpdatedAt,
+						"fetch_links": fetch_links,
+					})
+				}
+				return list, nil
+			},
+		},
+		"tokens": &graphql.Field{
+			Type: graphql.NewList(graphqlToken),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if err := getLoader(p).requireScope(SCOPE_TOKENS_ADMIN); err != nil {
+					return nil, err
+				}
+				return database.GetTokens(getLoader(p).user.ID)
+			},
+		},
+	},
+})
+
+var graphqlSchema = func() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: graphqlQuery})
+	if err != nil {
+		log.Fatal("Error building graphql schema", "err", err)
+	}
+	return schema
+}()
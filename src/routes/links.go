@@ -61,11 +61,7 @@ func UpsertIntegrations(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// read the 'source' and 'data' from the body
-	type IntegrationInput struct {
-		Source string `json:"source"`
-		Data   string `json:"data"`
-	}
-	var input IntegrationInput
+	var input types.IntegrationInput
 
 	err := json.NewDecoder(r.Body).Decode(&input)
 
@@ -0,0 +1,179 @@
+// newsletter.go
+package routes
+
+import (
+	"blog-server/actions"
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// how long the emailed link to confirm a subscription can be used for
+	SUBSCRIPTION_CONFIRM_TTL = 48 * time.Hour
+	// another confirmation isn't sent to the same address until this has passed, whichever author it's for
+	SUBSCRIPTION_CONFIRM_INTERVAL = 10 * time.Minute
+)
+
+// POST /newsletter/subscribe/{username}
+func Subscribe(w http.ResponseWriter, r *http.Request) {
+	if !utils.MailEnabled() {
+		utils.LogError("Newsletter is not enabled", errors.New("No SMTP relay configured"), http.StatusServiceUnavailable, w)
+		return
+	}
+
+	user, err := database.GetUserByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		utils.LogError("Error fetching user", err, http.StatusInternalServerError, w)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var body types.SubscribeRequest
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
+		return
+	}
+
+	address, err := mail.ParseAddress(body.Email)
+	if err != nil {
+		utils.LogError("Invalid email", err, http.StatusBadRequest, w)
+		return
+	}
+
+	existing, err := database.GetSubscriberByEmail(user.ID, address.Address)
+	if err != nil {
+		utils.LogError("Error fetching subscriber", err, http.StatusInternalServerError, w)
+		return
+	}
+	// respond the same way for existing subscribers so we don't leak who is subscribed
+	if existing != nil && existing.Status == "confirmed" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	// anyone can subscribe an address, so it isn't emailed again straight away
+	sent, err := database.ConfirmationSentSince(address.Address, time.Now().Add(-SUBSCRIPTION_CONFIRM_INTERVAL))
+	if err != nil {
+		utils.LogError("Error fetching subscribers", err, http.StatusInternalServerError, w)
+		return
+	}
+	if sent {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	subscriber, err := database.UpsertSubscriber(user.ID, address.Address)
+	if err != nil {
+		utils.LogError("Error creating subscriber", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = actions.SendConfirmation(user, subscriber)
+	if err != nil {
+		utils.LogError("Error sending confirmation email", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GET /newsletter/confirm
+func ConfirmSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriber, err := database.GetSubscriberByToken(r.URL.Query().Get("token"))
+	if err != nil {
+		utils.LogError("Error fetching subscriber", err, http.StatusInternalServerError, w)
+		return
+	}
+	if subscriber == nil || subscriber.Status == "unsubscribed" {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+	expired := subscriber.ConfirmationSentAt == nil || time.Since(*subscriber.ConfirmationSentAt) > SUBSCRIPTION_CONFIRM_TTL
+	if subscriber.Status == "pending" && expired {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+
+	err = database.SetSubscriberStatus(subscriber.ID, "confirmed")
+	if err != nil {
+		utils.LogError("Error confirming subscriber", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Subscription confirmed"))
+}
+
+// GET /newsletter/unsubscribe
+// Opening the link only shows a page that posts its token, so link scanners & prefetchers don't unsubscribe readers
+func UnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	utils.ConfirmForm("Stop receiving posts by email?", "Unsubscribe", r.URL.Query().Get("token"), w)
+}
+
+// POST /newsletter/unsubscribe
+// Mail clients post to the link for one-click unsubscribing (RFC 8058), the page of the link posts the token in the body
+func Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	subscriber, err := database.GetSubscriberByToken(r.FormValue("token"))
+	if err != nil {
+		utils.LogError("Error fetching subscriber", err, http.StatusInternalServerError, w)
+		return
+	}
+	if subscriber == nil {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+
+	err = database.SetSubscriberStatus(subscriber.ID, "unsubscribed")
+	if err != nil {
+		utils.LogError("Error unsubscribing", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("You have been unsubscribed"))
+}
+
+// GET /newsletter/subscribers
+func GetSubscribers(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	subscribers, err := database.GetSubscribers(user.ID, r.URL.Query().Get("status"))
+	if err != nil {
+		utils.LogError("Error fetching subscribers", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(subscribers, w)
+}
+
+// GET /newsletter/sends
+func GetNewsletterSends(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	sends, err := database.GetNewsletterSends(user.ID)
+	if err != nil {
+		utils.LogError("Error fetching newsletter sends", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(sends, w)
+}
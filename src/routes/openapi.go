@@ -0,0 +1,679 @@
+// openapi.go
+package routes
+
+import (
+	"blog-server/types"
+	"blog-server/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// openapiOperation documents a route, the path, method, path parameters & security of the operation come from the router
+type openapiOperation struct {
+	Summary string
+	// Request & Response are zero values of the JSON bodies
+	Request  interface{}
+	Required []string
+	Response interface{}
+	Query    []openapiParameter
+}
+
+var postFilterParameters = []openapiParameter{
+	queryParameter("limit", "integer", "Posts per page, -1 for every post."),
+	queryParameter("offset", "integer", "Number of posts to skip."),
+	queryParameter("tags_all", "string", "Comma separated tags, posts must have every one."),
+	queryParameter("tag", "string", "Alias of tags_all for a single tag."),
+	queryParameter("tags_any", "string", "Comma separated tags, posts must have at least one."),
+	queryParameter("tags_none", "string", "Comma separated tags, posts must have none."),
+	queryParameter("archived", "boolean", "Only archived, or only unarchived posts."),
+	queryParameter("format", "string", "The post format, e.g. md."),
+	queryParameter("published_after", "string", "RFC 3339 date or YYYY-MM-DD."),
+	queryParameter("published_before", "string", "RFC 3339 date or YYYY-MM-DD."),
+	queryParameter("project", "string", "Posts linked to a project id."),
+	fieldsParameter,
+}
+
+var fieldsParameter = queryParameter("fields", "string", "Comma separated fields of the posts to return, summary for everything but the content and all for every field. Listings return the summary by default.")
+
+var previewParameter = queryParameter("preview", "boolean", "Only count the affected posts.")
+
+// openapiOperations is keyed by "METHOD /path", routes without an entry are still documented from the router
+var openapiOperations = map[string]openapiOperation{
+	"GET /posts":                      {Summary: "Fetches all posts.", Response: types.PostsResponse{}, Query: postFilterParameters},
+	"GET /posts/{category}":           {Summary: "Fetches the posts within a category and its children.", Response: types.PostsResponse{}, Query: postFilterParameters},
+	"GET /project/posts/{project_id}": {Summary: "Fetches the posts linked to a project.", Response: types.PostsResponse{}, Query: postFilterParameters},
+	"POST /posts/bulk": {
+		Summary:  "Applies an operation to a list of post ids in one transaction.",
+		Request:  types.BulkOperation{},
+		Required: []string{"ids", "operation"},
+		Response: struct {
+			Operation string             `json:"operation"`
+			Results   []types.BulkResult `json:"results"`
+		}{},
+	},
+	"GET /post/{slug}": {Summary: "Retrieves a post by its slug.", Response: types.Post{}, Query: []openapiParameter{fieldsParameter}},
+	"POST /post/new": {
+		Summary:  "Creates a new post.",
+		Request:  types.Post{},
+		Required: []string{"author_id", "slug", "title", "category"},
+		Response: types.Post{},
+	},
+	"PUT /post/edit": {
+		Summary:  "Edits an existing post, send the post's ETag as If-Match (or its version) to reject stale edits.",
+		Request:  types.Post{},
+		Required: []string{"id", "author_id", "slug", "title", "category"},
+		Response: types.Post{},
+	},
+	// merge patches are validated by parsePostPatch, null has a meaning of its own
+	"PATCH /post/{id}":         {Summary: "Partially updates a post with a JSON Merge Patch of its editable fields.", Response: types.Post{}},
+	"DELETE /post/delete/{id}": {Summary: "Deletes a post."},
+	"GET /categories":          {Summary: "Retrieves all categories and the category graph.", Response: types.CategoryResponse{}},
+	"POST /category/new": {
+		Summary:  "Creates a new category.",
+		Request:  types.Category{},
+		Required: []string{"name"},
+		Response: types.CategoryResponse{},
+	},
+	"DELETE /category/delete/{name}": {Summary: "Deletes a category.", Response: types.CategoryResponse{}},
+	"PUT /category/{name}": {
+		Summary:  "Renames, moves and/or updates the metadata of a category.",
+		Request:  types.CategoryUpdate{},
+		Response: types.CategoryResponse{},
+	},
+	"POST /graphql": {
+		Summary:  "Read-only GraphQL queries.",
+		Request:  graphqlRequest{},
+		Required: []string{"query"},
+	},
+	"PUT /post/tag": {
+		Summary: "Adds a tag to a post.",
+		Query:   []openapiParameter{queryParameter("id", "integer", "The post id."), queryParameter("tag", "string", "The tag.")},
+	},
+	"DELETE /post/tag": {
+		Summary: "Removes a tag from a post.",
+		Query:   []openapiParameter{queryParameter("id", "integer", "The post id."), queryParameter("tag", "string", "The tag.")},
+	},
+	"GET /cache/stats": {
+		Summary:  "Reports the size of the read cache and its hits, misses, evictions & invalidations by kind, admins only.",
+		Response: types.CacheStats{},
+	},
+	"GET /tags": {
+		Summary:  "Retrieves all tags with their metadata & usage.",
+		Response: []types.Tag{},
+		Query: []openapiParameter{
+			queryParameter("unused", "boolean", "Include tags no longer on any post."),
+			queryParameter("cloud", "boolean", "Add tag cloud weights."),
+		},
+	},
+	"POST /tags/merge": {
+		Summary:  "Merges several tags into one on every post.",
+		Request:  types.TagOperation{},
+		Required: []string{"tags", "into"},
+		Response: types.TagOperationResult{},
+		Query:    []openapiParameter{previewParameter},
+	},
+	"POST /tags/suggest": {
+		Summary:  "Ranks the user's existing tags for a draft.",
+		Request:  types.TagSuggestionRequest{},
+		Response: []types.TagSuggestion{},
+	},
+	"PUT /tag/{tag}": {
+		Summary:  "Renames a tag on every post.",
+		Request:  types.TagRename{},
+		Required: []string{"name"},
+		Response: types.TagOperationResult{},
+		Query:    []openapiParameter{previewParameter},
+	},
+	"DELETE /tag/{tag}":            {Summary: "Removes a tag from every post.", Response: types.TagOperationResult{}, Query: []openapiParameter{previewParameter}},
+	"PATCH /tag/{tag}":             {Summary: "Updates the description, colour or slug of a tag.", Request: types.TagUpdate{}, Response: types.Tag{}},
+	"GET /auth/user":               {Summary: "Retrieves the logged in user.", Response: types.User{}},
+	"GET /auth/providers":          {Summary: "Lists the configured login providers.", Response: []string{}},
+	"GET /auth/identities":         {Summary: "Lists the provider logins linked to the user.", Response: []types.Identity{}},
+	"DELETE /auth/identities/{id}": {Summary: "Unlinks a provider login, the last one can't be removed."},
+	"GET /auth/{provider}/login": {
+		Summary: "Initiates login via a provider.",
+		Query: []openapiParameter{
+			queryParameter("return_to", "string", "The page to return to after logging in."),
+			queryParameter("link", "boolean", "Link the provider to the logged in user instead."),
+			queryParameter("invite", "string", "The invite code to sign up with."),
+		},
+	},
+	"GET /auth/{provider}/callback": {Summary: "Handles the callback from a provider's authentication."},
+	"POST /auth/register": {
+		Summary:  "Emails a link that creates a local account with a password, when AUTH_PASSWORD is enabled.",
+		Request:  types.RegisterRequest{},
+		Required: []string{"username", "email", "password"},
+	},
+	"POST /auth/password/login": {
+		Summary:  "Logs in to a local account with its password.",
+		Request:  types.PasswordLoginRequest{},
+		Required: []string{"email", "password"},
+		Response: types.User{},
+	},
+	"POST /auth/email/login": {
+		Summary:  "Emails a login link, or a sign up link with a username for an email without an account, when AUTH_MAGIC_LINK is enabled.",
+		Request:  types.LoginLinkRequest{},
+		Required: []string{"email"},
+	},
+	"GET /auth/email/callback": {
+		Summary: "Shows the page of an emailed login link, which posts its token to log in.",
+		Query:   []openapiParameter{queryParameter("token", "string", "The token of the link.")},
+	},
+	"POST /auth/email/callback": {
+		Summary: "Logs in with the form encoded token of an emailed login link, or creates the account of a registration.",
+	},
+	"GET /auth/logout":   {Summary: "Logs out the current session."},
+	"GET /auth/sessions": {Summary: "Lists the active sessions of the user, marking the current one.", Response: []types.Session{}},
+	"DELETE /auth/sessions": {
+		Summary:  "Logs out every session of the user.",
+		Query:    []openapiParameter{queryParameter("others", "boolean", "Keep the current session.")},
+		Response: map[string]int{},
+	},
+	"DELETE /auth/sessions/{id}": {Summary: "Logs out one of the user's sessions."},
+	"GET /auth/registration":     {Summary: "Retrieves the registration mode, open, invite or closed.", Response: map[string]string{}},
+	"GET /tokens":                {Summary: "Retrieves the API tokens of the user.", Response: []types.AccessKey{}},
+	"POST /token/new": {
+		Summary:  "Creates a new API token, without scopes it gets the scopes of the token creating it, or every scope.",
+		Request:  types.AccessKey{},
+		Required: []string{"user_id", "name"},
+		Response: types.AccessKey{},
+	},
+	"PUT /token/edit": {
+		Summary:  "Edits an API token, its scopes & expiry are unchanged when they're left out. A null expires_at removes the expiry.",
+		Request:  types.AccessKey{},
+		Required: []string{"id", "user_id", "name"},
+	},
+	"POST /token/rotate/{id}": {
+		Summary:  "Gives an API token a new value, the old value keeps working for the grace period.",
+		Response: types.AccessKey{},
+		Query:    []openapiParameter{queryParameter("grace", "integer", "Seconds the old value keeps working, 86400 by default and at most 30 days.")},
+	},
+	"DELETE /token/delete/{id}": {Summary: "Deletes an API token."},
+	"GET /links": {
+		Summary: "Retrieves the integrations of the user.",
+		Response: struct {
+			Integrations []types.Integration `json:"integrations"`
+			DevpadKey    string              `json:"devpad_key"`
+			LastCache    types.ProjectCache  `json:"last_cache"`
+		}{},
+	},
+	"PUT /links/upsert": {
+		Summary:  "Creates or updates an integration.",
+		Request:  types.IntegrationInput{},
+		Required: []string{"source"},
+	},
+	"GET /links/fetch/{source}":           {Summary: "Fetches the posts of an integration."},
+	"DELETE /links/delete/{id}":           {Summary: "Deletes an integration."},
+	"GET /projects":                       {Summary: "Retrieves the devpad projects of the user.", Response: []types.Project{}},
+	"PUT /project/key":                    {Summary: "Sets the devpad API key and fetches the projects.", Request: types.ProjectKey{}, Required: []string{"api_key"}},
+	"GET /.well-known/webfinger":          {Summary: "Resolves acct: resources to ActivityPub actors.", Query: []openapiParameter{queryParameter("resource", "string", "acct:<username>@<domain>")}},
+	"GET /ap/users/{username}":            {Summary: "ActivityPub actor of a user."},
+	"GET /ap/users/{username}/outbox":     {Summary: "Published posts as Create activities."},
+	"GET /ap/users/{username}/followers":  {Summary: "Follower count of a user."},
+	"POST /ap/users/{username}/inbox":     {Summary: "Accepts signed Follow & Undo activities."},
+	"GET /ap/users/{username}/posts/{id}": {Summary: "A published post as an Article."},
+	"POST /newsletter/subscribe/{username}": {
+		Summary:  "Subscribes an email to an author.",
+		Request:  types.SubscribeRequest{},
+		Required: []string{"email"},
+	},
+	"GET /newsletter/confirm":      {Summary: "Confirms a subscription.", Query: []openapiParameter{queryParameter("token", "string", "The emailed token.")}},
+	"GET /newsletter/unsubscribe":  {Summary: "Shows the page of the emailed link, which posts its token to unsubscribe.", Query: []openapiParameter{queryParameter("token", "string", "The emailed token.")}},
+	"POST /newsletter/unsubscribe": {Summary: "Unsubscribes, used by one-click unsubscribe.", Query: []openapiParameter{queryParameter("token", "string", "The emailed token, or a form encoded token.")}},
+	"GET /newsletter/subscribers":  {Summary: "Retrieves the subscribers of the user.", Response: []types.Subscriber{}},
+	"GET /newsletter/sends":        {Summary: "Retrieves sent newsletters and their deliveries.", Response: []types.NewsletterSend{}},
+	"GET /admin/users":             {Summary: "Lists every user of the instance, admins only.", Response: []types.User{}},
+	"PATCH /admin/users/{id}": {
+		Summary:  "Changes the role of a user or suspends them, admins only. The last admin can't be demoted or suspended.",
+		Request:  types.UserUpdate{},
+		Response: types.User{},
+	},
+	"DELETE /admin/users/{id}": {Summary: "Deletes a user and everything they own, admins only."},
+	"GET /admin/invites":       {Summary: "Lists the invites, admins only.", Response: []types.Invite{}},
+	"POST /admin/invites": {
+		Summary:  "Creates an invite, the code is only in this response. Admins only.",
+		Request:  types.Invite{},
+		Response: types.Invite{},
+	},
+	"DELETE /admin/invites/{id}": {Summary: "Deletes an invite, admins only."},
+	"GET /openapi.json":          {Summary: "This document."},
+}
+
+type openapiSchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	MinLength            int                       `json:"minLength,omitempty"`
+	Items                *openapiSchema            `json:"items,omitempty"`
+	Properties           map[string]*openapiSchema `json:"properties,omitempty"`
+	AdditionalProperties *openapiSchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AllOf                []*openapiSchema          `json:"allOf,omitempty"`
+}
+
+type openapiParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Required    bool           `json:"required,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      *openapiSchema `json:"schema"`
+}
+
+func queryParameter(name string, kind string, description string) openapiParameter {
+	return openapiParameter{Name: name, In: "query", Description: description, Schema: &openapiSchema{Type: kind}}
+}
+
+type openapiContent map[string]struct {
+	Schema *openapiSchema `json:"schema"`
+}
+
+type openapiBody struct {
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Content     openapiContent `json:"content,omitempty"`
+}
+
+type openapiPathOperation struct {
+	OperationID string                  `json:"operationId"`
+	Summary     string                  `json:"summary,omitempty"`
+	Tags        []string                `json:"tags,omitempty"`
+	Parameters  []openapiParameter      `json:"parameters,omitempty"`
+	RequestBody *openapiBody            `json:"requestBody,omitempty"`
+	Responses   map[string]*openapiBody `json:"responses"`
+	// an empty list marks a public route
+	Security *[]map[string][]string `json:"security,omitempty"`
+	// the scopes an API token needs for the route
+	Scopes []string `json:"x-scopes,omitempty"`
+}
+
+type openapiDocument struct {
+	OpenAPI    string                                      `json:"openapi"`
+	Info       map[string]string                           `json:"info"`
+	Paths      map[string]map[string]*openapiPathOperation `json:"paths"`
+	Components struct {
+		Schemas         map[string]*openapiSchema    `json:"schemas"`
+		SecuritySchemes map[string]map[string]string `json:"securitySchemes"`
+	} `json:"components"`
+	Security []map[string][]string `json:"security"`
+
+	// request body schemas by "METHOD /path", used to validate requests
+	requests map[string]*openapiSchema
+}
+
+var openapi *openapiDocument
+
+var pathParameterPattern = regexp.MustCompile(`{([^}:]+)(:[^}]+)?}`)
+
+// LoadOpenAPI builds the OpenAPI document from the routes registered on the router,
+// public reports whether a path can be requested without authentication
+func LoadOpenAPI(router *mux.Router, public func(path string) bool) error {
+	document := &openapiDocument{
+		OpenAPI:  "3.0.3",
+		Info:     map[string]string{"title": "dev-blog", "version": "1.0.0"},
+		Paths:    make(map[string]map[string]*openapiPathOperation),
+		Security: []map[string][]string{{"token": {}}, {"session": {}}},
+		requests: make(map[string]*openapiSchema),
+	}
+	document.Components.Schemas = make(map[string]*openapiSchema)
+	document.Components.SecuritySchemes = map[string]map[string]string{
+		"token":   {"type": "apiKey", "in": "header", "name": AUTH_HEADER},
+		"session": {"type": "apiKey", "in": "cookie", "name": "user-session"},
+	}
+	document.Components.Schemas["FieldError"] = document.schemaFor(reflect.TypeOf(types.FieldError{}))
+
+	operation_ids := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// routes without methods aren't endpoints
+			return nil
+		}
+
+		parameters := make([]openapiParameter, 0)
+		names := make([]string, 0)
+		for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+			kind := "string"
+			if match[1] == "id" {
+				kind = "integer"
+			}
+			parameters = append(parameters, openapiParameter{Name: match[1], In: "path", Required: true, Schema: &openapiSchema{Type: kind}})
+			names = append(names, titleCase(match[1]))
+		}
+		path = pathParameterPattern.ReplaceAllString(path, "{$1}")
+
+		handler := runtime.FuncForPC(reflect.ValueOf(route.GetHandler()).Pointer()).Name()
+		handler = handler[strings.LastIndex(handler, ".")+1:]
+
+		for _, method := range methods {
+			key := method + " " + path
+			documented := openapiOperations[key]
+
+			// handlers registered on several routes get the path parameters or method added to their id
+			id := handler
+			if operation_ids[id] && len(names) > 0 {
+				id += "By" + strings.Join(names, "And")
+			}
+			if operation_ids[id] {
+				id += titleCase(strings.ToLower(method))
+			}
+			operation_ids[id] = true
+
+			operation := &openapiPathOperation{
+				OperationID: id,
+				Summary:     documented.Summary,
+				Tags:        []string{strings.Split(strings.TrimPrefix(path, "/"), "/")[0]},
+				Parameters:  append(append([]openapiParameter{}, parameters...), documented.Query...),
+				Responses:   map[string]*openapiBody{"200": {Description: "OK"}},
+			}
+			if documented.Response != nil {
+				operation.Responses["200"].Content = jsonContent(document.schemaFor(reflect.TypeOf(documented.Response)))
+			}
+			if documented.Request != nil {
+				schema := document.requestSchema(reflect.TypeOf(documented.Request), documented.Required)
+				operation.RequestBody = &openapiBody{Required: true, Content: jsonContent(schema)}
+				operation.Responses["400"] = &openapiBody{Description: "Invalid request body", Content: jsonContent(&openapiSchema{
+					Type: "object",
+					Properties: map[string]*openapiSchema{
+						"error":  {Type: "string"},
+						"fields": {Type: "array", Items: &openapiSchema{Ref: "#/components/schemas/FieldError"}},
+					},
+				})}
+				document.requests[key] = schema
+			}
+			if public(path) {
+				operation.Security = &[]map[string][]string{}
+			} else {
+				operation.Responses["401"] = &openapiBody{Description: "Unauthorized"}
+				operation.Responses["403"] = &openapiBody{Description: "Token is missing a scope"}
+				operation.Scopes = SCOPES
+				if scope, ok := routeScopes[key]; ok {
+					operation.Scopes = []string{}
+					if scope != "" {
+						operation.Scopes = []string{scope}
+					}
+				}
+			}
+
+			if document.Paths[path] == nil {
+				document.Paths[path] = make(map[string]*openapiPathOperation)
+			}
+			document.Paths[path][strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	openapi = document
+	return nil
+}
+
+// GET /openapi.json
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	utils.ResponseJSON(openapi, w)
+}
+
+// titleCase turns snake_case into TitleCase, for operation ids
+func titleCase(name string) string {
+	words := strings.Split(name, "_")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+func jsonContent(schema *openapiSchema) openapiContent {
+	content := make(openapiContent)
+	content["application/json"] = struct {
+		Schema *openapiSchema `json:"schema"`
+	}{schema}
+	return content
+}
+
+// requestSchema adds the required fields of an operation to the schema of the body type, required strings can't be empty
+func (d *openapiDocument) requestSchema(body reflect.Type, required []string) *openapiSchema {
+	schema := d.schemaFor(body)
+	if len(required) == 0 {
+		return schema
+	}
+
+	fields := d.resolve(schema).Properties
+	overlay := &openapiSchema{Required: required, Properties: make(map[string]*openapiSchema)}
+	for _, name := range required {
+		if field, ok := fields[name]; ok && field.Type == "string" {
+			overlay.Properties[name] = &openapiSchema{MinLength: 1}
+		}
+	}
+	return &openapiSchema{AllOf: []*openapiSchema{schema, overlay}}
+}
+
+// schemaFor generates the schema of a Go type from its json tags, named structs become components
+func (d *openapiDocument) schemaFor(t reflect.Type) *openapiSchema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &openapiSchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := d.schemaFor(t.Elem())
+		if schema.Ref != "" {
+			return &openapiSchema{AllOf: []*openapiSchema{schema}, Nullable: true}
+		}
+		nullable := *schema
+		nullable.Nullable = true
+		return &nullable
+	case reflect.String:
+		return &openapiSchema{Type: "string"}
+	case reflect.Bool:
+		return &openapiSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openapiSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openapiSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json accepts null for slices & maps
+		return &openapiSchema{Type: "array", Items: d.schemaFor(t.Elem()), Nullable: true}
+	case reflect.Map:
+		return &openapiSchema{Type: "object", AdditionalProperties: d.schemaFor(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		ref := &openapiSchema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// set before generating the fields, so self referencing types like CategoryNode terminate
+			d.Components.Schemas[t.Name()] = &openapiSchema{}
+			*d.Components.Schemas[t.Name()] = *d.structSchema(t)
+		}
+		return ref
+	}
+	// interface{} accepts any value
+	return &openapiSchema{}
+}
+
+func (d *openapiDocument) structSchema(t reflect.Type) *openapiSchema {
+	schema := &openapiSchema{Type: "object", Properties: make(map[string]*openapiSchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = d.schemaFor(field.Type)
+	}
+	return schema
+}
+
+func (d *openapiDocument) resolve(schema *openapiSchema) *openapiSchema {
+	if schema.Ref != "" {
+		return d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// validate appends a FieldError for every part of value that doesn't match the schema, path is the name of the field
+func (d *openapiDocument) validate(schema *openapiSchema, value interface{}, path string, invalid *[]types.FieldError) {
+	fail := func(message string) {
+		field := path
+		if field == "" {
+			field = "body"
+		}
+		*invalid = append(*invalid, types.FieldError{Field: field, Message: message})
+	}
+
+	schema = d.resolve(schema)
+	if value == nil {
+		if !schema.Nullable && (schema.Type != "" || len(schema.AllOf) > 0) {
+			fail("must not be null")
+		}
+		return
+	}
+	for _, part := range schema.AllOf {
+		d.validate(part, value, path, invalid)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if schema.Type != "" && schema.Type != "object" {
+			fail("must be " + schemaTypeName(schema.Type))
+			return
+		}
+		for _, name := range schema.Required {
+			if field, ok := value[name]; !ok || field == nil {
+				*invalid = append(*invalid, types.FieldError{Field: joinFieldPath(path, name), Message: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := schema.Properties[key]; ok {
+				d.validate(property, value[key], joinFieldPath(path, key), invalid)
+			} else if schema.AdditionalProperties != nil {
+				d.validate(schema.AdditionalProperties, value[key], joinFieldPath(path, key), invalid)
+			}
+		}
+	case []interface{}:
+		if schema.Type != "" && schema.Type != "array" {
+			fail("must be " + schemaTypeName(schema.Type))
+			return
+		}
+		if schema.Items != nil {
+			for i, item := range value {
+				d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), invalid)
+			}
+		}
+	case string:
+		if schema.Type != "" && schema.Type != "string" {
+			fail("must be " + schemaTypeName(schema.Type))
+			return
+		}
+		if len(value) < schema.MinLength {
+			fail("must not be empty")
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				fail("must be an RFC 3339 date")
+			}
+		}
+	case json.Number:
+		if schema.Type == "integer" {
+			if _, err := value.Int64(); err != nil {
+				fail("must be an integer")
+			}
+		} else if schema.Type != "" && schema.Type != "number" {
+			fail("must be " + schemaTypeName(schema.Type))
+		}
+	case bool:
+		if schema.Type != "" && schema.Type != "boolean" {
+			fail("must be " + schemaTypeName(schema.Type))
+		}
+	}
+}
+
+func schemaTypeName(kind string) string {
+	switch kind {
+	case "object", "array", "integer":
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+func joinFieldPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// ValidateRequest checks JSON request bodies against the OpenAPI document before they reach the handler,
+// malformed JSON is left for the handler to reject
+func ValidateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if openapi == nil || route == nil || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		schema, ok := openapi.requests[r.Method+" "+pathParameterPattern.ReplaceAllString(path, "{$1}")]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.LogError("Error reading request body", err, http.StatusBadRequest, w)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if decoder.Decode(&value) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		invalid := make([]types.FieldError, 0)
+		openapi.validate(schema, value, "", &invalid)
+		if len(invalid) > 0 {
+			utils.ValidationError("Invalid request body", invalid, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
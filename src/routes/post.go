@@ -2,14 +2,20 @@
 package routes
 
 import (
+	"blog-server/actions"
 	"blog-server/database"
 	"blog-server/types"
 	"blog-server/utils"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/gorilla/mux"
 )
 
@@ -27,12 +33,29 @@ func GetPostBySlug(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post, err := database.FetchPost(user, database.Slug, slug)
+	fields, invalid := parsePostFields(r, nil)
+	if len(invalid) > 0 {
+		utils.ValidationError("Invalid fields", invalid, w)
+		return
+	}
+
+	post, err := database.FetchPostFields(user, database.Slug, slug, fields)
 	if err != nil {
 		utils.LogError("Error fetching post by slug", err, http.StatusNotFound, w)
 		return
 	}
 
+	if fields != nil {
+		// partial copies can't be used with If-Match, so they get an ETag of their own
+		if utils.NotModified(w, r, sparsePostETag(post, fields), post.UpdatedAt) {
+			return
+		}
+		utils.ResponseJSON(sparsePost(post, fields), w)
+		return
+	}
+	if utils.NotModified(w, r, postETag(post), post.UpdatedAt) {
+		return
+	}
 	utils.ResponseJSON(post, w)
 }
 
@@ -56,6 +79,10 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id, err := database.CreatePost(newPost)
+	if errors.Is(err, database.ErrCategoryNotFound) {
+		utils.LogError("Invalid category", fmt.Errorf("Category '%s' doesn't exist", newPost.Category), http.StatusBadRequest, w)
+		return
+	}
 	if err != nil {
 		utils.LogError("Error creating new post", err, http.StatusInternalServerError, w)
 		return
@@ -67,6 +94,9 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	notifyPostChange(user, createdPost, false)
+
+	w.Header().Set("ETag", postETag(createdPost))
 	utils.ResponseJSON(createdPost, w)
 }
 
@@ -90,14 +120,260 @@ func EditPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// make sure the post exists & belongs to the user before overwriting it
+	_, err = database.FetchPost(user, database.ID, updatedPost.Id)
+	if err != nil {
+		utils.LogError("Error fetching post", err, http.StatusNotFound, w)
+		return
+	}
+
+	// an If-Match header takes precedence over the version in the body
+	if match := r.Header.Get("If-Match"); match != "" {
+		version, err := parsePostETag(match, updatedPost.Id)
+		if err != nil {
+			utils.LogError("Invalid If-Match header", err, http.StatusBadRequest, w)
+			return
+		}
+		updatedPost.Version = version
+	}
+
 	// Update the post in the database
 	err = database.UpdatePost(&updatedPost)
+	if errors.Is(err, database.ErrVersionConflict) {
+		// give the client the current copy so they can merge their changes
+		current, err := database.FetchPost(user, database.ID, updatedPost.Id)
+		if err != nil {
+			utils.LogError("Error fetching current post", err, http.StatusInternalServerError, w)
+			return
+		}
+		log.Warn("Rejected stale post edit", "id", updatedPost.Id, "version", updatedPost.Version, "current", current.Version)
+		w.Header().Set("ETag", postETag(current))
+		utils.ResponseJSONStatus(current, http.StatusConflict, w)
+		return
+	}
+	if errors.Is(err, database.ErrCategoryNotFound) {
+		utils.LogError("Invalid category", fmt.Errorf("Category '%s' doesn't exist", updatedPost.Category), http.StatusBadRequest, w)
+		return
+	}
 	if err != nil {
 		utils.LogError("Error updating post", err, http.StatusInternalServerError, w)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	post, err := database.FetchPost(user, database.ID, updatedPost.Id)
+	if err != nil {
+		utils.LogError("Error fetching updated post", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	notifyPostChange(user, post, false)
+
+	w.Header().Set("ETag", postETag(post))
+	utils.ResponseJSON(post, w)
+}
+
+// PATCH /post/{id}
+// applies a JSON Merge Patch (RFC 7396) to the editable fields of a post
+func PatchPost(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing post ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.NewDecoder(r.Body).Decode(&fields)
+	if err != nil || fields == nil {
+		utils.LogError("Error decoding patch", err, http.StatusBadRequest, w)
+		return
+	}
+
+	// make sure the post exists & belongs to the user before patching it
+	_, err = database.FetchPost(user, database.ID, postID)
+	if err != nil {
+		utils.LogError("Error fetching post", err, http.StatusNotFound, w)
+		return
+	}
+
+	categories, err := database.GetCategories(user)
+	if err != nil {
+		utils.LogError("Error fetching categories", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	patch, invalid := parsePostPatch(fields, categories)
+	if len(invalid) > 0 {
+		utils.ValidationError("Invalid patch", invalid, w)
+		return
+	}
+
+	var version int
+	if match := r.Header.Get("If-Match"); match != "" {
+		version, err = parsePostETag(match, postID)
+		if err != nil {
+			utils.LogError("Invalid If-Match header", err, http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	err = database.PatchPost(postID, patch, version)
+	if errors.Is(err, database.ErrVersionConflict) {
+		current, err := database.FetchPost(user, database.ID, postID)
+		if err != nil {
+			utils.LogError("Error fetching current post", err, http.StatusInternalServerError, w)
+			return
+		}
+		w.Header().Set("ETag", postETag(current))
+		utils.ResponseJSONStatus(current, http.StatusConflict, w)
+		return
+	}
+	if errors.Is(err, database.ErrSlugTaken) {
+		utils.ValidationError("Invalid patch", []types.FieldError{{Field: "slug", Message: "is used by another post"}}, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error patching post", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	post, err := database.FetchPost(user, database.ID, postID)
+	if err != nil {
+		utils.LogError("Error fetching patched post", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	notifyPostChange(user, post, false)
+
+	w.Header().Set("ETag", postETag(post))
+	utils.ResponseJSON(post, w)
+}
+
+// parsePostPatch validates the fields of a merge patch, a null value resets optional fields and is invalid for required ones
+func parsePostPatch(fields map[string]json.RawMessage, categories []types.Category) (types.PostPatch, []types.FieldError) {
+	var patch types.PostPatch
+	invalid := make([]types.FieldError, 0)
+	fail := func(field string, message string) {
+		invalid = append(invalid, types.FieldError{Field: field, Message: message})
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := fields[key]
+		null := string(raw) == "null"
+		switch key {
+		case "slug", "title", "content", "format", "category":
+			var value string
+			if null || json.Unmarshal(raw, &value) != nil || value == "" {
+				fail(key, "must be a non-empty string")
+				continue
+			}
+			switch key {
+			case "slug":
+				if strings.ContainsAny(value, " /") {
+					fail(key, "must not contain spaces or slashes")
+					continue
+				}
+				patch.Slug = &value
+			case "title":
+				patch.Title = &value
+			case "content":
+				patch.Content = &value
+			case "format":
+				if value != "md" && value != "adoc" {
+					fail(key, "must be one of md, adoc")
+					continue
+				}
+				patch.Format = &value
+			case "category":
+				if !categoryExists(categories, value) {
+					fail(key, "category doesn't exist")
+					continue
+				}
+				patch.Category = &value
+			}
+		case "description", "project_id":
+			var value string
+			if !null && json.Unmarshal(raw, &value) != nil {
+				fail(key, "must be a string or null")
+				continue
+			}
+			if key == "description" {
+				patch.Description = &value
+			} else {
+				patch.ProjectID = &value
+			}
+		case "tags":
+			tags := make([]string, 0)
+			if !null && json.Unmarshal(raw, &tags) != nil {
+				fail(key, "must be an array of strings or null")
+				continue
+			}
+			seen := make(map[string]bool)
+			for _, tag := range tags {
+				if tag == "" || seen[tag] {
+					fail(key, "tags must be non-empty and unique")
+					break
+				}
+				seen[tag] = true
+			}
+			patch.Tags = &tags
+		case "archived":
+			var value bool
+			if !null && json.Unmarshal(raw, &value) != nil {
+				fail(key, "must be a boolean or null")
+				continue
+			}
+			patch.Archived = &value
+		case "publish_at":
+			var value string
+			if null || json.Unmarshal(raw, &value) != nil {
+				fail(key, "must be a date string")
+				continue
+			}
+			publish, err := parseDate(value)
+			if err != nil {
+				fail(key, "must be an RFC 3339 date")
+				continue
+			}
+			patch.PublishAt = &publish
+		default:
+			fail(key, "field can't be patched")
+		}
+	}
+
+	return patch, invalid
+}
+
+func categoryExists(categories []types.Category, name string) bool {
+	if name == "root" {
+		return true
+	}
+	for _, category := range categories {
+		if category.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDate accepts RFC 3339 dates, as well as dates without a timezone which the client sends
+func parseDate(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Parse("2006-01-02T15:04:05", value)
+	}
+	return parsed, nil
 }
 
 func DeletePost(w http.ResponseWriter, r *http.Request) {
@@ -133,5 +409,51 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	notifyPostChange(user, post, true)
+
 	w.WriteHeader(http.StatusOK)
 }
+
+// notifyPostChange sends a changed post out to followers & newsletter subscribers
+func notifyPostChange(user *types.User, post types.Post, deleted bool) {
+	actions.FederatePost(user, post, deleted)
+	if !deleted {
+		actions.SendNewsletter(user, post)
+	}
+}
+
+// notifyPostChangeByID re-fetches a post after it has been changed and notifies about the change
+func notifyPostChangeByID(user *types.User, id int) {
+	post, err := database.FetchPost(user, database.ID, id)
+	if err != nil {
+		log.Error("Error fetching changed post", "id", id, "err", err)
+		return
+	}
+	notifyPostChange(user, post, false)
+}
+
+// postETag identifies a version of a post, clients send it back in If-Match when editing
+func postETag(post types.Post) string {
+	return fmt.Sprintf(`"post-%d-v%d"`, post.Id, post.Version)
+}
+
+func sparsePostETag(post types.Post, fields []string) string {
+	return fmt.Sprintf(`"post-%d-v%d-%s"`, post.Id, post.Version, strings.Join(fields, "."))
+}
+
+// parsePostETag returns the version from an If-Match header, "*" matches any version so returns 0
+func parsePostETag(header string, postID int) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, nil
+	}
+	var id, version int
+	_, err := fmt.Sscanf(header, `"post-%d-v%d"`, &id, &version)
+	if err != nil {
+		return 0, err
+	}
+	if id != postID {
+		return 0, errors.New("ETag is for a different post")
+	}
+	return version, nil
+}
@@ -5,9 +5,13 @@ import (
 	"blog-server/database"
 	"blog-server/types"
 	"blog-server/utils"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -19,14 +23,17 @@ func FetchPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract category parameter from URL
-	category := mux.Vars(r)["category"]
-	if category == "" {
-		category = "root"
+	filter, invalid := parsePostFilter(r)
+	if len(invalid) > 0 {
+		utils.ValidationError("Invalid post filters", invalid, w)
+		return
 	}
-	tag := r.URL.Query().Get("tag")
 
-    project_id := mux.Vars(r)["project_id"]
+	fields, invalid := parsePostFields(r, POST_SUMMARY_FIELDS)
+	if len(invalid) > 0 {
+		utils.ValidationError("Invalid fields", invalid, w)
+		return
+	}
 
 	user := utils.GetUser(r)
 
@@ -35,26 +42,124 @@ func FetchPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, totalPosts, err := database.GetPosts(user, category, tag, limit, offset, project_id)
+	if contentNotModified(w, r, user.ID) {
+		return
+	}
+
+	posts, totalPosts, err := database.GetPosts(user, filter, limit, offset, fields)
 	if err != nil {
 		utils.LogError("Error fetching posts by category", err, http.StatusInternalServerError, w)
 		return
 	}
 
+	response := buildPostsResponse(posts, totalPosts, limit, offset)
+	if fields == nil {
+		utils.ResponseJSON(response, w)
+		return
+	}
+
+	// the posts of the embedded response are shadowed by the sparse posts
+	sparse := struct {
+		types.PostsResponse
+		Posts []map[string]interface{} `json:"posts"`
+	}{response, make([]map[string]interface{}, len(posts))}
+	for i, post := range posts {
+		sparse.Posts[i] = sparsePost(post, fields)
+	}
+	utils.ResponseJSON(sparse, w)
+}
+
+func buildPostsResponse(posts []types.Post, totalPosts, limit, offset int) types.PostsResponse {
+	// a limit of -1 has every post on one page
+	if limit < 0 {
+		return types.PostsResponse{Posts: posts, TotalPosts: totalPosts, TotalPages: 1, PerPage: limit, CurrentPage: 1}
+	}
+
 	// Calculate pagination information
 	totalPages := (totalPosts + limit - 1) / limit
 	currentPage := (offset / limit) + 1
 
 	// Create a response structure including pagination information
-	response := types.PostsResponse{
+	return types.PostsResponse{
 		Posts:       posts,
 		TotalPosts:  totalPosts,
 		TotalPages:  totalPages,
 		PerPage:     limit,
 		CurrentPage: currentPage,
 	}
+}
+
+const MAX_BULK_POSTS = 500
+
+// POST /posts/bulk
+func BulkPosts(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
 
-	utils.ResponseJSON(response, w)
+	var operation types.BulkOperation
+	err := json.NewDecoder(r.Body).Decode(&operation)
+	if err != nil {
+		utils.LogError("Error decoding bulk operation", err, http.StatusBadRequest, w)
+		return
+	}
+
+	categories, err := database.GetCategories(user)
+	if err != nil {
+		utils.LogError("Error fetching categories", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	invalid := validateBulkOperation(operation, categories)
+	if len(invalid) > 0 {
+		utils.ValidationError("Invalid bulk operation", invalid, w)
+		return
+	}
+
+	results, err := database.BulkUpdatePosts(user, operation)
+	if err != nil {
+		utils.LogError("Error applying bulk operation", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	for _, result := range results {
+		if result.Status != "ok" {
+			continue
+		}
+		if operation.Operation == "delete" {
+			notifyPostChange(user, types.Post{Id: result.ID, AuthorID: user.ID}, true)
+		} else {
+			notifyPostChangeByID(user, result.ID)
+		}
+	}
+
+	utils.ResponseJSON(map[string]interface{}{
+		"operation": operation.Operation,
+		"results":   results,
+	}, w)
+}
+
+func validateBulkOperation(operation types.BulkOperation, categories []types.Category) []types.FieldError {
+	invalid := make([]types.FieldError, 0)
+	if len(operation.IDs) == 0 || len(operation.IDs) > MAX_BULK_POSTS {
+		invalid = append(invalid, types.FieldError{Field: "ids", Message: fmt.Sprintf("must contain between 1 and %d ids", MAX_BULK_POSTS)})
+	}
+	if !slices.Contains(database.BULK_OPERATIONS, operation.Operation) {
+		invalid = append(invalid, types.FieldError{Field: "operation", Message: "must be one of " + strings.Join(database.BULK_OPERATIONS, ", ")})
+	}
+	switch operation.Operation {
+	case "set_category":
+		if !categoryExists(categories, operation.Category) {
+			invalid = append(invalid, types.FieldError{Field: "category", Message: "category doesn't exist"})
+		}
+	case "add_tags", "remove_tags":
+		if len(operation.Tags) == 0 || slices.Contains(operation.Tags, "") {
+			invalid = append(invalid, types.FieldError{Field: "tags", Message: "must contain at least one non-empty tag"})
+		}
+	}
+	return invalid
 }
 
 func buildPreparedParams(length int) string {
@@ -65,6 +170,132 @@ func buildPreparedParams(length int) string {
 	return "(" + strings.Join(placeholders, ",") + ")"
 }
 
+// parsePostFilter reads the filters of a post listing from the route & query parameters.
+// tag lists can either be comma separated or repeated, e.g. ?tags_any=go,rust or ?tags_any=go&tags_any=rust
+func parsePostFilter(r *http.Request) (types.PostFilter, []types.FieldError) {
+	query := r.URL.Query()
+	invalid := make([]types.FieldError, 0)
+
+	filter := types.PostFilter{
+		Category:  mux.Vars(r)["category"],
+		TagsAll:   parseListParam(query["tags_all"]),
+		TagsAny:   parseListParam(query["tags_any"]),
+		TagsNone:  parseListParam(query["tags_none"]),
+		Format:    query.Get("format"),
+		ProjectID: mux.Vars(r)["project_id"],
+	}
+	if filter.Category == "" {
+		filter.Category = "root"
+	}
+	// ?tag= is the original single tag filter
+	if tag := query.Get("tag"); tag != "" {
+		filter.TagsAll = append(filter.TagsAll, tag)
+	}
+	if project := query.Get("project"); project != "" {
+		filter.ProjectID = project
+	}
+
+	if archived := query.Get("archived"); archived != "" {
+		value, err := strconv.ParseBool(archived)
+		if err != nil {
+			invalid = append(invalid, types.FieldError{Field: "archived", Message: "must be true or false"})
+		} else {
+			filter.Archived = &value
+		}
+	}
+
+	dates := []struct {
+		field  string
+		target **time.Time
+	}{
+		{"published_after", &filter.PublishedAfter},
+		{"published_before", &filter.PublishedBefore},
+	}
+	for _, date_param := range dates {
+		field, target := date_param.field, date_param.target
+		value := query.Get(field)
+		if value == "" {
+			continue
+		}
+		date, err := parseFilterDate(value)
+		if err != nil {
+			invalid = append(invalid, types.FieldError{Field: field, Message: "must be a date"})
+			continue
+		}
+		*target = &date
+	}
+
+	return filter, invalid
+}
+
+// parseFilterDate accepts the same dates as posts, or a plain date
+func parseFilterDate(value string) (time.Time, error) {
+	date, err := parseDate(value)
+	if err != nil {
+		return time.Parse("2006-01-02", value)
+	}
+	return date, nil
+}
+
+// POST_SUMMARY_FIELDS is the "summary" projection, everything a listing needs without the content. Listings default to it
+var POST_SUMMARY_FIELDS = []string{"id", "slug", "title", "description", "category", "tags", "archived", "publish_at", "updated_at"}
+
+// parsePostFields reads the comma separated fields= parameter, returning nil when every field should be returned.
+// defaults are the fields without a fields= parameter, and "all" is every field
+func parsePostFields(r *http.Request, defaults []string) ([]string, []types.FieldError) {
+	values := parseListParam(r.URL.Query()["fields"])
+	if len(values) == 0 {
+		return defaults, nil
+	}
+	if slices.Contains(values, "all") {
+		return nil, nil
+	}
+
+	// the id is always included so posts can be told apart
+	fields := []string{"id"}
+	invalid := make([]types.FieldError, 0)
+	for _, value := range values {
+		expanded := []string{value}
+		if value == "summary" {
+			expanded = POST_SUMMARY_FIELDS
+		} else if !slices.Contains(database.POST_FIELDS, value) {
+			invalid = append(invalid, types.FieldError{Field: "fields", Message: fmt.Sprintf("unknown field '%s', must be summary, all or one of %s", value, strings.Join(database.POST_FIELDS, ", "))})
+			continue
+		}
+		for _, field := range expanded {
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields, invalid
+}
+
+// sparsePost serializes only the given fields of a post
+func sparsePost(post types.Post, fields []string) map[string]interface{} {
+	encoded, _ := json.Marshal(post)
+	var all map[string]interface{}
+	json.Unmarshal(encoded, &all)
+
+	sparse := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		sparse[field] = all[field]
+	}
+	return sparse
+}
+
+func parseListParam(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
 func parsePaginationParams(r *http.Request) (int, int, error) {
 	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
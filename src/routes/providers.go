@@ -0,0 +1,267 @@
+// providers.go
+package routes
+
+import (
+	"blog-server/database"
+	"blog-server/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// LoginProvider is an OAuth2 provider users can log in with
+type LoginProvider interface {
+	// Config returns the OAuth2 config of the provider
+	Config(ctx context.Context) (*oauth2.Config, error)
+	// Identity fetches who logged in from the provider with their token
+	Identity(ctx context.Context, token *oauth2.Token) (types.Identity, error)
+}
+
+// providers are the configured login providers by name, the name is used in the login & callback urls
+var providers map[string]LoginProvider
+
+// loadProviders configures a provider for each one with a client id in the env, GitHub was the only provider
+// before the others were added, so it's always available
+func loadProviders() {
+	providers = map[string]LoginProvider{
+		"github": &oauthProvider{
+			name: "github",
+			config: oauth2.Config{
+				ClientID:     os.Getenv("GITHUB_CLIENT"),
+				ClientSecret: os.Getenv("GITHUB_SECRET"),
+				RedirectURL:  callbackURL("github", os.Getenv("GITHUB_CALLBACK")),
+				Scopes:       []string{"read:user"},
+				Endpoint:     github.Endpoint,
+			},
+			user_url: "https://api.github.com/user",
+			fields:   identityFields{subject: "id", username: "login", email: "email", avatar: "avatar_url"},
+		},
+	}
+
+	if client := os.Getenv("GITLAB_CLIENT"); client != "" {
+		base := strings.TrimSuffix(os.Getenv("GITLAB_URL"), "/")
+		if base == "" {
+			base = "https://gitlab.com"
+		}
+		providers["gitlab"] = &oauthProvider{
+			name: "gitlab",
+			config: oauth2.Config{
+				ClientID:     client,
+				ClientSecret: os.Getenv("GITLAB_SECRET"),
+				RedirectURL:  callbackURL("gitlab", os.Getenv("GITLAB_CALLBACK")),
+				Scopes:       []string{"read_user"},
+				Endpoint:     oauth2.Endpoint{AuthURL: base + "/oauth/authorize", TokenURL: base + "/oauth/token"},
+			},
+			user_url: base + "/api/v4/user",
+			fields:   identityFields{subject: "id", username: "username", email: "email", avatar: "avatar_url"},
+		}
+	}
+
+	if client := os.Getenv("GITEA_CLIENT"); client != "" {
+		base := strings.TrimSuffix(os.Getenv("GITEA_URL"), "/")
+		if base == "" {
+			log.Error("GITEA_URL is required for Gitea logins")
+		} else {
+			providers["gitea"] = &oauthProvider{
+				name: "gitea",
+				config: oauth2.Config{
+					ClientID:     client,
+					ClientSecret: os.Getenv("GITEA_SECRET"),
+					RedirectURL:  callbackURL("gitea", os.Getenv("GITEA_CALLBACK")),
+					Scopes:       []string{"read:user"},
+					Endpoint:     oauth2.Endpoint{AuthURL: base + "/login/oauth/authorize", TokenURL: base + "/login/oauth/access_token"},
+				},
+				user_url: base + "/api/v1/user",
+				fields:   identityFields{subject: "id", username: "login", email: "email", avatar: "avatar_url"},
+			}
+		}
+	}
+
+	if client := os.Getenv("OIDC_CLIENT"); client != "" {
+		name := os.Getenv("OIDC_NAME")
+		if name == "" {
+			name = "oidc"
+		}
+		issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+		// local accounts use the email & password routes
+		if issuer == "" || providers[name] != nil || name == database.EMAIL_PROVIDER || name == "password" {
+			log.Error("OIDC_ISSUER is required and OIDC_NAME can't be another provider's name", "name", name)
+		} else {
+			providers[name] = &oidcProvider{
+				name:   name,
+				issuer: issuer,
+				config: oauth2.Config{
+					ClientID:     client,
+					ClientSecret: os.Getenv("OIDC_SECRET"),
+					RedirectURL:  callbackURL(name, os.Getenv("OIDC_CALLBACK")),
+					Scopes:       []string{"openid", "profile", "email"},
+				},
+			}
+		}
+	}
+
+	for name := range providers {
+		log.Info("Loaded login provider", "provider", name)
+	}
+}
+
+// callbackURL is the configured callback of a provider, or its callback route on the SERVER_URL
+func callbackURL(name string, configured string) string {
+	if configured != "" {
+		return configured
+	}
+	return strings.TrimSuffix(os.Getenv("SERVER_URL"), "/") + "/auth/" + name + "/callback"
+}
+
+// identityFields are the fields of a provider's user response that make up an identity
+type identityFields struct {
+	subject  string
+	username string
+	email    string
+	avatar   string
+}
+
+// oauthProvider is a provider with fixed endpoints & a user api, e.g. GitHub, GitLab or Gitea
+type oauthProvider struct {
+	name     string
+	config   oauth2.Config
+	user_url string
+	fields   identityFields
+}
+
+func (p *oauthProvider) Config(ctx context.Context) (*oauth2.Config, error) {
+	return &p.config, nil
+}
+
+func (p *oauthProvider) Identity(ctx context.Context, token *oauth2.Token) (types.Identity, error) {
+	return fetchIdentity(ctx, p.config.Client(ctx, token), p.name, p.user_url, p.fields)
+}
+
+// oidcProvider is an OpenID Connect provider, its endpoints are discovered from the issuer on the first login.
+// Who logged in is fetched from the userinfo endpoint with the access token rather than read from the id token,
+// so the id token's signature doesn't have to be checked
+type oidcProvider struct {
+	name   string
+	issuer string
+
+	mutex        sync.Mutex
+	config       oauth2.Config
+	userinfo_url string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+func (p *oidcProvider) Config(ctx context.Context) (*oauth2.Config, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.userinfo_url != "" {
+		return &p.config, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned %d", response.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	err = json.NewDecoder(response.Body).Decode(&discovery)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer %q doesn't match %q", discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.UserinfoEndpoint == "" {
+		return nil, errors.New("OIDC discovery is missing an endpoint")
+	}
+
+	p.config.Endpoint = oauth2.Endpoint{AuthURL: discovery.AuthorizationEndpoint, TokenURL: discovery.TokenEndpoint}
+	p.userinfo_url = discovery.UserinfoEndpoint
+	log.Info("Discovered OIDC provider", "provider", p.name, "issuer", p.issuer)
+	return &p.config, nil
+}
+
+func (p *oidcProvider) Identity(ctx context.Context, token *oauth2.Token) (types.Identity, error) {
+	config, err := p.Config(ctx)
+	if err != nil {
+		return types.Identity{}, err
+	}
+	fields := identityFields{subject: "sub", username: "preferred_username", email: "email", avatar: "picture"}
+	return fetchIdentity(ctx, config.Client(ctx, token), p.name, p.userinfo_url, fields)
+}
+
+// fetchIdentity requests the user of a token from a provider's user endpoint
+func fetchIdentity(ctx context.Context, client *http.Client, provider string, url string, fields identityFields) (types.Identity, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return types.Identity{}, err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return types.Identity{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return types.Identity{}, fmt.Errorf("Invalid status code %d fetching %s user", response.StatusCode, provider)
+	}
+
+	var user map[string]interface{}
+	decoder := json.NewDecoder(response.Body)
+	// numeric ids are kept as they are, rather than as floats
+	decoder.UseNumber()
+	if err := decoder.Decode(&user); err != nil {
+		return types.Identity{}, err
+	}
+
+	field := func(name string) string {
+		switch value := user[name].(type) {
+		case string:
+			return value
+		case json.Number:
+			return value.String()
+		}
+		return ""
+	}
+	identity := types.Identity{
+		Provider:  provider,
+		Subject:   field(fields.subject),
+		Username:  field(fields.username),
+		Email:     field(fields.email),
+		AvatarURL: field(fields.avatar),
+	}
+	if identity.Subject == "" {
+		return identity, fmt.Errorf("The %s user has no %s", provider, fields.subject)
+	}
+	if identity.Username == "" {
+		identity.Username, _, _ = strings.Cut(identity.Email, "@")
+	}
+	identity.Username = cleanUsername(identity.Username)
+	if identity.Username == "" {
+		identity.Username = cleanUsername(provider + "-" + identity.Subject)
+	}
+	return identity, nil
+}
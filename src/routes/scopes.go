@@ -0,0 +1,149 @@
+// scopes.go
+package routes
+
+import (
+	"blog-server/database"
+	"blog-server/types"
+	"blog-server/utils"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	SCOPE_POSTS_READ        = "posts:read"
+	SCOPE_POSTS_WRITE       = "posts:write"
+	SCOPE_CATEGORIES_WRITE  = "categories:write"
+	SCOPE_TOKENS_ADMIN      = "tokens:admin"
+	SCOPE_INTEGRATIONS_SYNC = "integrations:sync"
+	SCOPE_NEWSLETTER_READ   = "newsletter:read"
+)
+
+// SCOPES are every scope a token can have, tokens created without scopes get all of them
+var SCOPES = []string{
+	SCOPE_POSTS_READ,
+	SCOPE_POSTS_WRITE,
+	SCOPE_CATEGORIES_WRITE,
+	SCOPE_TOKENS_ADMIN,
+	SCOPE_INTEGRATIONS_SYNC,
+	SCOPE_NEWSLETTER_READ,
+}
+
+// roleScopes are the scopes of the routes each role can use, whether logged in or with a token. Readers can't
+// change content, routes without a scope aren't limited by role
+var roleScopes = map[string][]string{
+	database.ROLE_ADMIN:  SCOPES,
+	database.ROLE_AUTHOR: SCOPES,
+	database.ROLE_READER: {SCOPE_POSTS_READ, SCOPE_TOKENS_ADMIN},
+}
+
+// routeScopes are the scopes a token needs for each authenticated route, keyed by "METHOD /path".
+// An empty scope means any token can use the route, routes missing here need a token with every scope
+var routeScopes = map[string]string{
+	"GET /posts":                      SCOPE_POSTS_READ,
+	"GET /posts/{category}":           SCOPE_POSTS_READ,
+	"GET /post/{slug}":                SCOPE_POSTS_READ,
+	"GET /project/posts/{project_id}": SCOPE_POSTS_READ,
+	"GET /categories":                 SCOPE_POSTS_READ,
+	"GET /tags":                       SCOPE_POSTS_READ,
+	"POST /tags/suggest":              SCOPE_POSTS_READ,
+	"POST /graphql":                   SCOPE_POSTS_READ,
+	"POST /posts/bulk":                SCOPE_POSTS_WRITE,
+	"POST /post/new":                  SCOPE_POSTS_WRITE,
+	"PUT /post/edit":                  SCOPE_POSTS_WRITE,
+	"PATCH /post/{id}":                SCOPE_POSTS_WRITE,
+	"DELETE /post/delete/{id}":        SCOPE_POSTS_WRITE,
+	"PUT /post/tag":                   SCOPE_POSTS_WRITE,
+	"DELETE /post/tag":                SCOPE_POSTS_WRITE,
+	"POST /tags/merge":                SCOPE_POSTS_WRITE,
+	"PUT /tag/{tag}":                  SCOPE_POSTS_WRITE,
+	"DELETE /tag/{tag}":               SCOPE_POSTS_WRITE,
+	"PATCH /tag/{tag}":                SCOPE_POSTS_WRITE,
+	"POST /category/new":              SCOPE_CATEGORIES_WRITE,
+	"DELETE /category/delete/{name}":  SCOPE_CATEGORIES_WRITE,
+	"PUT /category/{name}":            SCOPE_CATEGORIES_WRITE,
+	"GET /tokens":                     SCOPE_TOKENS_ADMIN,
+	"POST /token/new":                 SCOPE_TOKENS_ADMIN,
+	"PUT /token/edit":                 SCOPE_TOKENS_ADMIN,
+	"POST /token/rotate/{id}":         SCOPE_TOKENS_ADMIN,
+	"DELETE /token/delete/{id}":       SCOPE_TOKENS_ADMIN,
+	"GET /links":                      SCOPE_INTEGRATIONS_SYNC,
+	"PUT /links/upsert":               SCOPE_INTEGRATIONS_SYNC,
+	"GET /links/fetch/{source}":       SCOPE_INTEGRATIONS_SYNC,
+	"DELETE /links/delete/{id}":       SCOPE_INTEGRATIONS_SYNC,
+	"GET /projects":                   SCOPE_INTEGRATIONS_SYNC,
+	"PUT /project/key":                SCOPE_INTEGRATIONS_SYNC,
+	"GET /newsletter/subscribers":     SCOPE_NEWSLETTER_READ,
+	"GET /newsletter/sends":           SCOPE_NEWSLETTER_READ,
+	"GET /auth/user":                  "",
+	"GET /auth/logout":                "",
+}
+
+// RequiredScopes returns the scopes a token needs to make the request
+func RequiredScopes(r *http.Request) []string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return SCOPES
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return SCOPES
+	}
+	scope, ok := routeScopes[r.Method+" "+path]
+	if !ok {
+		return SCOPES
+	}
+	if scope == "" {
+		return nil
+	}
+	return []string{scope}
+}
+
+// RoleMissingScope returns the scope of the request's route that the role can't use, or "" if it can use the route
+func RoleMissingScope(role string, r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	scope := routeScopes[r.Method+" "+path]
+	if scope == "" || slices.Contains(roleScopes[role], scope) {
+		return ""
+	}
+	return scope
+}
+
+// MissingScope returns the first of the scopes the token doesn't have, or "" if it has them all
+func MissingScope(token *types.AccessKey, scopes []string) string {
+	for _, scope := range scopes {
+		if !slices.Contains(token.Scopes, scope) {
+			return scope
+		}
+	}
+	return ""
+}
+
+// validateScopes checks the scopes of a new or edited token are known, and that a request made with a token doesn't
+// give the new token scopes its own token lacks
+func validateScopes(r *http.Request, scopes []string) []types.FieldError {
+	var fields []types.FieldError
+	if scopes != nil && len(scopes) == 0 {
+		fields = append(fields, types.FieldError{Field: "scopes", Message: "must not be empty"})
+	}
+	for i, scope := range scopes {
+		field := fmt.Sprintf("scopes[%d]", i)
+		if !slices.Contains(SCOPES, scope) {
+			fields = append(fields, types.FieldError{Field: field, Message: "is not a known scope"})
+		} else if token := utils.GetToken(r); token != nil && !slices.Contains(token.Scopes, scope) {
+			fields = append(fields, types.FieldError{Field: field, Message: "is not a scope of the token making the request"})
+		} else if user := utils.GetUser(r); user != nil && !slices.Contains(roleScopes[user.Role], scope) {
+			fields = append(fields, types.FieldError{Field: field, Message: "is not a scope of the user's role"})
+		}
+	}
+	return fields
+}
@@ -0,0 +1,89 @@
+// sessions.go
+package routes
+
+import (
+	"blog-server/database"
+	"blog-server/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// currentSession is the session token of the request, or "" when it's made with an api token
+func currentSession(r *http.Request) string {
+	session, err := database.GetSessionStore().Get(r, utils.USER_SESSION)
+	if err != nil || session.IsNew {
+		return ""
+	}
+	return session.ID
+}
+
+// GET /auth/sessions
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	sessions, err := database.GetSessions(user.ID, currentSession(r))
+	if err != nil {
+		utils.LogError("Error fetching sessions", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(sessions, w)
+}
+
+// DELETE /auth/sessions/{id}
+func DeleteSession(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.LogError("Error parsing session ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	err = database.DeleteSession(user.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.LogError("Session not found", err, http.StatusNotFound, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error deleting session", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DELETE /auth/sessions
+// Logs out every session of the user, ?others=true keeps the session making the request
+func DeleteSessions(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	keep := ""
+	if r.URL.Query().Get("others") == "true" {
+		keep = currentSession(r)
+	}
+
+	deleted, err := database.DeleteSessions(user.ID, keep)
+	if err != nil {
+		utils.LogError("Error deleting sessions", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.ResponseJSON(map[string]int{"deleted": deleted}, w)
+}
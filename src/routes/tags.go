@@ -2,11 +2,21 @@
 package routes
 
 import (
+	"blog-server/actions"
 	"blog-server/database"
+	"blog-server/types"
 	"blog-server/utils"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
 )
 
 func AddPostTag(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +49,8 @@ func AddPostTag(w http.ResponseWriter, r *http.Request) {
 		return;
 	}
 
+    notifyPostChangeByID(user, params.id);
+
 	w.WriteHeader(http.StatusOK);
 }
 
@@ -71,24 +83,271 @@ func DeletePostTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+    notifyPostChangeByID(user, params.id);
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// GET /tags
+// ?unused=true includes tags that aren't on any posts, ?cloud=true adds a weight to each tag for a tag cloud
 func GetTags(w http.ResponseWriter, r *http.Request) {
     user := utils.GetUser(r);
     if user == nil {
         utils.Unauthorized(w);
         return;
     }
-	tags, err := database.GetTags(user)
+
+    if contentNotModified(w, r, user.ID) {
+        return;
+    }
+
+	tags, err := database.GetTags(user, r.URL.Query().Get("unused") == "true")
 	if err != nil {
         utils.LogError("Error fetching tags", err, http.StatusInternalServerError, w);
 		return
 	}
 
+    if r.URL.Query().Get("cloud") == "true" {
+        setTagWeights(tags);
+    }
+
     utils.ResponseJSON(tags, w);
 }
 
+// PATCH /tag/{tag}
+// updates the description, colour and/or slug of a tag
+func PatchTag(w http.ResponseWriter, r *http.Request) {
+    user := utils.GetUser(r);
+    if user == nil {
+        utils.Unauthorized(w);
+        return;
+    }
+
+    var changes types.TagUpdate
+    err := json.NewDecoder(r.Body).Decode(&changes);
+    if err != nil {
+        utils.LogError("Error decoding tag", err, http.StatusBadRequest, w);
+        return;
+    }
+
+    tags, err := database.GetTags(user, true);
+    if err != nil {
+        utils.LogError("Error fetching tags", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    var tag *types.Tag
+    for i := range tags {
+        if tags[i].Name == mux.Vars(r)["tag"] {
+            tag = &tags[i]
+        }
+    }
+    if tag == nil {
+        http.Error(w, "Not Found", http.StatusNotFound);
+        return;
+    }
+
+    // omitted fields are left unchanged
+    if changes.Description != nil {
+        tag.Description = *changes.Description
+    }
+    if changes.Colour != nil {
+        tag.Colour = strings.ToLower(*changes.Colour)
+    }
+    if changes.Slug != nil {
+        tag.Slug = *changes.Slug
+        if tag.Slug == "" {
+            tag.Slug = utils.Slugify(tag.Name)
+        }
+    }
+
+    invalid := make([]types.FieldError, 0);
+    if tag.Colour != "" && !TAG_COLOUR.MatchString(tag.Colour) {
+        invalid = append(invalid, types.FieldError{Field: "colour", Message: "must be a hex colour, like #1e90ff"});
+    }
+    if tag.Slug != utils.Slugify(tag.Slug) {
+        invalid = append(invalid, types.FieldError{Field: "slug", Message: "can only contain lowercase letters, numbers & dashes"});
+    }
+    for _, other := range tags {
+        if other.ID != tag.ID && other.Slug == tag.Slug {
+            invalid = append(invalid, types.FieldError{Field: "slug", Message: "is already used by another tag"});
+        }
+    }
+    if len(invalid) > 0 {
+        utils.ValidationError("Invalid tag", invalid, w);
+        return;
+    }
+
+    err = database.UpdateTag(user, *tag);
+    if err != nil {
+        utils.LogError("Error updating tag", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    utils.ResponseJSON(tag, w);
+}
+
+const (
+	DEFAULT_TAG_SUGGESTIONS = 5
+	MAX_TAG_SUGGESTIONS     = 20
+)
+
+// POST /tags/suggest
+// ranks the user's existing tags for a draft post
+func SuggestTags(w http.ResponseWriter, r *http.Request) {
+    user := utils.GetUser(r);
+    if user == nil {
+        utils.Unauthorized(w);
+        return;
+    }
+
+    var draft types.TagSuggestionRequest
+    err := json.NewDecoder(r.Body).Decode(&draft);
+    if err != nil {
+        utils.LogError("Error decoding draft", err, http.StatusBadRequest, w);
+        return;
+    }
+
+    if draft.Limit == 0 {
+        draft.Limit = DEFAULT_TAG_SUGGESTIONS
+    }
+    if draft.Limit < 0 || draft.Limit > MAX_TAG_SUGGESTIONS {
+        utils.ValidationError("Invalid suggestion request", []types.FieldError{{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", MAX_TAG_SUGGESTIONS)}}, w);
+        return;
+    }
+
+    // the whole corpus of the author, tags are learnt from every post they've written
+    posts, _, err := database.GetPosts(user, types.PostFilter{Category: "root"}, -1, 0, []string{"title", "content", "tags"});
+    if err != nil {
+        utils.LogError("Error fetching posts", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    utils.ResponseJSON(actions.SuggestTags(draft, posts, draft.Limit), w);
+}
+
+var TAG_COLOUR = regexp.MustCompile("^#([0-9a-f]{3}|[0-9a-f]{6})$")
+
+// setTagWeights scales the usage of each tag logarithmically between 0 and 1,
+// so a few heavily used tags don't flatten the rest of the cloud
+func setTagWeights(tags []types.Tag) {
+    least, most := 0, 0
+    for _, tag := range tags {
+        if tag.Count == 0 {
+            continue
+        }
+        if least == 0 || tag.Count < least {
+            least = tag.Count
+        }
+        if tag.Count > most {
+            most = tag.Count
+        }
+    }
+
+    for i := range tags {
+        weight := 0.0
+        if tags[i].Count > 0 {
+            weight = 1.0
+            if most > least {
+                weight = (math.Log(float64(tags[i].Count)) - math.Log(float64(least))) / (math.Log(float64(most)) - math.Log(float64(least)))
+            }
+        }
+        tags[i].Weight = &weight
+    }
+}
+
+// PUT /tag/{tag}
+// renames a tag on every post, renaming into an existing tag merges them
+func RenameTag(w http.ResponseWriter, r *http.Request) {
+    user := utils.GetUser(r);
+    if user == nil {
+        utils.Unauthorized(w);
+        return;
+    }
+
+    var body types.TagRename
+    err := json.NewDecoder(r.Body).Decode(&body);
+    if err != nil {
+        utils.LogError("Error decoding tag", err, http.StatusBadRequest, w);
+        return;
+    }
+
+    tag := mux.Vars(r)["tag"];
+    if body.Name == "" || body.Name == tag {
+        utils.ValidationError("Invalid tag rename", []types.FieldError{{Field: "name", Message: "must be a new, non-empty tag"}}, w);
+        return;
+    }
+
+    replaceTags(user, "rename", []string{tag}, body.Name, w, r);
+}
+
+// POST /tags/merge
+func MergeTags(w http.ResponseWriter, r *http.Request) {
+    user := utils.GetUser(r);
+    if user == nil {
+        utils.Unauthorized(w);
+        return;
+    }
+
+    var operation types.TagOperation
+    err := json.NewDecoder(r.Body).Decode(&operation);
+    if err != nil {
+        utils.LogError("Error decoding tag merge", err, http.StatusBadRequest, w);
+        return;
+    }
+
+    invalid := make([]types.FieldError, 0);
+    if len(operation.Tags) == 0 || slices.Contains(operation.Tags, "") {
+        invalid = append(invalid, types.FieldError{Field: "tags", Message: "must contain at least one non-empty tag"});
+    }
+    if operation.Into == "" {
+        invalid = append(invalid, types.FieldError{Field: "into", Message: "must be a non-empty tag"});
+    }
+    if len(invalid) > 0 {
+        utils.ValidationError("Invalid tag merge", invalid, w);
+        return;
+    }
+
+    replaceTags(user, "merge", operation.Tags, operation.Into, w, r);
+}
+
+// DELETE /tag/{tag}
+func DeleteTag(w http.ResponseWriter, r *http.Request) {
+    user := utils.GetUser(r);
+    if user == nil {
+        utils.Unauthorized(w);
+        return;
+    }
+
+    replaceTags(user, "delete", []string{mux.Vars(r)["tag"]}, "", w, r);
+}
+
+// replaceTags applies a tag operation to all of the user's posts, or only counts the affected posts with ?preview=true
+func replaceTags(user *types.User, operation string, tags []string, into string, w http.ResponseWriter, r *http.Request) {
+    preview := r.URL.Query().Get("preview") == "true";
+
+    ids, err := database.ReplaceTags(user, tags, into, preview);
+    if err != nil {
+        utils.LogError("Error updating tags", err, http.StatusInternalServerError, w);
+        return;
+    }
+
+    if !preview {
+        for _, id := range ids {
+            notifyPostChangeByID(user, id);
+        }
+    }
+
+    utils.ResponseJSON(types.TagOperationResult{
+        Operation:     operation,
+        Tags:          tags,
+        Into:          into,
+        AffectedPosts: len(ids),
+        PostIDs:       ids,
+        Preview:       preview,
+    }, w);
+}
+
 type TagParams struct {
 	id  int
 	tag string
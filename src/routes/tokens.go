@@ -5,15 +5,25 @@ import (
 	"blog-server/database"
 	"blog-server/types"
 	"blog-server/utils"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/gorilla/mux"
 )
 
+const (
+	// how long the old value of a rotated token keeps working by default
+	TOKEN_ROTATION_GRACE     = 24 * time.Hour
+	TOKEN_MAX_ROTATION_GRACE = 30 * 24 * time.Hour
+)
+
 // GET /tokens
 func GetUserTokens(w http.ResponseWriter, r *http.Request) {
 	user := utils.GetUser(r)
@@ -54,7 +64,19 @@ func CreateToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := database.CreateToken(newToken)
+	if invalid := validateToken(r, newToken); len(invalid) > 0 {
+		utils.ValidationError("Invalid token", invalid, w)
+		return
+	}
+	// tokens without scopes get the scopes of the token creating them, or every scope of the user's role
+	if newToken.Scopes == nil {
+		newToken.Scopes = roleScopes[user.Role]
+		if token := utils.GetToken(r); token != nil {
+			newToken.Scopes = token.Scopes
+		}
+	}
+
+	id, value, err := database.CreateToken(newToken)
 
 	if err != nil {
 		utils.LogError("Error creating new token", err, http.StatusInternalServerError, w)
@@ -66,6 +88,7 @@ func CreateToken(w http.ResponseWriter, r *http.Request) {
 		utils.LogError("Error fetching created token", err, http.StatusInternalServerError, w)
 		return
 	}
+	fetchedToken.Value = value
 
 	utils.ResponseJSON(fetchedToken, w)
 }
@@ -77,12 +100,22 @@ func EditToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var updateToken types.AccessKey
-	err := json.NewDecoder(r.Body).Decode(&updateToken)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		utils.LogError("Error reading body", err, http.StatusBadRequest, w)
+		return
+	}
+	var updateToken types.AccessKey
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &updateToken); err != nil {
+		utils.LogError("Error decoding new post", err, http.StatusBadRequest, w)
+		return
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
 		utils.LogError("Error decoding new post", err, http.StatusBadRequest, w)
 		return
 	}
+	_, updateToken.ExpiresAtSet = fields["expires_at"]
 
 	// verify that the user_id of the token is the logged in user
 	if updateToken.UserID != user.ID {
@@ -90,6 +123,29 @@ func EditToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, err := database.GetToken(updateToken.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && token.UserID != user.ID) {
+		utils.LogError("Token not found", errors.New("Edited token doesn't exist or isn't the user's"), http.StatusNotFound, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error fetching token", err, http.StatusInternalServerError, w)
+		return
+	}
+	// like rotating, a token can't edit one with scopes it lacks
+	if caller := utils.GetToken(r); caller != nil {
+		if scope := MissingScope(caller, token.Scopes); scope != "" {
+			utils.LogError("Token is missing the "+scope+" scope", errors.New("Edited token has scopes the request's token doesn't"), http.StatusForbidden, w)
+			return
+		}
+	}
+
+	// scopes & expiry are left as they are when they're not sent
+	if invalid := validateToken(r, updateToken); len(invalid) > 0 {
+		utils.ValidationError("Invalid token", invalid, w)
+		return
+	}
+
 	log.Info("Updating token", "token", updateToken)
 
 	err = database.UpdateToken(updateToken)
@@ -101,28 +157,93 @@ func EditToken(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func DeleteToken(w http.ResponseWriter, r *http.Request) {
+// validateToken checks the scopes & expiry of a new or edited token
+func validateToken(r *http.Request, token types.AccessKey) []types.FieldError {
+	invalid := validateScopes(r, token.Scopes)
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		invalid = append(invalid, types.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	return invalid
+}
+
+// POST /token/rotate/{id}
+func RotateToken(w http.ResponseWriter, r *http.Request) {
 	user := utils.GetUser(r)
 	if user == nil {
 		utils.Unauthorized(w)
 		return
 	}
 
-	tokenIDStr := mux.Vars(r)["id"]
-	tokenID, err := strconv.Atoi(tokenIDStr)
+	tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		utils.LogError("Error parsing token ID", err, http.StatusBadRequest, w)
 		return
 	}
 
+	grace := TOKEN_ROTATION_GRACE
+	if value := r.URL.Query().Get("grace"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > TOKEN_MAX_ROTATION_GRACE {
+			utils.ValidationError("Invalid grace period", []types.FieldError{{Field: "grace", Message: fmt.Sprintf("must be between 0 and %d seconds", int(TOKEN_MAX_ROTATION_GRACE.Seconds()))}}, w)
+			return
+		}
+		grace = time.Duration(seconds) * time.Second
+	}
+
 	token, err := database.GetToken(tokenID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && token.UserID != user.ID) {
+		utils.LogError("Token not found", errors.New("Rotate token doesn't exist or isn't the user's"), http.StatusNotFound, w)
+		return
+	}
 	if err != nil {
 		utils.LogError("Error fetching token", err, http.StatusInternalServerError, w)
 		return
 	}
+	// the new value has the token's scopes, so a token can't rotate one with scopes it lacks
+	if caller := utils.GetToken(r); caller != nil {
+		if scope := MissingScope(caller, token.Scopes); scope != "" {
+			utils.LogError("Token is missing the "+scope+" scope", errors.New("Rotated token has scopes the request's token doesn't"), http.StatusForbidden, w)
+			return
+		}
+	}
 
-	if token.ID != tokenID {
-		utils.LogError("Invalid tokenID", errors.New("Delete post authorID doesn't match user"), http.StatusBadRequest, w)
+	value, err := database.RotateToken(token, grace)
+	if err != nil {
+		utils.LogError("Error rotating token", err, http.StatusInternalServerError, w)
+		return
+	}
+
+	rotated, err := database.GetToken(tokenID)
+	if err != nil {
+		utils.LogError("Error fetching rotated token", err, http.StatusInternalServerError, w)
+		return
+	}
+	rotated.Value = value
+
+	utils.ResponseJSON(rotated, w)
+}
+
+func DeleteToken(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUser(r)
+	if user == nil {
+		utils.Unauthorized(w)
+		return
+	}
+
+	tokenIDStr := mux.Vars(r)["id"]
+	tokenID, err := strconv.Atoi(tokenIDStr)
+	if err != nil {
+		utils.LogError("Error parsing token ID", err, http.StatusBadRequest, w)
+		return
+	}
+
+	token, err := database.GetToken(tokenID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && token.UserID != user.ID) {
+		utils.LogError("Token not found", errors.New("Deleted token doesn't exist or isn't the user's"), http.StatusNotFound, w)
+		return
+	}
+	if err != nil {
+		utils.LogError("Error fetching token", err, http.StatusInternalServerError, w)
 		return
 	}
 
@@ -175,11 +296,7 @@ func SetProjectKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type Body struct {
-		APIKey string `json:"api_key"`
-	}
-
-	var body Body
+	var body types.ProjectKey
 	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		utils.LogError("Error decoding body", err, http.StatusBadRequest, w)
@@ -0,0 +1,88 @@
+package types
+
+// these are the json-ld documents we serve & consume over activitypub, only the fields we make use of are included
+
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+type ActivityActor struct {
+	Context           interface{}        `json:"@context,omitempty"`
+	ID                string             `json:"id"`
+	Type              string             `json:"type"`
+	PreferredUsername string             `json:"preferredUsername"`
+	Name              string             `json:"name"`
+	Inbox             string             `json:"inbox"`
+	Outbox            string             `json:"outbox"`
+	Followers         string             `json:"followers"`
+	Icon              *ActivityImage     `json:"icon,omitempty"`
+	Endpoints         *ActivityEndpoints `json:"endpoints,omitempty"`
+	PublicKey         ActivityPublicKey  `json:"publicKey"`
+}
+
+type ActivityEndpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type ActivityImage struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type ActivityPublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type ActivityArticle struct {
+	Context      interface{}   `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	AttributedTo string        `json:"attributedTo,omitempty"`
+	Name         string        `json:"name,omitempty"`
+	Summary      string        `json:"summary,omitempty"`
+	Content      string        `json:"content,omitempty"`
+	MediaType    string        `json:"mediaType,omitempty"`
+	URL          string        `json:"url,omitempty"`
+	Published    string        `json:"published,omitempty"`
+	Updated      string        `json:"updated,omitempty"`
+	To           []string      `json:"to,omitempty"`
+	Cc           []string      `json:"cc,omitempty"`
+	Tag          []ActivityTag `json:"tag,omitempty"`
+}
+
+type ActivityTag struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type Activity struct {
+	Context   interface{} `json:"@context,omitempty"`
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	Published string      `json:"published,omitempty"`
+	To        []string    `json:"to,omitempty"`
+	Cc        []string    `json:"cc,omitempty"`
+}
+
+type OrderedCollection struct {
+	Context      interface{}   `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	First        string        `json:"first,omitempty"`
+	PartOf       string        `json:"partOf,omitempty"`
+	Next         string        `json:"next,omitempty"`
+	OrderedItems []interface{} `json:"orderedItems,omitempty"`
+}
@@ -3,15 +3,36 @@ package types
 import "time"
 
 type Category struct {
-	Name    string `json:"name"`
-	Parent  string `json:"parent"`
-	OwnerID int    `json:"owner_id"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Parent       string `json:"parent"`
+	OwnerID      int    `json:"owner_id"`
+	Description  string `json:"description"`
+	DisplayOrder int    `json:"display_order"`
+	Slug         string `json:"slug"`
+	CoverImage   string `json:"cover_image"`
+}
+
+// CategoryUpdate holds the fields of a category to change, nil fields are left as they are
+type CategoryUpdate struct {
+	Name         *string `json:"name"`
+	Parent       *string `json:"parent"`
+	Description  *string `json:"description"`
+	DisplayOrder *int    `json:"display_order"`
+	Slug         *string `json:"slug"`
+	CoverImage   *string `json:"cover_image"`
 }
 
 type CategoryNode struct {
-	Name     string         `json:"name"`
-	Children []CategoryNode `json:"children"`
-	OwnerID  int            `json:"owner_id"`
+	Name           string         `json:"name"`
+	Children       []CategoryNode `json:"children"`
+	OwnerID        int            `json:"owner_id"`
+	Description    string         `json:"description"`
+	DisplayOrder   int            `json:"display_order"`
+	Slug           string         `json:"slug"`
+	CoverImage     string         `json:"cover_image"`
+	PostCount      int            `json:"post_count"`
+	TotalPostCount int            `json:"total_post_count"`
 }
 
 type Post struct {
@@ -27,6 +48,7 @@ type Post struct {
 	Description string    `json:"description"`
 	ProjectID   string    `json:"project_id"`
 	PublishAt   time.Time `json:"publish_at" time_format:"sql_datetime"`
+	Version     int       `json:"version"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -39,33 +61,122 @@ type PostsResponse struct {
 	CurrentPage int    `json:"current_page"`
 }
 
-type GitHubUser struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
+// Identity is a login of a user with a provider, a user can link several
+type Identity struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	AvatarURL   string    `json:"avatar_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+	// only local accounts have a password, it's never sent
+	PasswordHash string `json:"-"`
+}
+
+// Session is a logged in browser, Current is the session making the request
+type Session struct {
+	ID           int       `json:"id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
+}
+
+// LoginLink is an emailed link that logs in once, links with a username sign a new email up
+type LoginLink struct {
+	ID       int
+	Email    string
+	Username string
+	Invite   string
+	// the password of a registration, set on the account it creates
+	PasswordHash string
+	ReturnTo     string
+	ExpiresAt    time.Time
+}
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// needed when registration is invite only
+	Invite string `json:"invite"`
+	// where the emailed link returns to once the account is created
+	ReturnTo string `json:"return_to"`
+}
+
+type PasswordLoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type LoginLinkRequest struct {
+	Email string `json:"email"`
+	// only used to sign up, when there isn't an account for the email
+	Username string `json:"username"`
+	Invite   string `json:"invite"`
+	ReturnTo string `json:"return_to"`
 }
 
 type User struct {
-	ID        int    `json:"user_id"`
-	GitHubID  int    `json:"github_id"`
+	ID int `json:"user_id"`
+	// only set for users that logged in with GitHub
+	GitHubID  *int   `json:"github_id"`
 	Username  string `json:"username"`
 	Email     string `json:"email"`
 	AvatarURL string `json:"avatar_url"`
+	// one of admin, author or reader
+	Role        string     `json:"role"`
+	SuspendedAt *time.Time `json:"suspended_at"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// UserUpdate is an admin's change to a user, fields that are left out aren't changed
+type UserUpdate struct {
+	Role      *string `json:"role"`
+	Suspended *bool   `json:"suspended"`
+}
+
+// Invite is a code that signs up one new user with its role
+type Invite struct {
+	ID        int        `json:"id"`
+	Prefix    string     `json:"prefix"`
+	Role      string     `json:"role"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	UsedBy    *int       `json:"used_by"`
+	UsedAt    *time.Time `json:"used_at"`
+	// only set in the response to creating the invite
+	Code string `json:"code,omitempty"`
+}
+
 type AccessKey struct {
 	ID        int       `json:"id"`
-	Value     string    `json:"value"`
+	Prefix    string    `json:"prefix"`
 	UserID    int       `json:"user_id"`
 	Name      string    `json:"name"`
 	Note      string    `json:"note"`
 	Enabled   bool      `json:"enabled"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// the routes the token can be used for, see routes.SCOPES
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	// after a rotation the old value works until then
+	PreviousExpiresAt *time.Time `json:"previous_expires_at"`
+	// the value is only sent when a token is created or rotated, after that only its prefix is known
+	Value string `json:"value,omitempty"`
+	// whether an edit sent expires_at, a null expiry clears it and a missing one leaves it as it is
+	ExpiresAtSet bool `json:"-"`
 }
 
 type Integration struct {
@@ -110,3 +221,190 @@ type ProjectLink struct {
 	ProjectID   string    `json:"project_id"`
 	CreatedAt   time.Time `json:"created_at"`
 }
+
+type ActorKey struct {
+	UserID     int       `json:"user_id"`
+	PublicKey  string    `json:"public_key"`
+	PrivateKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Follower struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Actor       string    `json:"actor"`
+	Inbox       string    `json:"inbox"`
+	SharedInbox string    `json:"shared_inbox"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Subscriber struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Email       string     `json:"email"`
+	Status      string     `json:"status"`
+	Token       string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// the confirmation link of a pending subscriber expires after it's sent
+	ConfirmationSentAt *time.Time `json:"-"`
+}
+
+type NewsletterSend struct {
+	ID         int                  `json:"id"`
+	PostID     int                  `json:"post_id"`
+	UserID     int                  `json:"user_id"`
+	Subject    string               `json:"subject"`
+	CreatedAt  time.Time            `json:"created_at"`
+	Deliveries []NewsletterDelivery `json:"deliveries"`
+}
+
+type NewsletterDelivery struct {
+	ID           int       `json:"id"`
+	SendID       int       `json:"send_id"`
+	SubscriberID int       `json:"subscriber_id"`
+	Email        string    `json:"email"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PostPatch holds the fields changed by a merge patch, nil fields are left unchanged
+type PostPatch struct {
+	Slug        *string
+	Title       *string
+	Description *string
+	Content     *string
+	Format      *string
+	Category    *string
+	Tags        *[]string
+	Archived    *bool
+	PublishAt   *time.Time
+	ProjectID   *string
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type BulkOperation struct {
+	IDs       []int    `json:"ids"`
+	Operation string   `json:"operation"`
+	Category  string   `json:"category"`
+	Tags      []string `json:"tags"`
+	ProjectID string   `json:"project_id"`
+}
+
+type BulkResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Tag struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Colour      string     `json:"colour"`
+	Slug        string     `json:"slug"`
+	Count       int        `json:"count"`
+	FirstUsed   *time.Time `json:"first_used"`
+	LastUsed    *time.Time `json:"last_used"`
+	Weight      *float64   `json:"weight,omitempty"`
+}
+
+// TagUpdate holds the metadata of a tag to change, nil fields are left as they are
+type TagUpdate struct {
+	Description *string `json:"description"`
+	Colour      *string `json:"colour"`
+	Slug        *string `json:"slug"`
+}
+
+type TagSuggestionRequest struct {
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Tags    []string `json:"tags"`
+	Limit   int      `json:"limit"`
+}
+
+type TagSuggestion struct {
+	Tag          string  `json:"tag"`
+	Score        float64 `json:"score"`
+	Similarity   float64 `json:"similarity"`
+	Mentions     int     `json:"mentions"`
+	CoOccurrence float64 `json:"co_occurrence"`
+}
+
+// TagOperation renames, merges or deletes tags across all of a user's posts
+type TagOperation struct {
+	Tags []string `json:"tags"`
+	Into string   `json:"into,omitempty"`
+}
+
+type TagOperationResult struct {
+	Operation     string   `json:"operation"`
+	Tags          []string `json:"tags"`
+	Into          string   `json:"into,omitempty"`
+	AffectedPosts int      `json:"affected_posts"`
+	PostIDs       []int    `json:"post_ids"`
+	Preview       bool     `json:"preview"`
+}
+
+// PostFilter narrows down the posts listed by /posts, empty fields don't filter
+type PostFilter struct {
+	Category        string
+	TagsAll         []string
+	TagsAny         []string
+	TagsNone        []string
+	Archived        *bool
+	Format          string
+	PublishedAfter  *time.Time
+	PublishedBefore *time.Time
+	ProjectID       string
+}
+
+type CategoryResponse struct {
+	Categories []Category   `json:"categories"`
+	Graph      CategoryNode `json:"graph"`
+}
+
+type TagRename struct {
+	Name string `json:"name"`
+}
+
+type IntegrationInput struct {
+	Source string `json:"source"`
+	Data   string `json:"data"`
+}
+
+type ProjectKey struct {
+	APIKey string `json:"api_key"`
+}
+
+type SubscribeRequest struct {
+	Email string `json:"email"`
+}
+
+// ContentVersion changes whenever anything a user has published or can list changes
+type ContentVersion struct {
+	UserID        int
+	Version       int
+	UpdatedAt     *time.Time
+	LastPublished *time.Time
+}
+
+type CacheKindStats struct {
+	Entries       int `json:"entries"`
+	Hits          int `json:"hits"`
+	Misses        int `json:"misses"`
+	Evictions     int `json:"evictions"`
+	Invalidations int `json:"invalidations"`
+}
+
+type CacheStats struct {
+	Entries    int                       `json:"entries"`
+	Capacity   int                       `json:"capacity"`
+	TTLSeconds int                       `json:"ttl_seconds"`
+	Kinds      map[string]CacheKindStats `json:"kinds"`
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	HTML    string
+	// extra headers, e.g. List-Unsubscribe
+	Headers map[string]string
+}
+
+// MailEnabled returns whether an SMTP relay has been configured
+func MailEnabled() bool {
+	return os.Getenv("SMTP_HOST") != ""
+}
+
+// SendMail sends an html email through the SMTP relay configured by the SMTP_* env variables
+func SendMail(mail Mail) error {
+	if !MailEnabled() {
+		return errors.New("No SMTP_HOST configured")
+	}
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	from := os.Getenv("SMTP_FROM")
+
+	// local sinks (e.g. mailpit) don't need auth
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	message, err := buildMessage(from, mail)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(host+":"+port, auth, from, []string{mail.To}, message)
+}
+
+func buildMessage(from string, mail Mail) ([]byte, error) {
+	headers := map[string]string{
+		"From":                      from,
+		"To":                        mail.To,
+		"Subject":                   mime.QEncoding.Encode("utf-8", mail.Subject),
+		"Date":                      time.Now().Format(time.RFC1123Z),
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/html; charset=UTF-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	}
+	for key, value := range mail.Headers {
+		headers[key] = value
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var message bytes.Buffer
+	for _, key := range keys {
+		// stop header injection through user provided values
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(headers[key])
+		fmt.Fprintf(&message, "%s: %s\r\n", key, value)
+	}
+	message.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&message)
+	if _, err := writer.Write([]byte(mail.HTML)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
@@ -2,24 +2,44 @@ package utils
 
 import (
 	"os"
+	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/gorilla/sessions"
 )
 
+// USER_SESSION is the cookie of a logged in browser, its session is stored in the database
+const USER_SESSION = "user-session"
+
+// DEFAULT_SESSION_TTL is how long a session lasts without being used
+const DEFAULT_SESSION_TTL = 8 * time.Hour
+
 var store *sessions.CookieStore
 
 func CreateStore() {
 	var secret = os.Getenv("COOKIE_SECRET")
 	store = sessions.NewCookieStore([]byte(secret))
 
+	ttl := DEFAULT_SESSION_TTL
+	if value := os.Getenv("SESSION_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Minute {
+			log.Error("Invalid SESSION_TTL, using the default", "value", value)
+		} else {
+			ttl = parsed
+		}
+	}
+
 	store.Options = &sessions.Options{
 		Domain: os.Getenv("COOKIE_DOMAIN"),
 		Path:   "/",
-		MaxAge: 3600 * 8, // 8 hours
 		Secure: true,
 	}
+	// also how long the signed values of cookies are accepted for
+	store.MaxAge(int(ttl.Seconds()))
 }
 
+// GetStore is the cookie store, user sessions use its codecs & options in the database's session store
 func GetStore() *sessions.CookieStore {
 	return store
 }
@@ -3,10 +3,13 @@ package utils
 import (
 	"blog-server/types"
 	"encoding/json"
+	"fmt"
 	"html"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/russross/blackfriday/v2"
@@ -17,22 +20,90 @@ func LogError(message string, err error, status int, writer http.ResponseWriter)
 	http.Error(writer, message, status)
 }
 
+// ValidationError responds with a 400 listing every invalid field of the request
+func ValidationError(message string, fields []types.FieldError, writer http.ResponseWriter) {
+	log.Warn(message, "fields", fields)
+	ResponseJSONStatus(map[string]interface{}{
+		"error":  message,
+		"fields": fields,
+	}, http.StatusBadRequest, writer)
+}
+
 func Unauthorized(writer http.ResponseWriter) {
 	http.Error(writer, "Unauthorized access", http.StatusUnauthorized)
 }
 
 func ResponseJSON(data interface{}, writer http.ResponseWriter) {
+	writeJSON(data, "application/json", http.StatusOK, writer)
+}
+
+// ResponseJSONStatus responds with JSON and a non-200 status, e.g. a 409 with the conflicting resource
+func ResponseJSONStatus(data interface{}, status int, writer http.ResponseWriter) {
+	writeJSON(data, "application/json", status, writer)
+}
+
+// ResponseContentType encodes data as JSON but responds with a custom content type, e.g. "application/activity+json"
+func ResponseContentType(data interface{}, contentType string, writer http.ResponseWriter) {
+	writeJSON(data, contentType, http.StatusOK, writer)
+}
+
+// ConfirmForm responds with a page that posts a token back to the same url once its button is pressed. Emailed links
+// show this instead of acting on the GET, which mail scanners & link previews make without the reader
+func ConfirmForm(message string, button string, token string, writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(writer, `<!DOCTYPE html><html><head><meta charset="utf-8"><meta name="robots" content="noindex"><title>%[1]s</title></head>`+
+		`<body><form method="post"><p>%[2]s</p><input type="hidden" name="token" value="%[3]s"><button type="submit">%[1]s</button></form></body></html>`,
+		html.EscapeString(button), html.EscapeString(message), html.EscapeString(token))
+}
+
+// NotModified sets the ETag & Last-Modified validators of a GET response, and responds with a 304 when the
+// request's If-None-Match or If-Modified-Since shows the client already has this version. A zero modified time is left out
+func NotModified(writer http.ResponseWriter, request *http.Request, etag string, modified time.Time) bool {
+	writer.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		writer.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	not_modified := false
+	// If-Modified-Since is ignored when If-None-Match is sent
+	if match := request.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				not_modified = true
+				break
+			}
+		}
+	} else if since := request.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		parsed, err := http.ParseTime(since)
+		not_modified = err == nil && !modified.Truncate(time.Second).After(parsed)
+	}
+
+	if not_modified {
+		writer.WriteHeader(http.StatusNotModified)
+	}
+	return not_modified
+}
+
+func writeJSON(data interface{}, contentType string, status int, writer http.ResponseWriter) {
 	encoded, err := json.Marshal(data)
 	if err != nil {
 		LogError("Error encoding to JSON", err, http.StatusInternalServerError, writer)
 		return
 	}
 
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
+	writer.Header().Set("Content-Type", contentType)
+	writer.WriteHeader(status)
 	writer.Write(encoded)
 }
 
+var nonSlugCharacters = regexp.MustCompile("[^a-z0-9]+")
+
+// Slugify converts a name into a lowercase, dash separated slug
+func Slugify(name string) string {
+	return strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
 func GetChildrenCategories(categories []types.Category, parent string) []types.Category {
 	var cats []types.Category
 
@@ -59,6 +130,27 @@ func GetUser(r *http.Request) *types.User {
 	}
 }
 
+// GetToken returns the API token the request was authenticated with, or nil for sessions
+func GetToken(r *http.Request) *types.AccessKey {
+	token, ok := r.Context().Value("token").(*types.AccessKey)
+	if ok && token != nil {
+		return token
+	}
+	return nil
+}
+
+// ClientIP returns the address of the client, the first X-Forwarded-For address when behind a proxy
+func ClientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func GetDescription(content string) string {
 	// parses content markdown to html
 	bytes := blackfriday.Run([]byte(content))
@@ -102,3 +194,8 @@ func GetLocation(source string) string {
 	}
 	return location
 }
+
+// IsPublished returns whether a post should be publicly visible
+func IsPublished(post types.Post) bool {
+	return !post.Archived && !post.PublishAt.After(time.Now())
+}
@@ -25,8 +25,14 @@ fi
 cd ${ROOT_DIR}
 mkdir -p ${COVERAGE_DIR}
 
-# Start the Go server in the background
-GOCOVERDIR=${COVERAGE_DIR} DATABASE=${DATABASE_FILE} ./${BINARY_NAME} 2> server.log &
+# Start the Go server in the background, with local accounts so the session tests can log in and an OIDC
+# provider pointing at the mock the provider tests start, logins return to the CLIENT_URL. Registration is open so the role tests can sign up readers,
+# and mail goes to the SMTP sink the tests start to confirm emails
+GOCOVERDIR=${COVERAGE_DIR} DATABASE=${DATABASE_FILE} SERVER_URL=http://localhost:${PORT} \
+    COOKIE_SECRET=test-secret AUTH_PASSWORD=true REGISTRATION=open DEFAULT_ROLE=author \
+    SMTP_HOST=localhost SMTP_PORT=2525 SMTP_FROM=blog@localhost PUBLISH_INTERVAL=1s \
+    OIDC_CLIENT=blog OIDC_SECRET=secret OIDC_ISSUER=http://localhost:8099 CLIENT_URL=http://localhost:5173 \
+    ./${BINARY_NAME} 2> server.log &
 
 # Store the process ID of the Go server
 server_pid=$!
@@ -0,0 +1,67 @@
+// a minimal SMTP server for the tests that send mail, they need the server started with SMTP_HOST=localhost SMTP_PORT=2525.
+// It keeps the decoded message of every email it's sent. Each test file starts its own & stops it once it's done
+export const mailSink = () => {
+    const messages: string[] = [];
+    const decode = (body: string) => body.replace(/=\r\n/g, "").replace(/=([0-9A-F]{2})/g, (_, hex) => String.fromCharCode(parseInt(hex, 16)));
+    const listener = Bun.listen<{ buffer: string, data: boolean, message: string }>({
+        hostname: "localhost",
+        port: 2525,
+        socket: {
+            open(socket) {
+                socket.data = { buffer: "", data: false, message: "" };
+                socket.write("220 sink\r\n");
+            },
+            data(socket, chunk) {
+                socket.data.buffer += chunk.toString();
+                let end;
+                while ((end = socket.data.buffer.indexOf("\r\n")) >= 0) {
+                    const line = socket.data.buffer.slice(0, end);
+                    socket.data.buffer = socket.data.buffer.slice(end + 2);
+                    if (socket.data.data) {
+                        if (line == ".") {
+                            messages.push(decode(socket.data.message));
+                            socket.data = { ...socket.data, data: false, message: "" };
+                            socket.write("250 ok\r\n");
+                        } else {
+                            socket.data.message += line + "\r\n";
+                        }
+                        continue;
+                    }
+                    const command = line.slice(0, 4).toUpperCase();
+                    if (command == "DATA") {
+                        socket.data.data = true;
+                        socket.write("354 go ahead\r\n");
+                    } else if (command == "QUIT") {
+                        socket.write("221 bye\r\n");
+                        socket.end();
+                    } else {
+                        socket.write(command == "EHLO" || command == "HELO" ? "250 sink\r\n" : "250 ok\r\n");
+                    }
+                }
+            },
+        },
+    });
+
+    // sentTo is the latest email to an address, e.g. after a link in it was used up
+    const sentTo = (to: string) => waitFor(async () => messages.findLast((m) => m.toLowerCase().includes(`to: ${to.toLowerCase()}`)));
+
+    return {
+        messages,
+        sentTo,
+        // token returns the token of the latest link to a path emailed to an address
+        token: async (to: string, path: string) => {
+            const message = await sentTo(to);
+            const match = message?.match(new RegExp(`${path}\\?token=([^"&]+)`));
+            return match ? decodeURIComponent(match[1]) : "";
+        },
+        stop: () => listener.stop(true),
+    };
+}
+
+export const waitFor = async <T>(check: () => Promise<T | undefined>, timeout = 10000) => {
+    for (const start = Date.now(); Date.now() - start < timeout; await Bun.sleep(250)) {
+        const result = await check();
+        if (result) return result;
+    }
+    return undefined;
+}
@@ -0,0 +1,87 @@
+import { expect, test, describe, afterAll } from "bun:test";
+import { cookie, localAccount, providers, suffix, useLoginLink } from "user";
+import { mailSink } from "mail";
+
+// the password tests need the server started with AUTH_PASSWORD=true, and mail going to the sink to confirm emails
+const password = providers.includes("password");
+const mail = mailSink();
+afterAll(() => mail.stop());
+
+// the email is registered in a different case than it's stored
+const account = { ...localAccount("local"), email: `Local-${suffix}@Example.com` };
+
+const post = (path: string, body: object) => fetch(`localhost:8080${path}`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(body),
+});
+
+describe("local accounts", () => {
+    test.skipIf(password)("disabled without AUTH_PASSWORD", async () => {
+        const response = await post("/auth/register", account);
+        expect(response.status).toBe(404);
+    });
+    test.skipIf(!password)("register", async () => {
+        const response = await post("/auth/register", account);
+        expect(response.status).toBe(202);
+        expect(cookie(response)).toBe("");
+
+        // the password doesn't work until the emailed link is used
+        const early = await post("/auth/password/login", { email: account.email, password: account.password });
+        expect(early.status).toBe(401);
+
+        const token = await mail.token(account.email, "/auth/email/callback");
+        expect(token).toBeTruthy();
+
+        // opening the link doesn't use it, the page it shows posts the token
+        const page = await fetch(`localhost:8080/auth/email/callback?token=${encodeURIComponent(token)}`, { redirect: "manual" });
+        expect(page.status).toBe(200);
+        expect(cookie(page)).toBe("");
+        expect(await page.text()).toContain('method="post"');
+
+        const confirmed = await useLoginLink(token);
+        expect(confirmed.status).toBe(303);
+        expect((await useLoginLink(token)).status).toBe(404);
+        const user = await (await fetch("localhost:8080/auth/user", { headers: { Cookie: cookie(confirmed) } })).json();
+        expect(user.username).toBe(account.username);
+        expect(user.email).toBe(account.email.toLowerCase());
+    });
+    test.skipIf(!password)("register validation", async () => {
+        const response = await post("/auth/register", { username: "f0rbit", email: "not an email", password: "short" });
+        expect(response.status).toBe(400);
+        const body = await response.json();
+        expect(body.fields.map((f: any) => f.field)).toEqual(["username", "email", "password"]);
+
+        // an email with an account gets the same response as one without
+        const taken = await post("/auth/register", { ...account, username: `other-${suffix}` });
+        expect(taken.status).toBe(202);
+        expect(await taken.text()).toBe("");
+    });
+    test.skipIf(!password)("password login", async () => {
+        const response = await post("/auth/password/login", { email: account.email.toUpperCase(), password: account.password });
+        expect(response.ok).toBeTrue();
+        expect(cookie(response)).not.toBe("");
+
+        const identities = await (await fetch("localhost:8080/auth/identities", { headers: { Cookie: cookie(response) } })).json();
+        expect(identities.map((i: any) => i.provider)).toEqual(["email"]);
+        expect(identities[0].password_hash).toBeUndefined();
+    });
+    test.skipIf(!password)("wrong password", async () => {
+        const wrong = await post("/auth/password/login", { email: account.email, password: "incorrect horse" });
+        expect(wrong.status).toBe(401);
+        const unknown = await post("/auth/password/login", { email: `nobody-${suffix}@example.com`, password: account.password });
+        expect(unknown.status).toBe(401);
+        expect(await unknown.text()).toBe(await wrong.text());
+    });
+    test.skipIf(!password)("failed logins are throttled", async () => {
+        const email = `throttled-${suffix}@example.com`;
+        for (let i = 0; i < 5; i++) {
+            expect((await post("/auth/password/login", { email, password: "incorrect horse" })).status).toBe(401);
+        }
+        expect((await post("/auth/password/login", { email, password: "incorrect horse" })).status).toBe(429);
+    });
+    test("invalid login link", async () => {
+        const response = await useLoginLink("rubbish");
+        expect(response.status).toBe(404);
+    });
+});
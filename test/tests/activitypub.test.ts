@@ -0,0 +1,43 @@
+import { describe, test, expect } from "bun:test";
+
+const ACTOR_URL = "http://localhost:8080/ap/users/f0rbit";
+
+describe("activitypub", () => {
+    test("webfinger", async () => {
+        const response = await fetch("localhost:8080/.well-known/webfinger?resource=acct:f0rbit@localhost:8080", { method: "GET" });
+        expect(response).toBeTruthy();
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.links.find((l: any) => l.rel == "self").href).toBe(ACTOR_URL);
+    });
+    test("webfinger unknown user", async () => {
+        const response = await fetch("localhost:8080/.well-known/webfinger?resource=acct:nobody@localhost:8080", { method: "GET" });
+        expect(response).toBeTruthy();
+        expect(response.status).toBe(404);
+    });
+    test("actor", async () => {
+        const response = await fetch("localhost:8080/ap/users/f0rbit", { method: "GET" });
+        expect(response).toBeTruthy();
+        expect(response.ok).toBeTrue();
+        expect(response.headers.get("Content-Type")).toBe("application/activity+json");
+        const result = await response.json();
+        expect(result.id).toBe(ACTOR_URL);
+        expect(result.inbox).toBe(`${ACTOR_URL}/inbox`);
+        expect(result.publicKey.publicKeyPem).toContain("PUBLIC KEY");
+    });
+    test("outbox", async () => {
+        const response = await fetch("localhost:8080/ap/users/f0rbit/outbox?page=1", { method: "GET" });
+        expect(response).toBeTruthy();
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.totalItems).toBeGreaterThan(0);
+        expect(result.orderedItems[0].type).toBe("Create");
+        expect(result.orderedItems[0].object.type).toBe("Article");
+    });
+    test("unsigned inbox request", async () => {
+        const follow = { type: "Follow", actor: "https://example.com/users/someone", object: ACTOR_URL };
+        const response = await fetch("localhost:8080/ap/users/f0rbit/inbox", { method: "POST", body: JSON.stringify(follow) });
+        expect(response).toBeTruthy();
+        expect(response.status).toBe(401);
+    });
+});
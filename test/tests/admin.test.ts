@@ -0,0 +1,115 @@
+import { expect, test, describe, afterAll } from "bun:test";
+import { AUTH_HEADERS, cookie, localAccount, providers, register } from "user";
+import { mailSink } from "mail";
+
+// the seeded user is the first user, so it's an admin. The reader tests register with an invite, so they need the
+// server started with AUTH_PASSWORD=true and REGISTRATION open or invite
+const { mode } = await (await fetch("localhost:8080/auth/registration")).json();
+const readers = providers.includes("password") && mode != "closed";
+
+const account = localAccount("reader");
+const mail = mailSink();
+afterAll(() => mail.stop());
+
+const admin = (path: string, method = "GET", body?: object) => fetch(`localhost:8080${path}`, {
+    method,
+    headers: { ...AUTH_HEADERS, "Content-Type": "application/json" },
+    body: body ? JSON.stringify(body) : undefined,
+});
+
+const createInvite = async (role: string) => {
+    const response = await admin("/admin/invites", "POST", { role });
+    expect(response.ok).toBeTrue();
+    return response.json();
+}
+
+describe("administration", () => {
+    test("registration mode", async () => {
+        expect(["open", "invite", "closed"]).toContain(mode);
+    });
+    test("seeded user is an admin", async () => {
+        const response = await fetch("localhost:8080/auth/user", { headers: AUTH_HEADERS });
+        const user = await response.json();
+        expect(user.role).toBe("admin");
+    });
+    test("list users", async () => {
+        const response = await admin("/admin/users");
+        expect(response.ok).toBeTrue();
+        const users = await response.json();
+        expect(users.find((u: any) => u.user_id == 1).role).toBe("admin");
+    });
+    test("last admin can't be demoted", async () => {
+        const response = await admin("/admin/users/1", "PATCH", { role: "author" });
+        expect(response.status).toBe(409);
+    });
+    test("last admin can't be deleted", async () => {
+        const response = await admin("/admin/users/1", "DELETE");
+        expect(response.status).toBe(409);
+    });
+    test("unknown role", async () => {
+        const response = await admin("/admin/users/1", "PATCH", { role: "owner" });
+        expect(response.status).toBe(400);
+    });
+    test("unknown user", async () => {
+        const response = await admin("/admin/users/999999", "PATCH", { suspended: true });
+        expect(response.status).toBe(404);
+    });
+    test("create, list & delete invite", async () => {
+        const invite = await createInvite("author");
+        expect(invite.code).toStartWith(invite.prefix);
+        expect(invite.role).toBe("author");
+
+        const list = await (await admin("/admin/invites")).json();
+        const listed = list.find((i: any) => i.id == invite.id);
+        expect(listed.code).toBeUndefined();
+        expect(listed.used_by).toBeNull();
+
+        expect((await admin(`/admin/invites/${invite.id}`, "DELETE")).ok).toBeTrue();
+        expect((await admin(`/admin/invites/${invite.id}`, "DELETE")).status).toBe(404);
+    });
+    test("invite must expire in the future", async () => {
+        const response = await admin("/admin/invites", "POST", { role: "reader", expires_at: "2020-01-01T00:00:00Z" });
+        expect(response.status).toBe(400);
+    });
+    test.skipIf(!providers.includes("password"))("invalid invite", async () => {
+        const response = await fetch("localhost:8080/auth/register", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ ...account, invite: "not-a-code" }),
+        });
+        expect(response.status).toBe(403);
+    });
+    test.skipIf(!readers)("reader", async () => {
+        const invite = await createInvite("reader");
+        const response = await register(mail, account, invite.code);
+        expect(response.status).toBe(303);
+        const session = cookie(response);
+        const user = await (await fetch("localhost:8080/auth/user", { headers: { Cookie: session } })).json();
+        expect(user.role).toBe("reader");
+
+        // readers can read but not write, or manage the instance
+        expect((await fetch("localhost:8080/posts", { headers: { Cookie: session } })).ok).toBeTrue();
+        const write = await fetch("localhost:8080/post/new", { method: "POST", headers: { Cookie: session, "Content-Type": "application/json" }, body: "{}" });
+        expect(write.status).toBe(403);
+        expect((await fetch("localhost:8080/admin/users", { headers: { Cookie: session } })).status).toBe(403);
+        expect((await fetch("localhost:8080/cache/stats", { headers: { Cookie: session } })).status).toBe(403);
+
+        // the invite is used up
+        const used = (await (await admin("/admin/invites")).json()).find((i: any) => i.id == invite.id);
+        expect(used.used_by).toBe(user.user_id);
+
+        // suspending logs the reader out & stops them logging in
+        const suspended = await admin(`/admin/users/${user.user_id}`, "PATCH", { suspended: true });
+        expect((await suspended.json()).suspended_at).not.toBeNull();
+        expect((await fetch("localhost:8080/auth/user", { headers: { Cookie: session } })).status).toBe(401);
+        const login = await fetch("localhost:8080/auth/password/login", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ email: account.email, password: account.password }),
+        });
+        expect(login.status).toBe(403);
+
+        expect((await admin(`/admin/users/${user.user_id}`, "DELETE")).ok).toBeTrue();
+        expect((await admin(`/admin/users/${user.user_id}`, "DELETE")).status).toBe(404);
+    });
+});
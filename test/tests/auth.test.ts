@@ -27,10 +27,12 @@ describe("auth tests", () => {
             ["PUT", "/post/tag"],
             ["DELETE", "/post/tag"],
             ["GET", "/tags"], 
+            ["GET", "/cache/stats"],
             ["GET", "/tokens"],
             ["POST", "/token/new"],
             ["PUT", "/token/edit"],
-            ["DELETE", "/token/delete/1"]
+            ["DELETE", "/token/delete/1"],
+            ["POST", "/graphql"]
         ];
         for (const [method, path] of unauth_routes) {
             const response = await fetch(`localhost:8080${path}`, { method });
@@ -41,3 +43,33 @@ describe("auth tests", () => {
         }
     })
 });
+
+describe("oauth login", () => {
+    test("state & pkce", async () => {
+        const response = await fetch(`localhost:8080/auth/github/login`, { redirect: "manual" });
+        expect(response.status).toBe(307);
+        const location = new URL(response.headers.get("Location") as string);
+        expect(location.searchParams.get("state")).not.toBe("state");
+        expect(location.searchParams.get("state")?.length).toBeGreaterThan(20);
+        expect(location.searchParams.get("code_challenge_method")).toBe("S256");
+        expect(response.headers.get("Set-Cookie")).toContain("oauth-state=");
+    });
+    test("state is per request", async () => {
+        const first = await fetch(`localhost:8080/auth/github/login`, { redirect: "manual" });
+        const second = await fetch(`localhost:8080/auth/github/login`, { redirect: "manual" });
+        const state = (response: Response) => new URL(response.headers.get("Location") as string).searchParams.get("state");
+        expect(state(first)).not.toBe(state(second));
+    });
+    test("return url allowlist", async () => {
+        const relative = await fetch(`localhost:8080/auth/github/login?return_to=/posts`, { redirect: "manual" });
+        expect(relative.status).toBe(307);
+        for (const url of ["https://example.com/steal", "//example.com/steal"]) {
+            const response = await fetch(`localhost:8080/auth/github/login?return_to=${encodeURIComponent(url)}`, { redirect: "manual" });
+            expect(response.status).toBe(400);
+        }
+    });
+    test("callback without state", async () => {
+        const response = await fetch(`localhost:8080/auth/github/callback?code=abc&state=state`, { redirect: "manual" });
+        expect(response.status).toBe(400);
+    });
+});
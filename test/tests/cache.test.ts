@@ -0,0 +1,88 @@
+import { expect, test, describe } from "bun:test";
+import { AUTH_HEADERS } from "user";
+
+const get = (path: string, headers: Record<string, string> = {}) => fetch(`localhost:8080${path}`, { method: "GET", headers: { ...AUTH_HEADERS, ...headers } });
+
+describe("http caching", () => {
+    for (const path of ["/posts", "/categories", "/tags", "/post/test-post"]) {
+        test(`${path} if-none-match`, async () => {
+            const response = await get(path);
+            expect(response.ok).toBeTrue();
+            const etag = response.headers.get("ETag");
+            expect(etag).toBeTruthy();
+            expect(response.headers.get("Last-Modified")).toBeTruthy();
+            expect(response.headers.get("Cache-Control")).toBe("private, no-cache");
+
+            const cached = await get(path, { "If-None-Match": etag as string });
+            expect(cached.status).toBe(304);
+            expect(await cached.text()).toBe("");
+        })
+    }
+    test("if-modified-since", async () => {
+        const response = await get("/categories");
+        const modified = response.headers.get("Last-Modified") as string;
+        const cached = await get("/categories", { "If-Modified-Since": modified });
+        expect(cached.status).toBe(304);
+        const stale = await get("/categories", { "If-Modified-Since": "Thu, 01 Jan 2015 00:00:00 GMT" });
+        expect(stale.status).toBe(200);
+    })
+    test("different query", async () => {
+        const response = await get("/posts?limit=5");
+        const other = await get("/posts?limit=6");
+        expect(response.headers.get("ETag")).not.toBe(other.headers.get("ETag"));
+    })
+    test("changes invalidate", async () => {
+        const response = await get("/tags");
+        const etag = response.headers.get("ETag") as string;
+
+        const add = await fetch("localhost:8080/post/tag?id=1&tag=cache-test", { method: "PUT", headers: AUTH_HEADERS });
+        expect(add.ok).toBeTrue();
+        const changed = await get("/tags", { "If-None-Match": etag });
+        expect(changed.status).toBe(200);
+        expect((await changed.json()).map((t: any) => t.name)).toContain("cache-test");
+
+        const remove = await fetch("localhost:8080/post/tag?id=1&tag=cache-test", { method: "DELETE", headers: AUTH_HEADERS });
+        expect(remove.ok).toBeTrue();
+    })
+    test("public feeds", async () => {
+        const response = await fetch("localhost:8080/ap/users/f0rbit/outbox");
+        expect(response.ok).toBeTrue();
+        expect(response.headers.get("Cache-Control")).toStartWith("public");
+        const cached = await fetch("localhost:8080/ap/users/f0rbit/outbox", { headers: { "If-None-Match": response.headers.get("ETag") as string } });
+        expect(cached.status).toBe(304);
+    })
+});
+
+describe("read cache", () => {
+    const stats = async () => (await get("/cache/stats")).json();
+
+    test("stats", async () => {
+        const response = await get("/cache/stats");
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.capacity).toBeGreaterThan(0);
+        expect(result.ttl_seconds).toBeGreaterThan(0);
+        for (const kind of ["posts", "post", "categories", "tags"]) {
+            expect(result.kinds[kind]).toBeTruthy();
+        }
+    })
+    test("repeated reads hit", async () => {
+        await get("/posts?limit=3");
+        const before = await stats();
+        await get("/posts?limit=3");
+        const after = await stats();
+        expect(after.kinds.posts.hits).toBe(before.kinds.posts.hits + 1);
+    })
+    test("writes invalidate", async () => {
+        await get("/categories");
+        const before = await stats();
+        const create = await fetch("localhost:8080/category/new", { method: "POST", body: JSON.stringify({ name: "cache-category", parent: "root", owner_id: 1 }), headers: AUTH_HEADERS });
+        expect(create.ok).toBeTrue();
+        const after = await stats();
+        expect(after.kinds.categories.invalidations).toBeGreaterThan(before.kinds.categories.invalidations);
+
+        const categories = await (await get("/categories")).json();
+        expect(JSON.stringify(categories)).toContain("cache-category");
+        await fetch("localhost:8080/category/delete/cache-category", { method: "DELETE", headers: AUTH_HEADERS });
+    })
+});
@@ -14,6 +14,18 @@ test("get categories", async () => {
     expect(result.categories.find((cat) => cat.name == "webdev" && cat.parent == "devlog")).toBeTruthy();
 });
 
+test("category post counts", async () => {
+    const response = await fetch("localhost:8080/categories", { method: "GET", headers: AUTH_HEADERS });
+    const result = SCHEMA.CATEGORY_RESPONSE.parse(await response.json()) as CategoryResponse;
+    const coding = result.graph.children.find((c) => c.name == "coding");
+    expect(coding).toBeTruthy();
+    expect(coding!.post_count).toBeGreaterThan(0);
+    // the recursive count includes the posts of all children
+    const children_total = coding!.children.reduce((total: number, c: any) => total + c.total_post_count, 0);
+    expect(coding!.total_post_count).toBe(coding!.post_count! + children_total);
+    expect(result.graph.total_post_count).toBeGreaterThanOrEqual(coding!.total_post_count!);
+});
+
 
 const test_category = {
     name: "Test",
@@ -31,9 +43,87 @@ describe("categories", () => {
         expect(categories.categories.map((c) => c.name)).toContain("Test");
         expect(categories.graph.children.find((c) => c.children.map((r) => r.name).includes("Test"))).toBeTruthy();
     })
+    test("create duplicate", async () => {
+        const response = await fetch(`localhost:8080/category/new`, { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify(test_category) });
+        expect(response.status).toBe(409);
+    })
+    test("create with unknown parent", async () => {
+        const response = await fetch(`localhost:8080/category/new`, { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_category, name: "Orphan", parent: "not-a-category" }) });
+        expect(response.status).toBe(400);
+    })
+    test("rename", async () => {
+        const response = await fetch(`localhost:8080/category/${test_category.name}`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ name: "Renamed" }) });
+        expect(response.ok).toBeTrue();
+        const categories = SCHEMA.CATEGORY_RESPONSE.parse(await response.json()) as CategoryResponse;
+        expect(categories.categories.map((c) => c.name)).toContain("Renamed");
+        expect(categories.categories.map((c) => c.name)).not.toContain("Test");
+        test_category.name = "Renamed";
+    })
+    test("move", async () => {
+        const response = await fetch(`localhost:8080/category/${test_category.name}`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ parent: "devlog" }) });
+        expect(response.ok).toBeTrue();
+        const categories = SCHEMA.CATEGORY_RESPONSE.parse(await response.json()) as CategoryResponse;
+        expect(categories.categories.find((c) => c.name == test_category.name && c.parent == "devlog")).toBeTruthy();
+    })
+    test("metadata", async () => {
+        const response = await fetch(`localhost:8080/category/${test_category.name}`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ description: "A test category", display_order: -1, slug: "test-slug", cover_image: "https://example.com/cover.png" }) });
+        expect(response.ok).toBeTrue();
+        const categories = SCHEMA.CATEGORY_RESPONSE.parse(await response.json()) as CategoryResponse;
+        const updated = categories.categories.find((c) => c.name == test_category.name);
+        expect(updated?.description).toBe("A test category");
+        expect(updated?.slug).toBe("test-slug");
+        expect(updated?.cover_image).toBe("https://example.com/cover.png");
+        // a lower display order sorts the category first amongst its siblings
+        const devlog = categories.graph.children.find((c) => c.name == "coding")?.children.find((c: any) => c.name == "devlog");
+        expect(devlog.children[0].name).toBe(test_category.name);
+    })
+    test("invalid slug", async () => {
+        const response = await fetch(`localhost:8080/category/${test_category.name}`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ slug: "Not A Slug" }) });
+        expect(response.status).toBe(400);
+    })
+    test("duplicate slug", async () => {
+        const response = await fetch(`localhost:8080/category/${test_category.name}`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ slug: "coding" }) });
+        expect(response.status).toBe(409);
+    })
+    test("move under descendant", async () => {
+        const response = await fetch(`localhost:8080/category/devlog`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ parent: test_category.name }) });
+        expect(response.status).toBe(400);
+    })
+    test("rename to existing", async () => {
+        const response = await fetch(`localhost:8080/category/${test_category.name}`, { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ name: "coding" }) });
+        expect(response.status).toBe(409);
+    })
     test("delete", async () => {
         const response = await fetch(`localhost:8080/category/delete/${test_category.name}`, { method: "DELETE", headers: AUTH_HEADERS });
         expect(response).toBeTruthy();
         expect(response.ok).toBeTrue();
     })
 });
+
+test("renaming and deleting a category changes its posts", async () => {
+    const headers = { ...AUTH_HEADERS, "Content-Type": "application/json" };
+    const category = (name: string) => fetch("localhost:8080/category/new", { method: "POST", headers, body: JSON.stringify({ name, parent: "coding", owner_id: 1 }) });
+    expect((await category("Versioned")).ok).toBeTrue();
+    const post = { author_id: 1, slug: "bun-versioned-category", title: "Versioned Category", content: "this post should be removed after tests completed.", category: "Versioned" };
+    const created = await (await fetch("localhost:8080/post/new", { method: "POST", headers, body: JSON.stringify(post) })).json();
+    const etag = (await fetch(`localhost:8080/post/${post.slug}`, { headers })).headers.get("ETag") as string;
+
+    const renamed = await fetch("localhost:8080/category/Versioned", { method: "PUT", headers, body: JSON.stringify({ name: "Versioned Renamed" }) });
+    expect(renamed.ok).toBeTrue();
+    const changed = await fetch(`localhost:8080/post/${post.slug}`, { headers: { ...headers, "If-None-Match": etag } });
+    expect(changed.status).toBe(200);
+    const current = await changed.json();
+    expect(current.category).toBe("Versioned Renamed");
+    expect(current.version).toBe(created.version + 1);
+
+    // the copy from before the rename is stale
+    const stale = await fetch("localhost:8080/post/edit", { method: "PUT", headers: { ...headers, "If-Match": etag }, body: JSON.stringify(created) });
+    expect(stale.status).toBe(409);
+
+    expect((await fetch("localhost:8080/category/delete/Versioned Renamed", { method: "DELETE", headers })).ok).toBeTrue();
+    const removed = await (await fetch(`localhost:8080/post/${post.slug}`, { headers })).json();
+    expect(removed.category).toBe("root");
+    expect(removed.version).toBe(current.version + 1);
+
+    expect((await fetch(`localhost:8080/post/delete/${created.id}`, { method: "DELETE", headers })).ok).toBeTrue();
+});
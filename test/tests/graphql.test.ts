@@ -0,0 +1,67 @@
+import { expect, test, describe } from "bun:test";
+import { AUTH_HEADERS } from "user";
+
+const query = async (query: string, variables: Record<string, any> = {}) => {
+    const response = await fetch("localhost:8080/graphql", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ query, variables }) });
+    return { status: response.status, result: await response.json() };
+}
+
+describe("graphql", () => {
+    test("single request for a page", async () => {
+        const { status, result } = await query(`{
+            me { username }
+            posts(limit: 5) { total_posts posts { slug tags category_details { name } tag_details { name count } } }
+            categories { name parent }
+            category_tree { name total_post_count children { name post_count } }
+            tags { name count }
+            projects { id name }
+            tokens { id name }
+        }`);
+        expect(status).toBe(200);
+        expect(result.errors).toBeUndefined();
+        expect(result.data.me.username).toBe("f0rbit");
+        expect(result.data.posts.total_posts).toBeGreaterThan(0);
+        expect(result.data.categories.find((c: any) => c.name == "coding")).toBeTruthy();
+        expect(result.data.category_tree.name).toBe("root");
+        // tokens never include their value
+        expect(result.data.tokens[0].value).toBeUndefined();
+    })
+    test("post by slug", async () => {
+        const { result } = await query(`query Post($slug: String) { post(slug: $slug) { slug title } }`, { slug: "test-post" });
+        expect(result.data.post.slug).toBe("test-post");
+    })
+    test("missing post", async () => {
+        const { result } = await query(`{ post(slug: "not-a-post") { id } }`);
+        expect(result.data.post).toBeNull();
+    })
+    test("depth limit", async () => {
+        const { status, result } = await query(`{ category_tree { children { children { children { children { children { children { children { children { children { children { name } } } } } } } } } } } }`);
+        expect(status).toBe(400);
+        expect(result.errors[0].message).toContain("depth");
+    })
+    test("complexity limit", async () => {
+        const { status, result } = await query(`{
+            a: posts(limit: -1) { posts { title content tag_details { name } category_details { name } project { name } } }
+            b: posts(limit: -1) { posts { title content tag_details { name } category_details { name } project { name } } }
+        }`);
+        expect(status).toBe(400);
+        expect(result.errors[0].message).toContain("complexity");
+    })
+    test("invalid limit", async () => {
+        for (const limit of [0, -2]) {
+            const { result } = await query(`{ posts(limit: ${limit}) { total_posts } }`);
+            expect(result.errors[0].message).toContain("limit");
+        }
+    })
+    test("every post", async () => {
+        const { result } = await query(`{ posts(limit: -1) { total_posts total_pages posts { id } } }`);
+        expect(result.errors).toBeUndefined();
+        expect(result.data.posts.total_pages).toBe(1);
+        expect(result.data.posts.posts.length).toBe(result.data.posts.total_posts);
+    })
+    test("invalid query", async () => {
+        const { status, result } = await query(`{ not_a_field }`);
+        expect(status).toBe(400);
+        expect(result.errors.length).toBeGreaterThan(0);
+    })
+});
@@ -0,0 +1,102 @@
+import { describe, test, expect, afterAll } from "bun:test";
+import { AUTH_HEADERS } from "user";
+import { mailSink, waitFor } from "mail";
+
+// the delivery test needs the server started with mail going to the sink, and a short publish interval: PUBLISH_INTERVAL=1s
+const headers = { ...AUTH_HEADERS, "Content-Type": "application/json" };
+
+const mail = mailSink();
+afterAll(() => mail.stop());
+
+const { username } = await (await fetch("localhost:8080/auth/user", { headers: AUTH_HEADERS })).json();
+const email = `subscriber-${Date.now().toString(36)}@example.com`;
+const subscribe = await fetch(`localhost:8080/newsletter/subscribe/${username}`, { method: "POST", headers, body: JSON.stringify({ email }) });
+const enabled = subscribe.status != 503;
+
+describe("newsletter", () => {
+    test("get subscribers", async () => {
+        const response = await fetch("localhost:8080/newsletter/subscribers", { method: "GET", headers: AUTH_HEADERS });
+        expect(response).toBeTruthy();
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(Array.isArray(result)).toBeTrue();
+    });
+    test("get sends", async () => {
+        const response = await fetch("localhost:8080/newsletter/sends", { method: "GET", headers: AUTH_HEADERS });
+        expect(response).toBeTruthy();
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(Array.isArray(result)).toBeTrue();
+    });
+    test("invalid confirm token", async () => {
+        const response = await fetch("localhost:8080/newsletter/confirm?token=invalid", { method: "GET" });
+        expect(response).toBeTruthy();
+        expect(response.status).toBe(404);
+    });
+    test("unauthorized subscribers", async () => {
+        const response = await fetch("localhost:8080/newsletter/subscribers", { method: "GET" });
+        expect(response).toBeTruthy();
+        expect(response.status).toBe(401);
+    });
+    test.skipIf(!enabled)("scheduled post is sent once it's published", async () => {
+        expect(subscribe.ok).toBeTrue();
+        const token = await mail.token(email, "/newsletter/confirm");
+        expect(token).toBeTruthy();
+        expect((await fetch(`localhost:8080/newsletter/confirm?token=${encodeURIComponent(token)}`)).ok).toBeTrue();
+
+        const slug = `scheduled-${Date.now().toString(36)}`;
+        const publish_at = new Date(Date.now() + 2000).toISOString();
+        const created = await fetch("localhost:8080/post/new", { method: "POST", headers, body: JSON.stringify({ slug, title: slug, content: "Scheduled Content", category: "root", author_id: 1, publish_at }) });
+        expect(created.ok).toBeTrue();
+        const post = await created.json();
+
+        // nothing is sent until the post goes live
+        const sends = async () => (await (await fetch("localhost:8080/newsletter/sends", { headers: AUTH_HEADERS })).json()).find((s: any) => s.post_id == post.id);
+        expect(await sends()).toBeUndefined();
+
+        const send = await waitFor(async () => {
+            const send = await sends();
+            return send?.deliveries.find((d: any) => d.email == email && d.status != "pending") ? send : undefined;
+        });
+        expect(send.subject).toBe(slug);
+        const delivery = send.deliveries.find((d: any) => d.email == email);
+        expect(delivery.status).toBe("sent");
+        expect(delivery.error).toBe("");
+        expect(mail.messages.some((m) => m.includes(`To: ${email}`) && m.includes("Scheduled Content"))).toBeTrue();
+
+        // the post is only sent once
+        await Bun.sleep(2000);
+        expect(mail.messages.filter((m) => m.includes(`To: ${email}`) && m.includes("Scheduled Content")).length).toBe(1);
+
+        expect((await fetch(`localhost:8080/post/delete/${post.id}`, { method: "DELETE", headers: AUTH_HEADERS })).ok).toBeTrue();
+    });
+    test.skipIf(!enabled)("unsubscribe", async () => {
+        const status = async () => (await (await fetch("localhost:8080/newsletter/subscribers", { headers: AUTH_HEADERS })).json()).find((s: any) => s.email == email)?.status;
+        const token = await mail.token(email, "/newsletter/unsubscribe");
+        expect(token).toBeTruthy();
+
+        // opening the link only shows a page that posts the token
+        const page = await fetch(`localhost:8080/newsletter/unsubscribe?token=${encodeURIComponent(token)}`);
+        expect(page.ok).toBeTrue();
+        expect(await page.text()).toContain('method="post"');
+        expect(await status()).toBe("confirmed");
+
+        // one-click unsubscribing posts to the link
+        const response = await fetch(`localhost:8080/newsletter/unsubscribe?token=${encodeURIComponent(token)}`, {
+            method: "POST",
+            headers: { "Content-Type": "application/x-www-form-urlencoded" },
+            body: "List-Unsubscribe=One-Click",
+        });
+        expect(response.ok).toBeTrue();
+        expect(await status()).toBe("unsubscribed");
+    });
+    test.skipIf(!enabled)("confirmation emails are throttled", async () => {
+        const throttled = `throttled-${Date.now().toString(36)}@example.com`;
+        const again = () => fetch(`localhost:8080/newsletter/subscribe/${username}`, { method: "POST", headers, body: JSON.stringify({ email: throttled }) });
+        expect((await again()).status).toBe(202);
+        expect(await mail.sentTo(throttled)).toBeTruthy();
+        expect((await again()).status).toBe(202);
+        await Bun.sleep(500);
+        expect(mail.messages.filter((m) => m.includes(`To: ${throttled}`)).length).toBe(1);
+    });
+});
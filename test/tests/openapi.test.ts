@@ -0,0 +1,35 @@
+import { expect, test, describe } from "bun:test";
+import { AUTH_HEADERS } from "user";
+
+describe("openapi", () => {
+    test("document", async () => {
+        // public, so clients can generate code without a token
+        const response = await fetch("localhost:8080/openapi.json");
+        expect(response.ok).toBeTrue();
+        const document = await response.json();
+        expect(document.openapi).toStartWith("3.");
+        for (const path of ["/posts", "/post/new", "/projects", "/project/key", "/project/posts/{project_id}"]) {
+            expect(document.paths[path]).toBeTruthy();
+        }
+        expect(document.paths["/post/new"].post.requestBody.content["application/json"].schema).toBeTruthy();
+        expect(document.components.schemas.Post.properties.tags.type).toBe("array");
+        expect(document.paths["/openapi.json"].get.security).toEqual([]);
+    })
+    test("invalid post", async () => {
+        const response = await fetch("localhost:8080/post/new", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ author_id: "1", slug: "openapi-post", tags: ["a", 3] }) });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        const fields = result.fields.map((f: any) => f.field);
+        expect(fields).toContain("author_id");
+        expect(fields).toContain("tags[1]");
+        expect(fields).toContain("title");
+        expect(fields).toContain("category");
+    })
+    test("invalid token", async () => {
+        const response = await fetch("localhost:8080/token/new", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ user_id: 1, name: "", enabled: "yes" }) });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields).toContainEqual({ field: "enabled", message: "must be a boolean" });
+        expect(result.fields).toContainEqual({ field: "name", message: "must not be empty" });
+    })
+});
@@ -54,6 +54,10 @@ describe("posts", () => {
             expect(response.ok).toBeFalse();
             expect(response.status).toBeGreaterThanOrEqual(500);
         })
+        test("unknown category", async () => {
+            const response = await fetch("localhost:8080/post/new", { method: "POST", body: JSON.stringify({ ...test_post, slug: "bun-unknown-category", category: "not-a-category" }), headers })
+            expect(response.status).toBe(400);
+        })
         test("update", async () => {
             // we are going to update the content to be "Updated Post Content"
             const response = await fetch("localhost:8080/post/edit", { method: "PUT", body: JSON.stringify({ ...test_post, content: " Updated Post Content" }), headers });
@@ -141,3 +145,240 @@ describe("posts", () => {
         })
     })
 });
+
+describe("concurrent edits", () => {
+    const concurrent_post = {
+        author_id: 1,
+        slug: "bun-concurrent-post",
+        title: "Bun Concurrent Post",
+        content: "this post should be removed after tests completed.",
+        category: "root"
+    }
+    let created: Post | null = null;
+    let etag: string | null = null;
+
+    test("create", async () => {
+        const response = await fetch("localhost:8080/post/new", { method: "POST", body: JSON.stringify(concurrent_post), headers });
+        expect(response.ok).toBeTrue();
+        etag = response.headers.get("ETag");
+        expect(etag).toBeTruthy();
+        created = (await response.json()) as Post;
+        expect(created.version).toBe(1);
+    })
+    test("edit with current version", async () => {
+        const response = await fetch("localhost:8080/post/edit", { method: "PUT", body: JSON.stringify({ ...created, title: "First Edit" }), headers: { ...headers, "If-Match": etag as string } });
+        expect(response.ok).toBeTrue();
+        const result = (await response.json()) as Post;
+        expect(result.version).toBe(2);
+        expect(response.headers.get("ETag")).not.toBe(etag);
+    })
+    test("edit with unknown category", async () => {
+        const response = await fetch("localhost:8080/post/edit", { method: "PUT", body: JSON.stringify({ ...created, category: "not-a-category" }), headers: { ...headers, "If-Match": "*" } });
+        expect(response.status).toBe(400);
+    })
+    test("edit with stale version", async () => {
+        const response = await fetch("localhost:8080/post/edit", { method: "PUT", body: JSON.stringify({ ...created, title: "Stale Edit" }), headers });
+        expect(response.status).toBe(409);
+        const current = (await response.json()) as Post;
+        expect(current.title).toBe("First Edit");
+        expect(current.version).toBe(2);
+    })
+    afterAll(async () => {
+        const response = await fetch(`localhost:8080/post/delete/${created?.id}`, { method: "DELETE", headers });
+        expect(response.ok).toBeTrue();
+    })
+});
+
+describe("patch", () => {
+    const patch_post = {
+        author_id: 1,
+        slug: "bun-patch-post",
+        title: "Bun Patch Post",
+        content: "this post should be removed after tests completed.",
+        category: "root",
+        tags: ["patch"]
+    }
+    let created: Post | null = null;
+
+    test("create", async () => {
+        const response = await fetch("localhost:8080/post/new", { method: "POST", body: JSON.stringify(patch_post), headers });
+        expect(response.ok).toBeTrue();
+        created = (await response.json()) as Post;
+    })
+    test("archive", async () => {
+        const response = await fetch(`localhost:8080/post/${created?.id}`, { method: "PATCH", body: JSON.stringify({ archived: true }), headers });
+        expect(response.ok).toBeTrue();
+        const result = (await response.json()) as Post;
+        expect(result.archived).toBeTrue();
+        expect(result.title).toBe(patch_post.title);
+        expect(result.tags).toEqual(["patch"]);
+    })
+    test("null removes tags", async () => {
+        const response = await fetch(`localhost:8080/post/${created?.id}`, { method: "PATCH", body: JSON.stringify({ tags: null }), headers });
+        expect(response.ok).toBeTrue();
+        const result = (await response.json()) as Post;
+        expect(result.tags).toEqual([]);
+    })
+    test("invalid fields", async () => {
+        const response = await fetch(`localhost:8080/post/${created?.id}`, { method: "PATCH", body: JSON.stringify({ title: null, author_id: 2, category: "not-a-category" }), headers });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields.map((f: any) => f.field)).toEqual(["author_id", "category", "title"]);
+    })
+    test("taken slug", async () => {
+        const response = await fetch(`localhost:8080/post/${created?.id}`, { method: "PATCH", body: JSON.stringify({ slug: "test-post" }), headers });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields.map((f: any) => f.field)).toEqual(["slug"]);
+    })
+    afterAll(async () => {
+        const response = await fetch(`localhost:8080/post/delete/${created?.id}`, { method: "DELETE", headers });
+        expect(response.ok).toBeTrue();
+    })
+});
+
+describe("bulk", () => {
+    const bulk_posts = [
+        { slug: "bulk-post-1", title: "Bulk Post 1", content: "Lorem Ipsum", category: "coding" },
+        { slug: "bulk-post-2", title: "Bulk Post 2", content: "Lorem Ipsum", category: "coding" },
+    ];
+    const ids: number[] = [];
+
+    test("create", async () => {
+        for (const post of bulk_posts) {
+            const response = await fetch("localhost:8080/post/new", { method: "POST", body: JSON.stringify({ ...post, author_id: 1 }), headers });
+            expect(response.ok).toBeTrue();
+            ids.push(((await response.json()) as Post).id);
+        }
+    })
+    test("set category", async () => {
+        const response = await fetch("localhost:8080/posts/bulk", { method: "POST", body: JSON.stringify({ ids: [...ids, 999999], operation: "set_category", category: "learning" }), headers });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.results.filter((r: any) => r.status == "ok").length).toBe(2);
+        expect(result.results.find((r: any) => r.id == 999999).status).toBe("not_found");
+
+        const post_response = await fetch("localhost:8080/post/bulk-post-1", { method: "GET", headers });
+        expect(((await post_response.json()) as Post).category).toBe("learning");
+    })
+    test("add tags", async () => {
+        const response = await fetch("localhost:8080/posts/bulk", { method: "POST", body: JSON.stringify({ ids, operation: "add_tags", tags: ["bulk"] }), headers });
+        expect(response.ok).toBeTrue();
+        const post_response = await fetch("localhost:8080/post/bulk-post-2", { method: "GET", headers });
+        expect(((await post_response.json()) as Post).tags).toContain("bulk");
+    })
+    test("invalid operation", async () => {
+        const response = await fetch("localhost:8080/posts/bulk", { method: "POST", body: JSON.stringify({ ids, operation: "explode" }), headers });
+        expect(response.status).toBe(400);
+    })
+    test("delete", async () => {
+        const response = await fetch("localhost:8080/posts/bulk", { method: "POST", body: JSON.stringify({ ids, operation: "delete" }), headers });
+        expect(response.ok).toBeTrue();
+        const post_response = await fetch("localhost:8080/post/bulk-post-1", { method: "GET", headers });
+        expect(post_response.status).toBe(404);
+    })
+});
+
+describe("filters", () => {
+    const filter_posts = [
+        { slug: "filter-post-1", title: "Filter Post 1", content: "Lorem Ipsum", category: "coding", tags: ["filter-a", "filter-b"], publish_at: "2020-01-10T00:00:00Z" },
+        { slug: "filter-post-2", title: "Filter Post 2", content: "Lorem Ipsum", category: "gamedev", tags: ["filter-a"], format: "adoc", publish_at: "2020-03-10T00:00:00Z" },
+        { slug: "filter-post-3", title: "Filter Post 3", content: "Lorem Ipsum", category: "hobbies", tags: ["filter-b"], archived: true, publish_at: "2020-05-10T00:00:00Z" },
+    ];
+    const ids: number[] = [];
+    const slugs = async (query: string) => {
+        const response = await fetch(`localhost:8080${query}`, { method: "GET", headers });
+        expect(response.ok).toBeTrue();
+        const result = (await response.json()) as PostsResponse;
+        // the total should match the posts, even with tags joined in
+        expect(result.total_posts).toBe(result.posts.length);
+        return result.posts.map((p) => p.slug).sort();
+    }
+
+    test("create", async () => {
+        for (const post of filter_posts) {
+            const response = await fetch("localhost:8080/post/new", { method: "POST", body: JSON.stringify({ ...post, author_id: 1 }), headers });
+            expect(response.ok).toBeTrue();
+            ids.push(((await response.json()) as Post).id);
+        }
+    })
+    test("tags all", async () => {
+        expect(await slugs("/posts?limit=-1&tags_all=filter-a,filter-b")).toEqual(["filter-post-1"]);
+    })
+    test("tags any", async () => {
+        expect(await slugs("/posts?limit=-1&tags_any=filter-a&tags_any=filter-b")).toEqual(["filter-post-1", "filter-post-2", "filter-post-3"]);
+    })
+    test("tags none", async () => {
+        expect(await slugs("/posts?limit=-1&tags_any=filter-a,filter-b&tags_none=filter-b")).toEqual(["filter-post-2"]);
+    })
+    test("tagged posts keep all their tags", async () => {
+        const response = await fetch("localhost:8080/posts?tag=filter-b&tags_any=filter-a", { method: "GET", headers });
+        const result = (await response.json()) as PostsResponse;
+        expect(result.posts.find((p) => p.slug == "filter-post-1")?.tags.sort()).toEqual(["filter-a", "filter-b"]);
+    })
+    test("category subtree", async () => {
+        expect(await slugs("/posts/coding?limit=-1&tags_any=filter-a,filter-b")).toEqual(["filter-post-1", "filter-post-2"]);
+    })
+    test("archived & format", async () => {
+        expect(await slugs("/posts?limit=-1&tags_any=filter-a,filter-b&archived=true")).toEqual(["filter-post-3"]);
+        expect(await slugs("/posts?limit=-1&tags_any=filter-a,filter-b&format=adoc")).toEqual(["filter-post-2"]);
+    })
+    test("publish date range", async () => {
+        expect(await slugs("/posts?limit=-1&tags_any=filter-a,filter-b&published_after=2020-02-01&published_before=2020-04-01T00:00:00Z")).toEqual(["filter-post-2"]);
+    })
+    test("invalid filters", async () => {
+        const response = await fetch("localhost:8080/posts?archived=maybe&published_after=yesterday", { method: "GET", headers });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields.map((f: any) => f.field)).toEqual(["archived", "published_after"]);
+    })
+    afterAll(async () => {
+        const response = await fetch("localhost:8080/posts/bulk", { method: "POST", body: JSON.stringify({ ids, operation: "delete" }), headers });
+        expect(response.ok).toBeTrue();
+    })
+});
+
+describe("sparse fields", () => {
+    test("summary", async () => {
+        const response = await fetch("localhost:8080/posts?fields=summary", { method: "GET", headers });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.total_posts).toBeGreaterThan(0);
+        for (const post of result.posts) {
+            expect(post.content).toBeUndefined();
+            expect(post.slug).toBeTruthy();
+            expect(post.description).toBeDefined();
+            expect(Array.isArray(post.tags)).toBeTrue();
+        }
+    })
+    test("listings default to the summary", async () => {
+        const response = await fetch("localhost:8080/posts", { method: "GET", headers });
+        const result = await response.json();
+        expect(Object.keys(result.posts[0]).sort()).toEqual(["archived", "category", "description", "id", "publish_at", "slug", "tags", "title", "updated_at"]);
+    })
+    test("all fields", async () => {
+        const response = await fetch("localhost:8080/posts?fields=all", { method: "GET", headers });
+        const result = await response.json();
+        expect(result.posts[0].content).toBeDefined();
+        expect(result.posts[0].author_id).toBeDefined();
+    })
+    test("listed fields", async () => {
+        const response = await fetch("localhost:8080/posts?fields=title,tags", { method: "GET", headers });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        // the id is always included
+        expect(Object.keys(result.posts[0]).sort()).toEqual(["id", "tags", "title"]);
+    })
+    test("single post", async () => {
+        const response = await fetch("localhost:8080/post/test-post?fields=title", { method: "GET", headers });
+        expect(response.ok).toBeTrue();
+        expect(response.headers.get("ETag")).toBeTruthy();
+        expect(Object.keys(await response.json()).sort()).toEqual(["id", "title"]);
+    })
+    test("unknown field", async () => {
+        const response = await fetch("localhost:8080/posts?fields=title,not_a_field", { method: "GET", headers });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields[0].field).toBe("fields");
+    })
+});
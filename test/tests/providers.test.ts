@@ -0,0 +1,128 @@
+import { expect, test, describe, beforeAll, afterAll } from "bun:test";
+import type { Server } from "bun";
+import { AUTH_HEADERS, cookie, providers } from "user";
+
+// the oidc tests need the server started with a provider pointing at the mock below:
+// OIDC_CLIENT=blog OIDC_SECRET=secret OIDC_ISSUER=http://localhost:8099
+const ISSUER = "http://localhost:8099";
+const oidc = providers.includes("oidc");
+
+// a minimal OIDC provider, that checks the PKCE verifier of the code it handed out
+const challenges = new Map<string, string>();
+let subject = "mock-user";
+let server: Server;
+
+const challengeOf = async (verifier: string) => {
+    const digest = new Uint8Array(await crypto.subtle.digest("SHA-256", new TextEncoder().encode(verifier)));
+    return Buffer.from(digest).toString("base64url");
+}
+
+beforeAll(() => {
+    if (!oidc) return;
+    server = Bun.serve({
+        port: 8099,
+        async fetch(request) {
+            const url = new URL(request.url);
+            switch (url.pathname) {
+                case "/.well-known/openid-configuration":
+                    return Response.json({ issuer: ISSUER, authorization_endpoint: `${ISSUER}/authorize`, token_endpoint: `${ISSUER}/token`, userinfo_endpoint: `${ISSUER}/userinfo` });
+                case "/authorize": {
+                    const code = crypto.randomUUID();
+                    challenges.set(code, url.searchParams.get("code_challenge") as string);
+                    const callback = new URL(url.searchParams.get("redirect_uri") as string);
+                    callback.searchParams.set("code", code);
+                    callback.searchParams.set("state", url.searchParams.get("state") as string);
+                    return Response.redirect(callback.toString(), 302);
+                }
+                case "/token": {
+                    const body = new URLSearchParams(await request.text());
+                    if (challenges.get(body.get("code") as string) !== await challengeOf(body.get("code_verifier") ?? "")) {
+                        return Response.json({ error: "invalid_grant" }, { status: 400 });
+                    }
+                    return Response.json({ access_token: `token-${subject}`, token_type: "Bearer" });
+                }
+                case "/userinfo": {
+                    const token = request.headers.get("Authorization")?.replace("Bearer token-", "");
+                    return Response.json({ sub: token, preferred_username: token, email: `${token}@example.com` });
+                }
+            }
+            return new Response("not found", { status: 404 });
+        }
+    });
+});
+
+afterAll(() => server?.stop());
+
+// login runs the redirects of a login, returning the callback's response
+const login = async (query = "", session = "") => {
+    const start = await fetch(`localhost:8080/auth/oidc/login${query}`, { redirect: "manual", headers: { Cookie: session } });
+    expect(start.status).toBe(307);
+    const authorize = await fetch(start.headers.get("Location") as string, { redirect: "manual" });
+    const callback = authorize.headers.get("Location") as string;
+    return fetch(callback, { redirect: "manual", headers: { Cookie: [session, cookie(start, "oauth-state")].filter(Boolean).join("; ") } });
+}
+
+describe("login providers", () => {
+    test("list", async () => {
+        expect(providers).toContain("github");
+    });
+    test("unknown provider", async () => {
+        const response = await fetch("localhost:8080/auth/unknown/login", { redirect: "manual" });
+        expect(response.status).toBe(404);
+    });
+    test("identities", async () => {
+        const response = await fetch("localhost:8080/auth/identities", { headers: AUTH_HEADERS });
+        expect(response.ok).toBeTrue();
+        const identities = await response.json();
+        expect(identities.map((i: any) => i.provider)).toContain("github");
+    });
+    test("can't remove the last identity", async () => {
+        const identities = await (await fetch("localhost:8080/auth/identities", { headers: AUTH_HEADERS })).json();
+        if (identities.length != 1) return;
+        const response = await fetch(`localhost:8080/auth/identities/${identities[0].id}`, { method: "DELETE", headers: AUTH_HEADERS });
+        expect(response.status).toBe(409);
+    });
+});
+
+describe("oidc login", () => {
+    test.skipIf(!oidc)("creates a user", async () => {
+        subject = "oidc-user";
+        const callback = await login();
+        expect(callback.status).toBe(303);
+        const session = cookie(callback);
+        const user = await (await fetch("localhost:8080/auth/user", { headers: { Cookie: session } })).json();
+        expect(user.username).toStartWith("oidc-user");
+        expect(user.github_id).toBeNull();
+
+        // logging in again is the same user
+        const again = await login();
+        const same = await (await fetch("localhost:8080/auth/user", { headers: { Cookie: cookie(again) } })).json();
+        expect(same.user_id).toBe(user.user_id);
+    });
+    test.skipIf(!oidc)("cleans up the provider's username", async () => {
+        subject = "Oidc User/#1";
+        const session = cookie(await login());
+        const user = await (await fetch("localhost:8080/auth/user", { headers: { Cookie: session } })).json();
+        expect(user.username).toMatch(/^Oidc-User-1(-\d+)?$/);
+    });
+    test.skipIf(!oidc)("links a second provider", async () => {
+        subject = "oidc-linker";
+        const session = cookie(await login());
+        subject = "oidc-linked";
+        const callback = await login("?link=true", session);
+        expect(callback.status).toBe(303);
+
+        const identities = await (await fetch("localhost:8080/auth/identities", { headers: { Cookie: session } })).json();
+        expect(identities.map((i: any) => i.subject)).toEqual(["oidc-linker", "oidc-linked"]);
+        const removed = await fetch(`localhost:8080/auth/identities/${identities[1].id}`, { method: "DELETE", headers: { Cookie: session } });
+        expect(removed.ok).toBeTrue();
+    });
+    test.skipIf(!oidc)("rejects a mismatched state", async () => {
+        const start = await fetch("localhost:8080/auth/oidc/login", { redirect: "manual" });
+        const authorize = await fetch(start.headers.get("Location") as string, { redirect: "manual" });
+        const callback = new URL(authorize.headers.get("Location") as string);
+        callback.searchParams.set("state", "forged");
+        const response = await fetch(callback.toString(), { redirect: "manual", headers: { Cookie: cookie(start, "oauth-state") } });
+        expect(response.status).toBe(400);
+    });
+});
@@ -0,0 +1,70 @@
+import { expect, test, describe, afterAll } from "bun:test";
+import { AUTH_HEADERS, cookie, localAccount, providers, register } from "user";
+import { mailSink } from "mail";
+
+// the session tests log in to a local account, so they need the server started with AUTH_PASSWORD=true
+const password = providers.includes("password");
+const account = localAccount("sessions");
+const mail = mailSink();
+afterAll(() => mail.stop());
+
+// login returns the session cookie of a new login
+const login = async (agent: string) => {
+    const response = await fetch("localhost:8080/auth/password/login", {
+        method: "POST",
+        headers: { "Content-Type": "application/json", "User-Agent": agent },
+        body: JSON.stringify({ email: account.email, password: account.password }),
+    });
+    expect(response.ok).toBeTrue();
+    return cookie(response);
+}
+
+const sessions = async (session: string) => (await fetch("localhost:8080/auth/sessions", { headers: { Cookie: session } })).json();
+const loggedIn = async (session: string) => (await fetch("localhost:8080/auth/user", { headers: { Cookie: session } })).ok;
+
+describe("sessions", () => {
+    test("list with a token", async () => {
+        const response = await fetch("localhost:8080/auth/sessions", { headers: AUTH_HEADERS });
+        expect(response.ok).toBeTrue();
+        const list = await response.json();
+        expect(list.every((s: any) => !s.current)).toBeTrue();
+    });
+    test("can't revoke another user's session", async () => {
+        const response = await fetch("localhost:8080/auth/sessions/999999", { method: "DELETE", headers: AUTH_HEADERS });
+        expect(response.status).toBe(404);
+    });
+    test("garbage cookie is logged out", async () => {
+        expect(await loggedIn("user-session=garbage")).toBeFalse();
+    });
+    test.skipIf(!password)("list & revoke", async () => {
+        expect((await register(mail, account)).status).toBe(303);
+
+        const first = await login("first browser");
+        const second = await login("second browser");
+        const list = await sessions(first);
+        expect(list.length).toBe(3);
+        expect(list.filter((s: any) => s.current).map((s: any) => s.user_agent)).toEqual(["first browser"]);
+
+        const other = list.find((s: any) => s.user_agent == "second browser");
+        const revoked = await fetch(`localhost:8080/auth/sessions/${other.id}`, { method: "DELETE", headers: { Cookie: first } });
+        expect(revoked.ok).toBeTrue();
+        expect(await loggedIn(second)).toBeFalse();
+        expect(await loggedIn(first)).toBeTrue();
+    });
+    test.skipIf(!password)("revoke others", async () => {
+        const first = await login("first browser");
+        const second = await login("second browser");
+        const response = await fetch("localhost:8080/auth/sessions?others=true", { method: "DELETE", headers: { Cookie: first } });
+        expect(response.ok).toBeTrue();
+        expect((await response.json()).deleted).toBeGreaterThan(0);
+        expect(await loggedIn(second)).toBeFalse();
+        expect(await sessions(first)).toHaveLength(1);
+    });
+    test.skipIf(!password)("logout only ends the current session", async () => {
+        const first = await login("first browser");
+        const second = await login("second browser");
+        await fetch("localhost:8080/auth/logout", { headers: { Cookie: first }, redirect: "manual" });
+        expect(await loggedIn(first)).toBeFalse();
+        expect(await loggedIn(second)).toBeTrue();
+    });
+});
@@ -1,4 +1,5 @@
-import { beforeAll, expect, test, describe } from "bun:test";
+import { SCHEMA, Tag } from "@client/schema";
+import { afterAll, beforeAll, expect, test, describe } from "bun:test";
 import { AUTH_HEADERS } from "user";
 
 const test_post = {
@@ -45,11 +46,16 @@ describe("tags", () => {
         const response = await fetch("localhost:8080/tags", { method: "GET", headers });
         expect(response).toBeTruthy();
         expect(response.ok).toBeTrue();
-        const result = await response.json() as string[];
+        const result = SCHEMA.TAGS.parse(await response.json()) as Tag[];
+        const names = result.map((t) => t.name);
         for (const tag of tags) {
-            expect(result).toContain(tag)
+            expect(names).toContain(tag)
         }
-        expect(result).toContain("test-tag");
+        expect(names).toContain("test-tag");
+        const test_tag = result.find((t) => t.name == "test-tag");
+        expect(test_tag?.count).toBe(1);
+        expect(test_tag?.first_used).toBeTruthy();
+        expect(test_tag?.last_used).toBeTruthy();
     })
     test("get tagged posts", async () => {
         const response = await fetch("localhost:8080/posts?tag=test-tag", { method: "GET", headers });
@@ -66,8 +72,8 @@ describe("tags", () => {
         expect(response.ok).toBeTrue();
         // there should no longer be a "test-tag" in the tags
         const check = await fetch("localhost:8080/tags", { method: "GET", headers });
-        const check_result = await check.json();
-        expect(check_result).not.toContain("test-tag");
+        const check_result = SCHEMA.TAGS.parse(await check.json()) as Tag[];
+        expect(check_result.map((t) => t.name)).not.toContain("test-tag");
     })
     test("unauthorized delete tag", async () => {
         const response = await fetch(`localhost:8080/post/tag?id=${test_id}&tag=test-tag`, { method: "DELETE" });
@@ -77,3 +83,115 @@ describe("tags", () => {
         expect(response.statusText).toBe("Unauthorized");
     });
 })
+
+describe("tag management", () => {
+    test("rename preview", async () => {
+        const response = await fetch(`localhost:8080/tag/tag-1?preview=true`, { method: "PUT", headers, body: JSON.stringify({ name: "renamed-tag" }) });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.preview).toBeTrue();
+        expect(result.affected_posts).toBe(1);
+        expect(result.post_ids).toContain(test_id);
+        // nothing should have changed
+        const check = (await (await fetch("localhost:8080/tags", { method: "GET", headers })).json()).map((t: Tag) => t.name);
+        expect(check).toContain("tag-1");
+    })
+    test("rename", async () => {
+        const response = await fetch(`localhost:8080/tag/tag-1`, { method: "PUT", headers, body: JSON.stringify({ name: "renamed-tag" }) });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.affected_posts).toBe(1);
+        const check = (await (await fetch("localhost:8080/tags", { method: "GET", headers })).json()).map((t: Tag) => t.name);
+        expect(check).toContain("renamed-tag");
+        expect(check).not.toContain("tag-1");
+    })
+    test("metadata", async () => {
+        const response = await fetch(`localhost:8080/tag/renamed-tag`, { method: "PATCH", headers, body: JSON.stringify({ description: "A renamed tag", colour: "#1E90FF" }) });
+        expect(response.ok).toBeTrue();
+        const result = SCHEMA.TAGS.element.parse(await response.json()) as Tag;
+        expect(result.description).toBe("A renamed tag");
+        expect(result.colour).toBe("#1e90ff");
+        expect(result.slug).toBe("renamed-tag");
+    })
+    test("invalid metadata", async () => {
+        const response = await fetch(`localhost:8080/tag/renamed-tag`, { method: "PATCH", headers, body: JSON.stringify({ colour: "blue", slug: "Not A Slug" }) });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields.map((f: any) => f.field)).toEqual(["colour", "slug"]);
+    })
+    test("tag cloud", async () => {
+        const response = await fetch(`localhost:8080/tags?cloud=true`, { method: "GET", headers });
+        const result = SCHEMA.TAGS.parse(await response.json()) as Tag[];
+        for (const tag of result) {
+            expect(tag.weight).toBeGreaterThanOrEqual(0);
+            expect(tag.weight).toBeLessThanOrEqual(1);
+        }
+    })
+    test("merge", async () => {
+        const response = await fetch(`localhost:8080/tags/merge`, { method: "POST", headers, body: JSON.stringify({ tags: ["tag-2", "tag-3"], into: "renamed-tag" }) });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.affected_posts).toBe(1);
+        const post = await (await fetch(`localhost:8080/post/${test_post.slug}`, { method: "GET", headers })).json();
+        expect(post.tags).toEqual(["renamed-tag"]);
+    })
+    test("invalid merge", async () => {
+        const response = await fetch(`localhost:8080/tags/merge`, { method: "POST", headers, body: JSON.stringify({ tags: [] }) });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields.map((f: any) => f.field)).toEqual(["tags", "into"]);
+    })
+    test("delete", async () => {
+        const response = await fetch(`localhost:8080/tag/renamed-tag`, { method: "DELETE", headers });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.affected_posts).toBe(1);
+        const check = (await (await fetch("localhost:8080/tags", { method: "GET", headers })).json()).map((t: Tag) => t.name);
+        expect(check).not.toContain("renamed-tag");
+    })
+})
+
+describe("tag suggestions", () => {
+    const suggest_posts = [
+        { slug: "suggest-post-1", title: "Goroutines in practice", content: "goroutines and channels make servers concurrent", tags: ["suggest-go", "suggest-backend"] },
+        { slug: "suggest-post-2", title: "Watercolour basics", content: "brushes paper and pigments", tags: ["suggest-art"] },
+    ];
+    const ids: number[] = [];
+
+    beforeAll(async () => {
+        for (const post of suggest_posts) {
+            const response = await fetch("localhost:8080/post/new", { method: "POST", body: JSON.stringify({ ...post, author_id: 1, category: "root" }), headers });
+            expect(response.ok).toBeTrue();
+            ids.push((await response.json()).id);
+        }
+    });
+
+    test("suggest from content", async () => {
+        const response = await fetch("localhost:8080/tags/suggest", { method: "POST", headers, body: JSON.stringify({ title: "More goroutines", content: "channels between goroutines" }) });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        const tags = result.map((s: any) => s.tag);
+        expect(tags).toContain("suggest-go");
+        expect(tags.indexOf("suggest-go")).toBeLessThan(tags.indexOf("suggest-art") == -1 ? Infinity : tags.indexOf("suggest-art"));
+        for (let i = 1; i < result.length; i++) {
+            expect(result[i - 1].score).toBeGreaterThanOrEqual(result[i].score);
+        }
+    })
+    test("co-occurring tags", async () => {
+        const response = await fetch("localhost:8080/tags/suggest", { method: "POST", headers, body: JSON.stringify({ content: "", tags: ["suggest-go"], limit: 20 }) });
+        const result = await response.json();
+        const backend = result.find((s: any) => s.tag == "suggest-backend");
+        expect(backend.co_occurrence).toBe(1);
+        // tags already on the draft aren't suggested again
+        expect(result.map((s: any) => s.tag)).not.toContain("suggest-go");
+    })
+    test("invalid limit", async () => {
+        const response = await fetch("localhost:8080/tags/suggest", { method: "POST", headers, body: JSON.stringify({ content: "", limit: 100 }) });
+        expect(response.status).toBe(400);
+    })
+
+    afterAll(async () => {
+        const response = await fetch("localhost:8080/posts/bulk", { method: "POST", body: JSON.stringify({ ids, operation: "delete" }), headers });
+        expect(response.ok).toBeTrue();
+    })
+});
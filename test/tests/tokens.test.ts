@@ -1,5 +1,6 @@
-import { expect, test, describe } from "bun:test";
-import { AUTH_HEADERS } from "user";
+import { expect, test, describe, afterAll } from "bun:test";
+import { AUTH_HEADERS, cookie, localAccount, providers, register } from "user";
+import { mailSink } from "mail";
 
 const test_token = {
     user_id: 1,
@@ -17,6 +18,7 @@ describe("tokens", () => {
         const result = await response.json();
         expect(result).toBeTruthy();
         expect(result.id).toBeTruthy();
+        expect(result.value).toStartWith(result.prefix);
         token_id = result.id;
     })
     test("update", async () => {
@@ -31,6 +33,8 @@ describe("tokens", () => {
         const result = await response.json();
         expect(result).toBeTruthy();
         expect(Object.values(result).map((r: any) => r.name)).toContain("Updated Token");
+        // values are only shown once
+        expect(Object.values(result).every((r: any) => r.value === undefined && r.prefix)).toBeTrue();
     })
     test("delete", async () => {
         const response = await fetch(`localhost:8080/token/delete/${token_id}`, { method: "DELETE", headers: AUTH_HEADERS })
@@ -48,3 +52,147 @@ describe("tokens", () => {
         expect(response.ok).toBeFalse();
     })
 });
+
+describe("token scopes", () => {
+    let scoped: { id: number, value: string } | null = null;
+    const scoped_headers = () => ({ "Auth-Token": scoped!.value });
+
+    test("create scoped", async () => {
+        const response = await fetch("localhost:8080/token/new", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_token, name: "Read Only", scopes: ["posts:read"] }) });
+        expect(response.ok).toBeTrue();
+        const result = await response.json();
+        expect(result.scopes).toEqual(["posts:read"]);
+        scoped = result;
+    })
+    test("full scope by default", async () => {
+        const response = await fetch("localhost:8080/tokens", { method: "GET", headers: AUTH_HEADERS });
+        const result = await response.json();
+        expect(result.find((t: any) => t.prefix == "test123k").scopes).toContain("tokens:admin");
+    })
+    test("unknown scope", async () => {
+        const response = await fetch("localhost:8080/token/new", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_token, scopes: ["posts:read", "everything"] }) });
+        expect(response.status).toBe(400);
+        const result = await response.json();
+        expect(result.fields[0].field).toBe("scopes[1]");
+    })
+    test("allowed route", async () => {
+        const response = await fetch("localhost:8080/posts", { method: "GET", headers: scoped_headers() });
+        expect(response.ok).toBeTrue();
+    })
+    test("forbidden routes", async () => {
+        const remove = await fetch("localhost:8080/post/delete/1", { method: "DELETE", headers: scoped_headers() });
+        expect(remove.status).toBe(403);
+        const mint = await fetch("localhost:8080/token/new", { method: "POST", headers: scoped_headers(), body: JSON.stringify(test_token) });
+        expect(mint.status).toBe(403);
+    })
+    test("graphql fields", async () => {
+        const response = await fetch("localhost:8080/graphql", { method: "POST", headers: scoped_headers(), body: JSON.stringify({ query: "{ tokens { id } }" }) });
+        const result = await response.json();
+        expect(result.errors[0].message).toContain("tokens:admin");
+    })
+    test("edit keeps scopes", async () => {
+        const response = await fetch("localhost:8080/token/edit", { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_token, id: scoped!.id, name: "Still Read Only" }) });
+        expect(response.ok).toBeTrue();
+        const tokens = await (await fetch("localhost:8080/tokens", { method: "GET", headers: AUTH_HEADERS })).json();
+        expect(tokens.find((t: any) => t.id == scoped!.id).scopes).toEqual(["posts:read"]);
+        await fetch(`localhost:8080/token/delete/${scoped!.id}`, { method: "DELETE", headers: AUTH_HEADERS });
+    })
+});
+
+describe("token lifecycle", () => {
+    const create = async (token: object) => (await fetch("localhost:8080/token/new", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_token, ...token }) })).json();
+    const remove = (id: number) => fetch(`localhost:8080/token/delete/${id}`, { method: "DELETE", headers: AUTH_HEADERS });
+    const posts = (value: string) => fetch("localhost:8080/posts", { method: "GET", headers: { "Auth-Token": value } });
+
+    test("past expiry", async () => {
+        const response = await fetch("localhost:8080/token/new", { method: "POST", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_token, expires_at: "2020-01-01T00:00:00Z" }) });
+        expect(response.status).toBe(400);
+    })
+    test("disabled", async () => {
+        const token = await create({ enabled: false });
+        expect((await posts(token.value)).status).toBe(401);
+        await remove(token.id);
+    })
+    test("last used", async () => {
+        const token = await create({ expires_at: "2099-01-01T00:00:00Z" });
+        expect(token.last_used_at).toBeNull();
+        expect((await posts(token.value)).ok).toBeTrue();
+        const tokens = await (await fetch("localhost:8080/tokens", { method: "GET", headers: AUTH_HEADERS })).json();
+        const used = tokens.find((t: any) => t.id == token.id);
+        expect(used.last_used_at).toBeTruthy();
+        expect(used.last_used_ip).toBeTruthy();
+        await remove(token.id);
+    })
+    test("edit keeps expiry", async () => {
+        const token = await create({ expires_at: "2099-01-01T00:00:00Z" });
+        const edit = (body: object) => fetch("localhost:8080/token/edit", { method: "PUT", headers: AUTH_HEADERS, body: JSON.stringify({ ...test_token, id: token.id, ...body }) });
+        const expiry = async () => (await (await fetch("localhost:8080/tokens", { method: "GET", headers: AUTH_HEADERS })).json()).find((t: any) => t.id == token.id).expires_at;
+
+        expect((await edit({ name: "Renamed Token" })).ok).toBeTrue();
+        expect(await expiry()).toStartWith("2099-01-01");
+        expect((await edit({ expires_at: null })).ok).toBeTrue();
+        expect(await expiry()).toBeNull();
+        await remove(token.id);
+    })
+    test("rotate", async () => {
+        const token = await create({});
+        const response = await fetch(`localhost:8080/token/rotate/${token.id}`, { method: "POST", headers: AUTH_HEADERS });
+        expect(response.ok).toBeTrue();
+        const rotated = await response.json();
+        expect(rotated.value).not.toBe(token.value);
+        expect(rotated.previous_expires_at).toBeTruthy();
+        expect((await posts(rotated.value)).ok).toBeTrue();
+        // the old value works during the grace period
+        expect((await posts(token.value)).ok).toBeTrue();
+
+        const immediate = await (await fetch(`localhost:8080/token/rotate/${token.id}?grace=0`, { method: "POST", headers: AUTH_HEADERS })).json();
+        expect((await posts(rotated.value)).status).toBe(401);
+        expect((await posts(immediate.value)).ok).toBeTrue();
+        await remove(token.id);
+    })
+    test("rotate can't escalate scopes", async () => {
+        const admin = await create({ scopes: ["tokens:admin"] });
+        const full = await create({});
+        const response = await fetch(`localhost:8080/token/rotate/${full.id}`, { method: "POST", headers: { "Auth-Token": admin.value } });
+        expect(response.status).toBe(403);
+        // the full token still has its value
+        expect((await posts(full.value)).ok).toBeTrue();
+
+        const own = await fetch(`localhost:8080/token/rotate/${admin.id}`, { method: "POST", headers: { "Auth-Token": admin.value } });
+        expect(own.ok).toBeTrue();
+        await remove(admin.id);
+        await remove(full.id);
+    })
+    test("edit can't escalate scopes", async () => {
+        const admin = await create({ scopes: ["tokens:admin"] });
+        const full = await create({});
+        const response = await fetch("localhost:8080/token/edit", { method: "PUT", headers: { "Auth-Token": admin.value }, body: JSON.stringify({ ...test_token, id: full.id, enabled: false }) });
+        expect(response.status).toBe(403);
+        expect((await posts(full.value)).ok).toBeTrue();
+        await remove(admin.id);
+        await remove(full.id);
+    })
+    test("rotate invalid", async () => {
+        const grace = await fetch("localhost:8080/token/rotate/1?grace=-1", { method: "POST", headers: AUTH_HEADERS });
+        expect(grace.status).toBe(400);
+        const missing = await fetch("localhost:8080/token/rotate/99999", { method: "POST", headers: AUTH_HEADERS });
+        expect(missing.status).toBe(404);
+    })
+});
+
+// another user's token needs a second account, so these need the server started with AUTH_PASSWORD=true
+describe("token owners", () => {
+    const mail = mailSink();
+    afterAll(() => mail.stop());
+
+    test.skipIf(!providers.includes("password"))("can't delete another user's token", async () => {
+        const session = cookie(await register(mail, localAccount("tokens")));
+        const user = await (await fetch("localhost:8080/auth/user", { headers: { Cookie: session } })).json();
+        const token = await (await fetch("localhost:8080/token/new", { method: "POST", headers: { Cookie: session }, body: JSON.stringify({ ...test_token, user_id: user.user_id }) })).json();
+
+        const response = await fetch(`localhost:8080/token/delete/${token.id}`, { method: "DELETE", headers: AUTH_HEADERS });
+        expect(response.status).toBe(404);
+        const own = await fetch(`localhost:8080/token/delete/${token.id}`, { method: "DELETE", headers: { Cookie: session } });
+        expect(own.ok).toBeTrue();
+    })
+});
@@ -1,2 +1,33 @@
+import type { mailSink } from "mail";
+
 export const API_TOKEN = "test123key"
 export const AUTH_HEADERS = { 'Auth-Token': API_TOKEN };
+
+// the login providers the server was started with, local accounts are "password" with AUTH_PASSWORD=true
+export const providers: string[] = await (await fetch("localhost:8080/auth/providers")).json();
+
+// cookie returns a cookie set by a response, ready for a Cookie header
+export const cookie = (response: Response, name = "user-session") => response.headers.getSetCookie().map((c) => c.split(";")[0]).find((c) => c.startsWith(`${name}=`)) ?? "";
+
+// unique per run, so the tests can be run against the same database again
+export const suffix = Date.now().toString(36);
+export const localAccount = (name: string) => ({ username: `${name}-${suffix}`, email: `${name}-${suffix}@example.com`, password: "correct horse battery" });
+
+// useLoginLink posts the token of an emailed login link, like the page the link opens does
+export const useLoginLink = (token: string) => fetch("localhost:8080/auth/email/callback", {
+    method: "POST",
+    headers: { "Content-Type": "application/x-www-form-urlencoded" },
+    body: new URLSearchParams({ token }),
+    redirect: "manual",
+});
+
+// register signs up a local account with the link emailed to confirm it, returning the response of using the link
+export const register = async (mail: ReturnType<typeof mailSink>, account: { username: string, email: string, password: string }, invite?: string) => {
+    const response = await fetch("localhost:8080/auth/register", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ ...account, invite }),
+    });
+    if (response.status != 202) return response;
+    return useLoginLink(await mail.token(account.email, "/auth/email/callback"));
+}